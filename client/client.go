@@ -0,0 +1,368 @@
+// Package client talks to a guest agent from the host. It sends QMP requests
+// over any byte stream, matches responses to requests by ID, and provides a
+// typed method for each command the agent implements.
+//
+// A new client performs the guest-sync-delimited handshake before it is
+// returned: it writes a 0xFF byte to flush any partial request the agent may
+// be holding, discards everything the agent sends until the 0xFF that
+// precedes the handshake response, and checks that the response echoes the
+// random ID it sent. The handshake is repeated before the next command after
+// a command times out, so a late response cannot be mistaken for a new one.
+//
+// The agent answers a request it could not parse, such as one larger than
+// its message size limit, with an error that carries no ID. Such an error
+// cannot be matched to the request that caused it, so it is returned by
+// every command waiting for a response at the time. Responses to those
+// commands that arrive later are discarded.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mac-guest-agent/protocol"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the deadline of a command whose context has none.
+const DefaultTimeout = 30 * time.Second
+
+// resyncByte precedes the guest-sync-delimited response and flushes the
+// agent's input. It cannot occur in valid UTF-8 JSON.
+const resyncByte = 0xFF
+
+// ErrClosed is returned by commands issued after the client was closed.
+var ErrClosed = errors.New("client is closed")
+
+// Error is a QMP error returned by the agent.
+type Error struct {
+	// Command is the command that failed.
+	Command string
+
+	protocol.QMPError
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Command, e.Class, e.Desc)
+}
+
+// Config describes how a Client behaves.
+type Config struct {
+	// Timeout bounds each command whose context has no deadline. Zero
+	// selects DefaultTimeout.
+	Timeout time.Duration
+
+	// SkipSync disables the handshake when the client is created. The
+	// handshake still runs after a command times out.
+	SkipSync bool
+}
+
+// reply is the outcome of a request, as read from the connection.
+type reply struct {
+	result json.RawMessage
+	err    *protocol.QMPError
+}
+
+// Client is a connection to a guest agent. It is safe for concurrent use;
+// the agent may answer concurrent commands out of order.
+type Client struct {
+	conn   io.ReadWriteCloser
+	config Config
+
+	// syncMutex is held for writing during the handshake and for reading
+	// by every other command, so no response is discarded while the
+	// client waits for the handshake delimiter.
+	syncMutex  sync.RWMutex
+	writeMutex sync.Mutex
+
+	mutex     sync.Mutex
+	nextID    uint64
+	pending   map[uint64]chan reply
+	needSync  bool
+	delimited bool
+	err       error
+	done      chan struct{}
+}
+
+// New creates a client that talks to an agent over conn and performs the
+// handshake unless config.SkipSync is set. The client owns conn and closes
+// it when the client is closed or the handshake fails.
+func New(ctx context.Context, conn io.ReadWriteCloser, config Config) (*Client, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	c := &Client{
+		conn:    conn,
+		config:  config,
+		pending: make(map[uint64]chan reply),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+
+	if !config.SkipSync {
+		if err := c.Sync(ctx); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close closes the connection. Commands in progress fail with ErrClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return c.conn.Close()
+}
+
+// Done returns a channel that is closed when the connection is lost or the
+// client is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client stopped, or nil while it is usable.
+func (c *Client) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// Sync performs the guest-sync-delimited handshake. Responses to earlier
+// commands that arrive before the handshake response are discarded.
+func (c *Client) Sync(ctx context.Context) error {
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	c.mutex.Lock()
+	c.delimited = true
+	c.mutex.Unlock()
+
+	if err := c.write(ctx, []byte{resyncByte}); err != nil {
+		return err
+	}
+
+	id := rand.Int31()
+	var echoed int64
+	if err := c.call(ctx, "guest-sync-delimited", protocol.SyncArgs{ID: int64(id)}, &echoed); err != nil {
+		return err
+	}
+	if echoed != int64(id) {
+		return fmt.Errorf("guest-sync-delimited returned %d, expected %d", echoed, id)
+	}
+
+	c.mutex.Lock()
+	c.needSync = false
+	c.mutex.Unlock()
+	return nil
+}
+
+// Call runs a command. args is marshalled as the command's arguments and
+// may be nil; the result is unmarshalled into result unless it is nil.
+// A QMP error is returned as an *Error.
+func (c *Client) Call(ctx context.Context, command string, args, result interface{}) error {
+	c.mutex.Lock()
+	needSync := c.needSync
+	c.mutex.Unlock()
+	if needSync {
+		if err := c.Sync(ctx); err != nil {
+			return err
+		}
+	}
+
+	c.syncMutex.RLock()
+	defer c.syncMutex.RUnlock()
+	return c.call(ctx, command, args, result)
+}
+
+// call sends a request and waits for its response.
+func (c *Client) call(ctx context.Context, command string, args, result interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	c.mutex.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mutex.Unlock()
+		return err
+	}
+	c.nextID++
+	id := c.nextID
+	replies := make(chan reply, 1)
+	c.pending[id] = replies
+	c.mutex.Unlock()
+
+	data, err := json.Marshal(protocol.QMPRequest{Execute: command, Arguments: args, ID: id})
+	if err != nil {
+		c.forget(id)
+		return fmt.Errorf("%s: %v", command, err)
+	}
+	if err := c.write(ctx, append(data, '\n')); err != nil {
+		// Part of the request may have been written
+		c.forget(id)
+		c.setNeedSync()
+		return fmt.Errorf("%s: %w", command, err)
+	}
+
+	select {
+	case r := <-replies:
+		if r.err != nil {
+			return &Error{Command: command, QMPError: *r.err}
+		}
+		if result == nil || len(r.result) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(r.result, result); err != nil {
+			return fmt.Errorf("%s: invalid result: %v", command, err)
+		}
+		return nil
+	case <-ctx.Done():
+		// The response may still arrive; resynchronise before the next
+		// command so it is not read as that command's response.
+		c.forget(id)
+		c.setNeedSync()
+		return fmt.Errorf("%s: %w", command, ctx.Err())
+	case <-c.done:
+		return fmt.Errorf("%s: %w", command, c.Err())
+	}
+}
+
+// withTimeout applies the default timeout to a context without a deadline.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.config.Timeout)
+}
+
+// forget stops waiting for the response to a request.
+func (c *Client) forget(id uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.pending, id)
+}
+
+// setNeedSync makes the next command perform the handshake first.
+func (c *Client) setNeedSync() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.needSync = true
+}
+
+// write writes data to the connection, honouring the deadline of ctx when
+// the connection supports write deadlines.
+func (c *Client) write(ctx context.Context, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if conn, ok := c.conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		deadline, _ := ctx.Deadline()
+		conn.SetWriteDeadline(deadline)
+	}
+	for len(data) > 0 {
+		n, err := c.conn.Write(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// readLoop reads newline-terminated responses until the connection fails.
+func (c *Client) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			c.fail(fmt.Errorf("connection lost: %w", err))
+			return
+		}
+		c.handleLine(line)
+	}
+}
+
+// handleLine delivers a response to the command waiting for it. An error
+// without an ID is delivered to every pending command. Other lines that are
+// not responses, or answer no pending command, are dropped.
+func (c *Client) handleLine(line []byte) {
+	delimiter := bytes.LastIndexByte(line, resyncByte)
+	if delimiter >= 0 {
+		line = line[delimiter+1:]
+	}
+
+	c.mutex.Lock()
+	if c.delimited && delimiter < 0 {
+		// Output from before the handshake
+		c.mutex.Unlock()
+		return
+	}
+	c.delimited = false
+	c.mutex.Unlock()
+
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var result, rawID json.RawMessage
+	resp := protocol.QMPResponse{Return: &result, ID: &rawID}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return
+	}
+	if len(rawID) == 0 || string(rawID) == "null" {
+		if resp.Error != nil {
+			c.failPending(*resp.Error)
+		}
+		return
+	}
+	id, err := strconv.ParseUint(string(rawID), 10, 64)
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	replies, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mutex.Unlock()
+	if ok {
+		replies <- reply{result: result, err: resp.Error}
+	}
+}
+
+// failPending delivers an error that names no request to every command
+// waiting for a response.
+func (c *Client) failPending(qmpErr protocol.QMPError) {
+	c.mutex.Lock()
+	pending := c.pending
+	c.pending = make(map[uint64]chan reply)
+	c.mutex.Unlock()
+
+	for _, replies := range pending {
+		replies <- reply{err: &qmpErr}
+	}
+}
+
+// fail stops the client; the first error is kept.
+func (c *Client) fail(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mac-guest-agent/internal/agent"
+	"mac-guest-agent/internal/commands"
+	"mac-guest-agent/internal/transport"
+	"mac-guest-agent/protocol"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startAgent runs an agent in the test process and returns a client
+// connected to it through Pipe.
+func startAgent(t *testing.T, agentConfig agent.Config) *Client {
+	t.Helper()
+	host, guest := Pipe()
+	agentConfig.Channel = transport.NewStream("pipe", guest, guest)
+	a, err := agent.New(agentConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := New(ctx, host, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// registerCommand registers a command with the agent for the duration of a
+// test.
+func registerCommand(t *testing.T, cmd *commands.Command) {
+	t.Helper()
+	cmd.Enabled = true
+	commands.RegisterCommand(cmd)
+	t.Cleanup(func() { delete(commands.CommandRegistry, cmd.Name) })
+}
+
+// registerEcho registers test-echo, which returns its value argument.
+func registerEcho(t *testing.T) {
+	registerCommand(t, &commands.Command{
+		Name:  "test-echo",
+		Class: commands.ExecConcurrent,
+		Args:  []commands.Arg{{Name: "value", Type: commands.TypeString}},
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			var args struct{ Value string }
+			err := json.Unmarshal(req, &args)
+			return args.Value, err
+		},
+	})
+}
+
+// registerBlock registers test-block, which signals started and returns
+// "slow" once release is called. It is released when the test ends.
+func registerBlock(t *testing.T) (started <-chan struct{}, release func()) {
+	starts := make(chan struct{}, 1)
+	released := make(chan struct{})
+	var once sync.Once
+	release = func() { once.Do(func() { close(released) }) }
+	registerCommand(t, &commands.Command{
+		Name:  "test-block",
+		Class: commands.ExecConcurrent,
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			starts <- struct{}{}
+			<-released
+			return "slow", nil
+		},
+	})
+	// Registered after startAgent, so it runs before the agent stops
+	t.Cleanup(release)
+	return starts, release
+}
+
+// echo runs test-echo and returns its result.
+func echo(ctx context.Context, c *Client, value string) (string, error) {
+	var result string
+	err := c.Call(ctx, "test-echo", map[string]string{"value": value}, &result)
+	return result, err
+}
+
+func TestHandshake(t *testing.T) {
+	c := startAgent(t, agent.Config{})
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+}
+
+// fakeAgent answers the first guest-sync-delimited request it reads on conn
+// with what respond returns for its request ID and echoed ID.
+func fakeAgent(conn net.Conn, respond func(reqID uint64, id int64) string) {
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimLeft(line, "\xff")
+		var req struct {
+			Execute   string
+			Arguments protocol.SyncArgs
+			ID        uint64
+		}
+		if json.Unmarshal([]byte(line), &req) == nil && req.Execute == "guest-sync-delimited" {
+			conn.Write([]byte(respond(req.ID, req.Arguments.ID)))
+			return
+		}
+	}
+}
+
+func TestHandshakeDiscardsEarlierOutput(t *testing.T) {
+	host, guest := Pipe()
+	defer guest.Close()
+	go fakeAgent(guest, func(reqID uint64, id int64) string {
+		// A response left over from an earlier client, carrying the ID of
+		// the handshake request, precedes the delimiter.
+		return fmt.Sprintf(`{"return": 1, "id": %d}`+"\n"+"\xff"+`{"return": %d, "id": %d}`+"\n", reqID, id, reqID)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := New(ctx, host, Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c.Close()
+}
+
+func TestHandshakeRejectsWrongID(t *testing.T) {
+	host, guest := Pipe()
+	defer guest.Close()
+	go fakeAgent(guest, func(reqID uint64, id int64) string {
+		return fmt.Sprintf("\xff"+`{"return": %d, "id": %d}`+"\n", id+1, reqID)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := New(ctx, host, Config{}); err == nil || !strings.Contains(err.Error(), "guest-sync-delimited returned") {
+		t.Fatalf("New() error = %v, want a mismatched handshake", err)
+	}
+}
+
+func TestTimeoutResynchronises(t *testing.T) {
+	c := startAgent(t, agent.Config{})
+	registerEcho(t)
+	started, release := registerBlock(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	err := c.Call(ctx, "test-block", nil, nil)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call() error = %v, want context.DeadlineExceeded", err)
+	}
+	<-started
+
+	c.mutex.Lock()
+	needSync := c.needSync
+	c.mutex.Unlock()
+	if !needSync {
+		t.Fatal("a timed-out command did not schedule a handshake")
+	}
+
+	// The late response reaches the client while it resynchronises or
+	// after; either way it must not be taken for the next result.
+	release()
+	got, err := echo(context.Background(), c, "fresh")
+	if err != nil || got != "fresh" {
+		t.Fatalf("echo() = %q, %v; want \"fresh\"", got, err)
+	}
+
+	c.mutex.Lock()
+	needSync = c.needSync
+	c.mutex.Unlock()
+	if needSync {
+		t.Error("the handshake did not run before the next command")
+	}
+}
+
+func TestConcurrentResponsesOutOfOrder(t *testing.T) {
+	c := startAgent(t, agent.Config{})
+	registerEcho(t)
+	started, release := registerBlock(t)
+
+	slow := make(chan error, 1)
+	go func() {
+		var result string
+		err := c.Call(context.Background(), "test-block", nil, &result)
+		if err == nil && result != "slow" {
+			err = fmt.Errorf("result = %q", result)
+		}
+		slow <- err
+	}()
+	<-started
+
+	// Later commands are answered while the first is still running
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			if got, err := echo(context.Background(), c, value); err != nil || got != value {
+				t.Errorf("echo(%q) = %q, %v", value, got, err)
+			}
+		}(fmt.Sprintf("value-%d", i))
+	}
+	wg.Wait()
+
+	select {
+	case err := <-slow:
+		t.Fatalf("test-block returned before it was released: %v", err)
+	default:
+	}
+	release()
+	if err := <-slow; err != nil {
+		t.Errorf("test-block: %v", err)
+	}
+}
+
+func TestErrorWithoutIDFailsPendingCommands(t *testing.T) {
+	c := startAgent(t, agent.Config{MaxMessageSize: 256})
+	registerEcho(t)
+	started, _ := registerBlock(t)
+
+	slow := make(chan error, 1)
+	go func() { slow <- c.Call(context.Background(), "test-block", nil, nil) }()
+	<-started
+
+	// The agent rejects the oversized request before reading its ID
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := echo(ctx, c, strings.Repeat("x", 1024))
+	var qmpErr *Error
+	if !errors.As(err, &qmpErr) || qmpErr.Class != protocol.ErrorClassGeneric {
+		t.Fatalf("echo() error = %v, want a %s", err, protocol.ErrorClassGeneric)
+	}
+
+	// The error cannot be told apart from one meant for test-block
+	if err := <-slow; !errors.As(err, &qmpErr) {
+		t.Errorf("test-block error = %v, want the agent's error", err)
+	}
+
+	if got, err := echo(ctx, c, "after"); err != nil || got != "after" {
+		t.Errorf("echo() = %q, %v; want \"after\"", got, err)
+	}
+}
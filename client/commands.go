@@ -0,0 +1,329 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"mac-guest-agent/protocol"
+	"time"
+)
+
+// Typed wrappers for the commands the agent implements. The aliases kept for
+// older hosts, guest-get-hostname and guest-sync-id, have no wrappers of
+// their own; use GetHostName and GuestSync, or Call.
+
+// Ping runs guest-ping.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Call(ctx, "guest-ping", nil, nil)
+}
+
+// GuestSync runs guest-sync, which returns id unchanged. Unlike Sync it
+// does not discard responses to earlier commands.
+func (c *Client) GuestSync(ctx context.Context, id int64) (int64, error) {
+	var result int64
+	err := c.Call(ctx, "guest-sync", protocol.SyncArgs{ID: id}, &result)
+	return result, err
+}
+
+// Info runs guest-info.
+func (c *Client) Info(ctx context.Context) (*protocol.GuestAgentInfo, error) {
+	var result protocol.GuestAgentInfo
+	if err := c.Call(ctx, "guest-info", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// QuerySchema runs guest-query-schema. Each entity is returned undecoded;
+// its meta-type selects the protocol.SchemaInfo type to decode it into.
+func (c *Client) QuerySchema(ctx context.Context) ([]json.RawMessage, error) {
+	var result []json.RawMessage
+	err := c.Call(ctx, "guest-query-schema", nil, &result)
+	return result, err
+}
+
+// Shutdown runs guest-shutdown. mode is "powerdown", "halt" or "reboot";
+// an empty mode selects powerdown. The connection usually drops soon after.
+func (c *Client) Shutdown(ctx context.Context, mode string) error {
+	return c.Call(ctx, "guest-shutdown", protocol.ShutdownArgs{Mode: mode}, nil)
+}
+
+// SuspendDisk runs guest-suspend-disk.
+func (c *Client) SuspendDisk(ctx context.Context) error {
+	return c.Call(ctx, "guest-suspend-disk", nil, nil)
+}
+
+// SuspendRAM runs guest-suspend-ram.
+func (c *Client) SuspendRAM(ctx context.Context) error {
+	return c.Call(ctx, "guest-suspend-ram", nil, nil)
+}
+
+// SuspendHybrid runs guest-suspend-hybrid.
+func (c *Client) SuspendHybrid(ctx context.Context) error {
+	return c.Call(ctx, "guest-suspend-hybrid", nil, nil)
+}
+
+// GetOSInfo runs guest-get-osinfo.
+func (c *Client) GetOSInfo(ctx context.Context) (*protocol.GuestOSInfo, error) {
+	var result protocol.GuestOSInfo
+	if err := c.Call(ctx, "guest-get-osinfo", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetHostName runs guest-get-host-name.
+func (c *Client) GetHostName(ctx context.Context) (string, error) {
+	var result protocol.GuestHostName
+	err := c.Call(ctx, "guest-get-host-name", nil, &result)
+	return result.HostName, err
+}
+
+// GetUsers runs guest-get-users.
+func (c *Client) GetUsers(ctx context.Context) ([]protocol.GuestUser, error) {
+	var result []protocol.GuestUser
+	err := c.Call(ctx, "guest-get-users", nil, &result)
+	return result, err
+}
+
+// GetTimezone runs guest-get-timezone.
+func (c *Client) GetTimezone(ctx context.Context) (*protocol.GuestTimezone, error) {
+	var result protocol.GuestTimezone
+	if err := c.Call(ctx, "guest-get-timezone", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetTime runs guest-get-time.
+func (c *Client) GetTime(ctx context.Context) (time.Time, error) {
+	var nanoseconds int64
+	if err := c.Call(ctx, "guest-get-time", nil, &nanoseconds); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanoseconds), nil
+}
+
+// SetTime runs guest-set-time. A zero t sets the guest clock from the
+// hardware clock.
+func (c *Client) SetTime(ctx context.Context, t time.Time) error {
+	var args protocol.SetTimeArgs
+	if !t.IsZero() {
+		args.Time = t.UnixNano()
+	}
+	return c.Call(ctx, "guest-set-time", args, nil)
+}
+
+// GetVCPUs runs guest-get-vcpus.
+func (c *Client) GetVCPUs(ctx context.Context) ([]protocol.GuestLogicalProcessor, error) {
+	var result []protocol.GuestLogicalProcessor
+	err := c.Call(ctx, "guest-get-vcpus", nil, &result)
+	return result, err
+}
+
+// NetworkGetInterfaces runs guest-network-get-interfaces.
+func (c *Client) NetworkGetInterfaces(ctx context.Context) ([]protocol.GuestNetworkInterface, error) {
+	var result []protocol.GuestNetworkInterface
+	err := c.Call(ctx, "guest-network-get-interfaces", nil, &result)
+	return result, err
+}
+
+// GetDisks runs guest-get-disks.
+func (c *Client) GetDisks(ctx context.Context) ([]protocol.GuestDiskInfo, error) {
+	var result []protocol.GuestDiskInfo
+	err := c.Call(ctx, "guest-get-disks", nil, &result)
+	return result, err
+}
+
+// GetFSInfo runs guest-get-fsinfo.
+func (c *Client) GetFSInfo(ctx context.Context) ([]protocol.GuestFilesystemInfo, error) {
+	var result []protocol.GuestFilesystemInfo
+	err := c.Call(ctx, "guest-get-fsinfo", nil, &result)
+	return result, err
+}
+
+// GetMemoryBlocks runs guest-get-memory-blocks.
+func (c *Client) GetMemoryBlocks(ctx context.Context) ([]protocol.GuestMemoryBlock, error) {
+	var result []protocol.GuestMemoryBlock
+	err := c.Call(ctx, "guest-get-memory-blocks", nil, &result)
+	return result, err
+}
+
+// GetMemoryBlockInfo runs guest-get-memory-block-info.
+func (c *Client) GetMemoryBlockInfo(ctx context.Context) (*protocol.GuestMemoryBlockInfo, error) {
+	var result protocol.GuestMemoryBlockInfo
+	if err := c.Call(ctx, "guest-get-memory-block-info", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetMemoryInfo runs guest-get-memory-info, which reports the vm_stat
+// counters keyed by their label, such as "Pages free". The values are the
+// raw counts vm_stat prints: numbers of pages for the "Pages ..." entries
+// and numbers of events for the others, never bytes. The page size is not
+// reported.
+func (c *Client) GetMemoryInfo(ctx context.Context) (map[string]int64, error) {
+	var result map[string]int64
+	err := c.Call(ctx, "guest-get-memory-info", nil, &result)
+	return result, err
+}
+
+// SetMemoryBlocks runs guest-set-memory-blocks. macOS does not support
+// memory hotplug, so the agent accepts the request without changing
+// anything.
+func (c *Client) SetMemoryBlocks(ctx context.Context, blocks []protocol.GuestMemoryBlock) error {
+	args := struct {
+		MemBlks []protocol.GuestMemoryBlock `json:"mem-blks"`
+	}{blocks}
+	return c.Call(ctx, "guest-set-memory-blocks", args, nil)
+}
+
+// FSFreezeStatus runs guest-fsfreeze-status.
+func (c *Client) FSFreezeStatus(ctx context.Context) (protocol.GuestFsfreezeStatus, error) {
+	var result protocol.GuestFsfreezeStatus
+	err := c.Call(ctx, "guest-fsfreeze-status", nil, &result)
+	return result, err
+}
+
+// FSFreezeFreeze runs guest-fsfreeze-freeze and returns the number of
+// filesystems frozen.
+func (c *Client) FSFreezeFreeze(ctx context.Context) (int, error) {
+	var result int
+	err := c.Call(ctx, "guest-fsfreeze-freeze", nil, &result)
+	return result, err
+}
+
+// FSFreezeFreezeList runs guest-fsfreeze-freeze-list. An empty list freezes
+// every filesystem.
+func (c *Client) FSFreezeFreezeList(ctx context.Context, mountpoints []string) (int, error) {
+	var result int
+	err := c.Call(ctx, "guest-fsfreeze-freeze-list", protocol.FreezeListArgs{Mountpoints: mountpoints}, &result)
+	return result, err
+}
+
+// FSFreezeThaw runs guest-fsfreeze-thaw and returns the number of
+// filesystems thawed.
+func (c *Client) FSFreezeThaw(ctx context.Context) (int, error) {
+	var result int
+	err := c.Call(ctx, "guest-fsfreeze-thaw", nil, &result)
+	return result, err
+}
+
+// FSTrim runs guest-fstrim. minimum is the smallest free range, in bytes,
+// worth discarding; zero lets the guest choose.
+func (c *Client) FSTrim(ctx context.Context, minimum int64) (*protocol.GuestFilesystemTrimResponse, error) {
+	var result protocol.GuestFilesystemTrimResponse
+	if err := c.Call(ctx, "guest-fstrim", protocol.FstrimArgs{Minimum: minimum}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Exec runs guest-exec and returns the PID of the started process. The
+// input data in args must already be base64 encoded.
+func (c *Client) Exec(ctx context.Context, args protocol.GuestExecArgs) (int, error) {
+	var result protocol.GuestExec
+	err := c.Call(ctx, "guest-exec", args, &result)
+	return result.PID, err
+}
+
+// ExecStatus runs guest-exec-status. The captured output in the result is
+// base64 encoded.
+func (c *Client) ExecStatus(ctx context.Context, pid int) (*protocol.GuestExecStatus, error) {
+	var result protocol.GuestExecStatus
+	if err := c.Call(ctx, "guest-exec-status", protocol.GuestExecStatusArgs{PID: pid}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FileOpen runs guest-file-open and returns the file handle. An empty mode
+// opens the file for reading.
+func (c *Client) FileOpen(ctx context.Context, path, mode string) (int64, error) {
+	var handle int64
+	err := c.Call(ctx, "guest-file-open", protocol.GuestFileOpenArgs{Path: path, Mode: mode}, &handle)
+	return handle, err
+}
+
+// FileClose runs guest-file-close.
+func (c *Client) FileClose(ctx context.Context, handle int64) error {
+	return c.Call(ctx, "guest-file-close", protocol.GuestFileHandleArgs{Handle: handle}, nil)
+}
+
+// FileRead runs guest-file-read and returns the decoded data and whether
+// the end of the file was reached. A count of zero reads the agent's
+// default amount.
+func (c *Client) FileRead(ctx context.Context, handle int64, count int64) ([]byte, bool, error) {
+	args := protocol.GuestFileReadArgs{Handle: handle}
+	if count > 0 {
+		args.Count = &count
+	}
+	var result protocol.GuestFileRead
+	if err := c.Call(ctx, "guest-file-read", args, &result); err != nil {
+		return nil, false, err
+	}
+	data, err := base64.StdEncoding.DecodeString(result.BufB64)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, result.EOF, nil
+}
+
+// FileWrite runs guest-file-write and returns the number of bytes written.
+func (c *Client) FileWrite(ctx context.Context, handle int64, data []byte) (int, error) {
+	args := protocol.GuestFileWriteArgs{
+		Handle: handle,
+		BufB64: base64.StdEncoding.EncodeToString(data),
+	}
+	var result protocol.GuestFileWrite
+	err := c.Call(ctx, "guest-file-write", args, &result)
+	return result.Count, err
+}
+
+// FileSeek runs guest-file-seek.
+func (c *Client) FileSeek(ctx context.Context, handle, offset int64, whence protocol.GuestFileWhence) (*protocol.GuestFileSeek, error) {
+	args := protocol.GuestFileSeekArgs{Handle: handle, Offset: offset, Whence: whence}
+	var result protocol.GuestFileSeek
+	if err := c.Call(ctx, "guest-file-seek", args, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FileFlush runs guest-file-flush.
+func (c *Client) FileFlush(ctx context.Context, handle int64) error {
+	return c.Call(ctx, "guest-file-flush", protocol.GuestFileHandleArgs{Handle: handle}, nil)
+}
+
+// SSHGetAuthorizedKeys runs guest-ssh-get-authorized-keys.
+func (c *Client) SSHGetAuthorizedKeys(ctx context.Context, username string) ([]string, error) {
+	var result protocol.GuestSSHInfo
+	err := c.Call(ctx, "guest-ssh-get-authorized-keys", protocol.GuestSSHGetKeysArgs{Username: username}, &result)
+	return result.Keys, err
+}
+
+// SSHAddAuthorizedKeys runs guest-ssh-add-authorized-keys. With reset the
+// keys replace the existing ones.
+func (c *Client) SSHAddAuthorizedKeys(ctx context.Context, username string, keys []string, reset bool) error {
+	args := protocol.GuestSSHAddKeysArgs{Username: username, Keys: keys, Reset: reset}
+	return c.Call(ctx, "guest-ssh-add-authorized-keys", args, nil)
+}
+
+// SSHRemoveAuthorizedKeys runs guest-ssh-remove-authorized-keys.
+func (c *Client) SSHRemoveAuthorizedKeys(ctx context.Context, username string, keys []string) error {
+	args := protocol.GuestSSHRemoveKeysArgs{Username: username, Keys: keys}
+	return c.Call(ctx, "guest-ssh-remove-authorized-keys", args, nil)
+}
+
+// SetUserPassword runs guest-set-user-password. password is the plain
+// text password, or a crypt(3) hash if crypted is set; it is base64
+// encoded for the wire.
+func (c *Client) SetUserPassword(ctx context.Context, username, password string, crypted bool) error {
+	args := protocol.GuestSetUserPasswordArgs{
+		Username: username,
+		Password: base64.StdEncoding.EncodeToString([]byte(password)),
+		Crypted:  crypted,
+	}
+	return c.Call(ctx, "guest-set-user-password", args, nil)
+}
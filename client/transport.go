@@ -0,0 +1,25 @@
+package client
+
+import (
+	"context"
+	"net"
+)
+
+// DialUnix connects to an agent channel exposed as a Unix socket, such as
+// the chardev socket QEMU creates for the guest agent, or an agent started
+// with the unix-listen transport.
+func DialUnix(ctx context.Context, path string, config Config) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return New(ctx, conn, config)
+}
+
+// Pipe returns the two ends of an in-memory connection. The host end is
+// passed to New; the guest end is given to an agent running in the same
+// process, wrapped with transport.NewStream.
+func Pipe() (host, guest net.Conn) {
+	return net.Pipe()
+}
@@ -2,11 +2,13 @@ package agent
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"mac-guest-agent/internal/commands"
-	"mac-guest-agent/internal/communication"
-	"mac-guest-agent/internal/protocol"
-	"strings"
+	"mac-guest-agent/internal/metrics"
+	"mac-guest-agent/internal/transport"
+	"mac-guest-agent/protocol"
 	"sync"
 	"time"
 
@@ -17,29 +19,106 @@ const (
 	Version = "1.1.0"
 )
 
+// Config describes how an Agent is set up.
+type Config struct {
+	// Transport selects and configures the channel to the host.
+	Transport transport.Spec
+
+	// Channel, if set, is used instead of creating a transport from
+	// Transport. Tests use it to run the agent over an in-memory pipe.
+	Channel transport.Transport
+
+	// MaxMessageSize caps the size of a single incoming JSON message.
+	// Zero selects DefaultMaxMessageSize.
+	MaxMessageSize int
+
+	// Workers is the number of goroutines executing concurrent commands.
+	// Zero selects DefaultWorkers.
+	Workers int
+
+	// ReconnectDelay is the pause before reopening a transport that went
+	// away. Zero selects DefaultReconnectDelay.
+	ReconnectDelay time.Duration
+
+	// CommandTimeout is the default execution deadline for commands.
+	// Zero selects commands.DefaultTimeout.
+	CommandTimeout time.Duration
+
+	// CommandTimeouts overrides the deadline of individual commands; every
+	// key must be a registered command.
+	CommandTimeouts map[string]time.Duration
+
+	// AllowedRPCs, if not empty, lists the only commands the host may run.
+	AllowedRPCs []string
+
+	// BlockedRPCs lists commands the host may not run.
+	BlockedRPCs []string
+}
+
 // Agent represents the main class for the macOS Guest Agent.
 type Agent struct {
-	commManager communication.CommunicationManager
-	isRunning   bool
-	stopChan    chan struct{}
-	mutex       sync.RWMutex
+	config     Config
+	state      *GAState
+	transport  transport.Transport
+	parser     *JSONMessageParser
+	dispatcher *Dispatcher
+	writeMutex sync.Mutex
+	isRunning  bool
+	stopChan   chan struct{}
+	done       chan struct{}
+	mutex      sync.RWMutex
 }
 
-// New creates a new Agent instance.
-func New(devicePath string) (*Agent, error) {
-	agent := &Agent{
-		commManager: communication.NewManager(devicePath),
-		stopChan:    make(chan struct{}),
+// readBufferSize is the size of a single read from the transport.
+const readBufferSize = 4096
+
+// DefaultReconnectDelay is the pause before reopening a transport that went
+// away.
+const DefaultReconnectDelay = 5 * time.Second
+
+// New creates a new Agent instance using the transport described by config.
+func New(config Config) (*Agent, error) {
+	t := config.Channel
+	if t == nil {
+		var err error
+		if t, err = transport.New(config.Transport); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ReconnectDelay <= 0 {
+		config.ReconnectDelay = DefaultReconnectDelay
 	}
-	return agent, nil
-}
 
-// NewTestMode creates a test mode Agent instance.
-func NewTestMode() (*Agent, error) {
+	for name := range config.CommandTimeouts {
+		if _, ok := commands.LookupCommand(name); !ok {
+			return nil, fmt.Errorf("timeout set for unknown command %q", name)
+		}
+	}
+	commands.SetDefaultTimeout(config.CommandTimeout)
+	for name, timeout := range config.CommandTimeouts {
+		commands.SetCommandTimeout(name, timeout)
+	}
+
+	parser := NewJSONMessageParser()
+	parser.SetMaxMessageSize(config.MaxMessageSize)
+
+	state := NewGAState()
+	state.Parser = parser
+	state.Transport = t
+	state.AllowedRPCs = config.AllowedRPCs
+	state.BlockedRPCs = config.BlockedRPCs
+	commands.SetState(state)
+
 	agent := &Agent{
-		commManager: communication.NewTestManager(),
-		stopChan:    make(chan struct{}),
+		config:    config,
+		state:     state,
+		transport: t,
+		parser:    parser,
+		stopChan:  make(chan struct{}),
+		done:      make(chan struct{}),
 	}
+	agent.dispatcher = NewDispatcher(config.Workers, agent.sendResponse)
 	return agent, nil
 }
 
@@ -52,12 +131,17 @@ func (a *Agent) Start() error {
 		return fmt.Errorf("Agent is already running")
 	}
 
-	if err := a.commManager.Open(); err != nil {
-		return fmt.Errorf("failed to open communication device: %v", err)
+	if err := a.transport.Open(); err != nil {
+		return fmt.Errorf("failed to open transport %s: %v", a.config.Transport.Method, err)
 	}
 
+	a.state.InitCommandState()
+	a.dispatcher.Start()
 	a.isRunning = true
-	logrus.Info("Agent started, listening for messages...")
+	logrus.WithFields(logrus.Fields{
+		"method": a.config.Transport.Method,
+		"path":   a.transport.Path(),
+	}).Info("Agent started, listening for messages...")
 
 	go a.messageLoop()
 
@@ -74,12 +158,21 @@ func (a *Agent) Stop() {
 	}
 
 	close(a.stopChan)
-	a.commManager.Close()
+	a.transport.Close()
+	a.dispatcher.Stop()
+	a.state.CleanupCommandState()
 	a.isRunning = false
 
 	logrus.Info("Agent stopped")
 }
 
+// Done returns a channel that is closed when the agent stops reading from
+// the host: after Stop, or once the input of a stream transport such as
+// stdio has ended and the commands already read have been answered.
+func (a *Agent) Done() <-chan struct{} {
+	return a.done
+}
+
 // IsRunning checks if the Agent is running.
 func (a *Agent) IsRunning() bool {
 	a.mutex.RLock()
@@ -89,70 +182,114 @@ func (a *Agent) IsRunning() bool {
 
 // messageLoop is the main message processing loop.
 func (a *Agent) messageLoop() {
+	defer close(a.done)
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.WithField("panic", r).Error("Message processing loop panicked")
 		}
 	}()
 
+	buffer := make([]byte, readBufferSize)
+
 	for {
 		select {
 		case <-a.stopChan:
 			logrus.Debug("Received stop signal, exiting message loop")
 			return
 		default:
-			if err := a.processMessage(); err != nil {
-				if err.Error() == "read_timeout" || err.Error() == "empty_message" || strings.Contains(err.Error(), "timeout") {
-					continue
+		}
+
+		n, err := a.transport.Read(buffer)
+		if n > 0 {
+			for _, message := range a.parser.Feed(buffer[:n]) {
+				if err := a.processMessage(message); err != nil {
+					logrus.WithError(err).Error("Failed to process message")
 				}
+			}
+		}
+		if err == nil || errors.Is(err, transport.ErrTimeout) {
+			continue
+		}
 
-				logrus.WithError(err).Error("Failed to process message")
+		select {
+		case <-a.stopChan:
+			logrus.Debug("Received stop signal, exiting message loop")
+			return
+		default:
+		}
 
-				if !a.commManager.IsOpen() {
-					logrus.Info("Device connection lost, attempting to reconnect...")
-					time.Sleep(5 * time.Second)
-					if err := a.commManager.Open(); err != nil {
-						logrus.WithError(err).Error("Failed to reconnect")
-					}
-				} else {
-					time.Sleep(100 * time.Millisecond)
-				}
+		if errors.Is(err, io.EOF) {
+			// The peer went away; drop whatever it left half-written.
+			a.parser.Reset()
+			// The input of a stream, such as stdin, cannot come back.
+			// Answer what was read and stop like upstream does.
+			if _, stream := a.transport.(*transport.StreamTransport); stream {
+				logrus.WithField("path", a.transport.Path()).Info("Input ended, stopping message loop")
+				a.dispatcher.Wait()
+				return
+			}
+			// A listening transport waits for the next client on the
+			// following read. A device keeps returning EOF while no host
+			// is attached, so it falls through to the back-off below.
+			if _, listening := a.transport.(*transport.ListenTransport); listening && a.transport.IsOpen() {
+				metrics.TransportReconnects.Inc("success")
+				continue
 			}
+		} else {
+			logrus.WithError(err).Error("Failed to read from transport")
+		}
+
+		if !a.transport.IsOpen() {
+			logrus.Info("Transport connection lost, attempting to reconnect...")
+			time.Sleep(a.config.ReconnectDelay)
+			if err := a.transport.Open(); err != nil {
+				logrus.WithError(err).Error("Failed to reconnect")
+				metrics.TransportReconnects.Inc("failure")
+			} else {
+				metrics.TransportReconnects.Inc("success")
+			}
+			a.parser.Reset()
+		} else {
+			time.Sleep(100 * time.Millisecond)
 		}
 	}
 }
 
-// processMessage handles a single message.
-func (a *Agent) processMessage() error {
-	msgData, err := a.commManager.ReadMessage()
-	if err != nil {
-		return fmt.Errorf("failed to read message: %v", err)
+// processMessage handles a single message produced by the parser.
+func (a *Agent) processMessage(message ParsedMessage) error {
+	if message.Err != nil {
+		logrus.WithError(message.Err).Error("Failed to parse request")
+		errorResp := protocol.NewErrorResponse("GenericError", "Invalid message format")
+		return a.sendResponse(errorResp, false)
 	}
 
+	msgData := message.Data
+	// The raw message may hold passwords, so only its size is logged.
+	logrus.WithField("bytes", len(msgData)).Debug("Received message")
+
 	request, err := protocol.ParseRequest(msgData)
 	if err != nil {
-		logrus.WithError(err).WithField("data", string(msgData)).Error("Failed to parse request")
+		logrus.WithError(err).WithField("bytes", len(msgData)).Error("Failed to parse request")
 		errorResp := protocol.NewErrorResponse("GenericError", "Invalid message format")
 		return a.sendResponse(errorResp, false)
 	}
 
-	// Log arguments safely
-	var argsStr string
-	if request.Arguments != nil {
-		argBytes, marshalErr := json.Marshal(request.Arguments)
+	logFields := logrus.Fields{
+		"command": request.Execute,
+		"id":      request.ID,
+	}
+
+	// Arguments are only logged at debug level, with sensitive values
+	// redacted; the audit log keeps the record of what was run.
+	if request.Arguments != nil && logrus.IsLevelEnabled(logrus.DebugLevel) {
+		argBytes, marshalErr := json.Marshal(commands.RedactArguments(request.Execute, request.Arguments))
 		if marshalErr != nil {
-			argsStr = "[failed to marshal arguments]"
+			logFields["arguments"] = "[failed to marshal arguments]"
 		} else {
-			argsStr = string(argBytes)
+			logFields["arguments"] = string(argBytes)
 		}
 	}
 
-	logFields := logrus.Fields{
-		"command":   request.Execute,
-		"arguments": argsStr,
-		"id":        request.ID,
-	}
-
 	// Reduce log noise for frequent commands.
 	if request.Execute == "guest-ping" || request.Execute == "guest-sync-delimited" {
 		logrus.WithFields(logFields).Debug("Received QMP request")
@@ -160,23 +297,8 @@ func (a *Agent) processMessage() error {
 		logrus.WithFields(logFields).Info("Received QMP request")
 	}
 
-	// The old processor is gone, directly call the new command handler.
-	// The new handler returns a `protocol.Response` which needs to be converted.
-	handlerResponse := commands.HandleCommand(*request)
-	qmpResponse := protocol.QMPResponse{
-		Return: handlerResponse.Return,
-		Error:  handlerResponse.Error,
-	}
-
-	// The response ID must match the request ID.
-	if request.ID != nil {
-		qmpResponse.ID = request.ID
-	}
-
-	// For guest-sync-delimited, we need to send a delimiter.
-	useDelimiter := request.Execute == "guest-sync-delimited"
-
-	return a.sendResponse(&qmpResponse, useDelimiter)
+	a.dispatcher.Dispatch(*request)
+	return nil
 }
 
 // sendResponse sends a response message.
@@ -188,11 +310,33 @@ func (a *Agent) sendResponse(resp *protocol.QMPResponse, useDelimiter bool) erro
 		fallbackResp := protocol.NewErrorResponse("InternalError", "Failed to marshal response")
 		fallbackResp.ID = resp.ID
 		fallbackData, _ := json.Marshal(fallbackResp)
-		return a.commManager.SendResponse(fallbackData)
+		return a.writeFrame(fallbackData, false)
 	}
 
+	return a.writeFrame(respData, useDelimiter)
+}
+
+// writeFrame writes a single newline-terminated response, preceded by the
+// 0xFF delimiter when requested (guest-sync-delimited).
+func (a *Agent) writeFrame(data []byte, useDelimiter bool) error {
+	a.writeMutex.Lock()
+	defer a.writeMutex.Unlock()
+
+	frame := make([]byte, 0, len(data)+2)
 	if useDelimiter {
-		return a.commManager.SendDelimitedResponse(respData)
+		frame = append(frame, 0xFF)
+	}
+	frame = append(frame, data...)
+	frame = append(frame, '\n')
+
+	for written := 0; written < len(frame); {
+		n, err := a.transport.Write(frame[written:])
+		if err != nil {
+			return fmt.Errorf("failed to write response: %v", err)
+		}
+		written += n
 	}
-	return a.commManager.SendResponse(respData)
+
+	logrus.WithField("response", string(data)).Debug("Sent response")
+	return nil
 }
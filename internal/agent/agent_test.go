@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"mac-guest-agent/internal/metrics"
+	"mac-guest-agent/internal/transport"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// eofTransport behaves like a virtio-serial port with no host attached: it
+// stays open and every read returns EOF at once.
+type eofTransport struct {
+	reads int64
+	mutex sync.Mutex
+	open  bool
+}
+
+func (t *eofTransport) Open() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.open = true
+	return nil
+}
+
+func (t *eofTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.open = false
+	return nil
+}
+
+func (t *eofTransport) Read(buffer []byte) (int, error) {
+	atomic.AddInt64(&t.reads, 1)
+	return 0, io.EOF
+}
+
+func (t *eofTransport) Write(data []byte) (int, error) { return len(data), nil }
+
+func (t *eofTransport) IsOpen() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.open
+}
+
+func (t *eofTransport) Path() string { return "eof" }
+
+func TestMessageLoopBacksOffOnEOF(t *testing.T) {
+	channel := &eofTransport{}
+	a, err := New(Config{Channel: channel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	a.Stop()
+
+	// With a 100ms back-off about five reads happen; a busy loop makes
+	// millions.
+	if reads := atomic.LoadInt64(&channel.reads); reads > 20 {
+		t.Errorf("messageLoop read %d times in 500ms, want it to back off", reads)
+	}
+}
+
+// lockedBuffer collects what the agent writes from several goroutines.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(data []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(data)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestStreamEOFStopsAgent(t *testing.T) {
+	// guest-get-time runs on a worker; its response must still be written
+	input := strings.NewReader(`{"execute":"guest-get-time","id":1}{"execute":"guest-ping","id":2}`)
+	output := &lockedBuffer{}
+	a, err := New(Config{
+		Channel:        transport.NewStream("stdio-test", input, output),
+		ReconnectDelay: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	select {
+	case <-a.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the agent kept running after its input ended")
+	}
+	for _, want := range []string{`"id":1`, `{"return":{},"id":2}`} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("output %q does not contain %s", output.String(), want)
+		}
+	}
+}
+
+func TestNewRejectsUnknownCommandTimeout(t *testing.T) {
+	_, err := New(Config{
+		Channel:         &eofTransport{},
+		CommandTimeouts: map[string]time.Duration{"guest-ping": time.Second, "guest-bogus": time.Second},
+	})
+	if err == nil || !strings.Contains(err.Error(), `"guest-bogus"`) {
+		t.Errorf("New() error = %v, want one naming guest-bogus", err)
+	}
+}
+
+// reconnects returns the value of the transport reconnect counter for
+// result.
+func reconnects(t *testing.T, result string) float64 {
+	t.Helper()
+	var text bytes.Buffer
+	if err := metrics.Default.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	prefix := `mac_guest_agent_transport_reconnects_total{result="` + result + `"} `
+	for _, line := range strings.Split(text.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(line, prefix), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return value
+		}
+	}
+	return 0
+}
+
+func TestListenHangupCountsReconnect(t *testing.T) {
+	channel, err := transport.New(transport.Spec{
+		Method: "unix-listen",
+		Path:   filepath.Join(t.TempDir(), "agent.sock"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Config{Channel: channel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	before := reconnects(t, "success")
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", channel.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Wait for the agent to serve the client before hanging up
+		conn.Write([]byte(`{"execute":"guest-ping"}`))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 64)); err != nil {
+			t.Fatalf("no response from the agent: %v", err)
+		}
+		conn.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for reconnects(t, "success") < before+2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("reconnects = %v, want %v after two hangups", reconnects(t, "success"), before+2)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessMessageDoesNotLogInvalidRequests(t *testing.T) {
+	a, err := New(Config{Channel: &eofTransport{open: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetLevel(level)
+
+	secret := "c2VjcmV0LXBhc3N3b3Jk"
+	data := []byte(`{"execute":["guest-set-user-password"],"arguments":{"username":"admin","password":"` + secret + `"}}`)
+	if err := a.processMessage(ParsedMessage{Data: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hook.AllEntries()) == 0 {
+		t.Fatal("the invalid request was not logged")
+	}
+	for _, entry := range hook.AllEntries() {
+		line, _ := entry.String()
+		if strings.Contains(line, secret) {
+			t.Errorf("log entry contains the request: %s", line)
+		}
+	}
+}
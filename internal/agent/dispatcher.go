@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"mac-guest-agent/internal/commands"
+	"mac-guest-agent/protocol"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultWorkers is the default size of the concurrent worker pool.
+	DefaultWorkers = 4
+
+	// dispatchQueueSize bounds the number of requests waiting per queue.
+	dispatchQueueSize = 64
+)
+
+// responder writes a response back to the host.
+type responder func(resp *protocol.QMPResponse, useDelimiter bool) error
+
+// Dispatcher schedules requests according to their commands.ExecClass.
+//
+// Heartbeat commands are executed inline on the read path, concurrent
+// commands are handed to a pool of workers and serial commands are executed
+// one at a time, in arrival order, by a dedicated goroutine. Responses are
+// tagged with the request ID since they may be written out of order.
+type Dispatcher struct {
+	workers    int
+	respond    responder
+	concurrent chan protocol.QMPRequest
+	serial     chan protocol.QMPRequest
+	ctx        context.Context
+	cancel     context.CancelFunc
+	stopChan   chan struct{}
+	wg         sync.WaitGroup
+
+	// pending counts queued and executing requests. Only the read loop
+	// dispatches, so it never grows while Wait is waiting.
+	pending sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher with the given number of workers.
+func NewDispatcher(workers int, respond responder) *Dispatcher {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		ctx:        ctx,
+		cancel:     cancel,
+		workers:    workers,
+		respond:    respond,
+		concurrent: make(chan protocol.QMPRequest, dispatchQueueSize),
+		serial:     make(chan protocol.QMPRequest, dispatchQueueSize),
+		stopChan:   make(chan struct{}),
+	}
+}
+
+// Start launches the worker goroutines.
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.worker(d.concurrent)
+	}
+	d.wg.Add(1)
+	go d.worker(d.serial)
+}
+
+// Stop stops the workers. Requests still waiting in the queues are dropped;
+// requests already executing have their context cancelled.
+func (d *Dispatcher) Stop() {
+	close(d.stopChan)
+	d.cancel()
+	d.wg.Wait()
+}
+
+// Wait blocks until every dispatched request has been answered or the
+// dispatcher is stopped.
+func (d *Dispatcher) Wait() {
+	idle := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-d.stopChan:
+	}
+}
+
+// Dispatch schedules a request. It never blocks on slow commands.
+func (d *Dispatcher) Dispatch(request protocol.QMPRequest) {
+	class := commands.ExecHeartbeat
+	if cmd, ok := commands.LookupCommand(request.Execute); ok {
+		class = cmd.Class
+	}
+
+	var queue chan protocol.QMPRequest
+	switch class {
+	case commands.ExecHeartbeat:
+		// Unknown commands also take this path; they fail fast.
+		d.execute(request)
+		return
+	case commands.ExecConcurrent:
+		queue = d.concurrent
+	default:
+		queue = d.serial
+	}
+
+	d.pending.Add(1)
+	select {
+	case queue <- request:
+	default:
+		d.pending.Done()
+		logrus.WithFields(logrus.Fields{
+			"command": request.Execute,
+			"class":   class.String(),
+		}).Warn("Dispatch queue is full, rejecting request")
+		resp := protocol.NewErrorResponse(protocol.ErrorClassGeneric,
+			fmt.Sprintf("too many pending requests, %s was not executed", request.Execute))
+		resp.ID = request.ID
+		d.send(resp, false)
+	}
+}
+
+// worker executes requests from the given queue until the dispatcher stops.
+func (d *Dispatcher) worker(queue chan protocol.QMPRequest) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stopChan:
+			return
+		case request := <-queue:
+			d.execute(request)
+			d.pending.Done()
+		}
+	}
+}
+
+// execute runs a single request and writes its response.
+func (d *Dispatcher) execute(request protocol.QMPRequest) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithFields(logrus.Fields{
+				"command": request.Execute,
+				"panic":   r,
+			}).Error("Command handler panicked")
+			resp := protocol.NewErrorResponse(protocol.ErrorClassGeneric, fmt.Sprintf("internal error while executing %s", request.Execute))
+			resp.ID = request.ID
+			d.send(resp, false)
+		}
+	}()
+
+	handlerResponse := commands.HandleCommand(d.ctx, request)
+	qmpResponse := protocol.QMPResponse{
+		Return: handlerResponse.Return,
+		Error:  handlerResponse.Error,
+	}
+
+	// The response ID must match the request ID.
+	if request.ID != nil {
+		qmpResponse.ID = request.ID
+	}
+
+	// For guest-sync-delimited, we need to send a delimiter.
+	useDelimiter := request.Execute == "guest-sync-delimited"
+
+	d.send(&qmpResponse, useDelimiter)
+}
+
+// send writes a response, logging failures.
+func (d *Dispatcher) send(resp *protocol.QMPResponse, useDelimiter bool) {
+	if err := d.respond(resp, useDelimiter); err != nil {
+		logrus.WithError(err).WithField("response_id", resp.ID).Error("Failed to send response")
+	}
+}
@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"mac-guest-agent/internal/commands"
+	"mac-guest-agent/protocol"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// registerCommand registers a command for the duration of a test.
+func registerCommand(t *testing.T, cmd *commands.Command) {
+	t.Helper()
+	cmd.Enabled = true
+	commands.RegisterCommand(cmd)
+	t.Cleanup(func() { delete(commands.CommandRegistry, cmd.Name) })
+}
+
+// startDispatcher starts a dispatcher whose responses are sent on the
+// returned channel. It is stopped when the test ends.
+func startDispatcher(t *testing.T, workers int) (*Dispatcher, <-chan *protocol.QMPResponse) {
+	t.Helper()
+	responses := make(chan *protocol.QMPResponse, 2*dispatchQueueSize)
+	d := NewDispatcher(workers, func(resp *protocol.QMPResponse, useDelimiter bool) error {
+		responses <- resp
+		return nil
+	})
+	d.Start()
+	t.Cleanup(d.Stop)
+	return d, responses
+}
+
+// receive waits for the next response.
+func receive(t *testing.T, responses <-chan *protocol.QMPResponse) *protocol.QMPResponse {
+	t.Helper()
+	select {
+	case resp := <-responses:
+		return resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response")
+		return nil
+	}
+}
+
+// blockingCommand registers a command of the given class that signals
+// started when it first runs and waits until release is called, which also
+// happens when the test ends.
+func blockingCommand(t *testing.T, name string, class commands.ExecClass) (started <-chan struct{}, release func()) {
+	starts := make(chan struct{}, 1)
+	released := make(chan struct{})
+	var once sync.Once
+	release = func() { once.Do(func() { close(released) }) }
+	registerCommand(t, &commands.Command{
+		Name:    name,
+		Class:   class,
+		Timeout: time.Minute,
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			select {
+			case starts <- struct{}{}:
+			default:
+			}
+			<-released
+			return "released", nil
+		},
+	})
+	// Runs before the dispatcher is stopped
+	t.Cleanup(release)
+	return starts, release
+}
+
+func TestDispatcherSerialOrder(t *testing.T) {
+	var mutex sync.Mutex
+	var order []int
+	registerCommand(t, &commands.Command{
+		Name:  "test-serial",
+		Class: commands.ExecSerial,
+		Args:  []commands.Arg{{Name: "n", Type: commands.TypeInt}},
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			var args struct{ N int }
+			json.Unmarshal(req, &args)
+			// Earlier commands take longer; they must still finish first
+			time.Sleep(time.Duration(10-args.N) * time.Millisecond)
+			mutex.Lock()
+			order = append(order, args.N)
+			mutex.Unlock()
+			return args.N, nil
+		},
+	})
+	d, responses := startDispatcher(t, 4)
+
+	for i := 0; i < 10; i++ {
+		d.Dispatch(protocol.QMPRequest{Execute: "test-serial", Arguments: map[string]int{"n": i}, ID: i})
+	}
+	for i := 0; i < 10; i++ {
+		if resp := receive(t, responses); resp.ID != i {
+			t.Errorf("response %d has ID %v", i, resp.ID)
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i, n := range order {
+		if n != i {
+			t.Fatalf("serial commands ran in order %v", order)
+		}
+	}
+}
+
+func TestDispatcherConcurrentOverlap(t *testing.T) {
+	const workers = 3
+	var running sync.WaitGroup
+	running.Add(workers)
+	allRunning := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(allRunning)
+	}()
+	registerCommand(t, &commands.Command{
+		Name:  "test-concurrent",
+		Class: commands.ExecConcurrent,
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			// Each command waits until all of them are running at once
+			running.Done()
+			select {
+			case <-allRunning:
+				return "overlapped", nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+	})
+	d, responses := startDispatcher(t, workers)
+
+	for i := 0; i < workers; i++ {
+		d.Dispatch(protocol.QMPRequest{Execute: "test-concurrent", ID: i})
+	}
+	for i := 0; i < workers; i++ {
+		select {
+		case resp := <-responses:
+			if resp.Error != nil {
+				t.Errorf("response %v: %+v", resp.ID, resp.Error)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("concurrent commands did not run in parallel")
+		}
+	}
+}
+
+func TestDispatcherHeartbeatWhileSerialBusy(t *testing.T) {
+	started, release := blockingCommand(t, "test-busy", commands.ExecSerial)
+	d, responses := startDispatcher(t, 1)
+
+	d.Dispatch(protocol.QMPRequest{Execute: "test-busy", ID: "busy"})
+	<-started
+
+	// Heartbeats are answered before Dispatch returns
+	d.Dispatch(protocol.QMPRequest{Execute: "guest-ping", ID: "ping"})
+	d.Dispatch(protocol.QMPRequest{Execute: "guest-sync", Arguments: map[string]int{"id": 7}, ID: "sync"})
+	for _, id := range []string{"ping", "sync"} {
+		select {
+		case resp := <-responses:
+			if resp.ID != id || resp.Error != nil {
+				t.Errorf("got response %+v, want %s", resp, id)
+			}
+		default:
+			t.Fatalf("%s was not answered while a serial command was running", id)
+		}
+	}
+
+	release()
+	if resp := receive(t, responses); resp.ID != "busy" {
+		t.Errorf("got response %+v, want busy", resp)
+	}
+}
+
+func TestDispatcherFullQueue(t *testing.T) {
+	started, release := blockingCommand(t, "test-busy", commands.ExecSerial)
+	d, responses := startDispatcher(t, 1)
+
+	// The first request is taken off the queue and blocks the serial
+	// worker; the next ones fill the queue.
+	d.Dispatch(protocol.QMPRequest{Execute: "test-busy", ID: 0})
+	<-started
+	for i := 1; i <= dispatchQueueSize; i++ {
+		d.Dispatch(protocol.QMPRequest{Execute: "test-busy", ID: i})
+	}
+	d.Dispatch(protocol.QMPRequest{Execute: "test-busy", ID: "rejected"})
+
+	resp := receive(t, responses)
+	if resp.ID != "rejected" || resp.Error == nil || resp.Error.Class != protocol.ErrorClassGeneric ||
+		!strings.Contains(resp.Error.Desc, "too many pending requests") {
+		t.Fatalf("got response %+v, want a GenericError for the rejected request", resp)
+	}
+
+	// Every queued request still runs once the worker is free
+	release()
+	for i := 0; i <= dispatchQueueSize; i++ {
+		if resp := receive(t, responses); resp.ID != i || resp.Error != nil {
+			t.Fatalf("got response %+v, want %d", resp, i)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMaxMessageSize 单条消息的默认最大长度
+const DefaultMaxMessageSize = 16 * 1024 * 1024
+
+// resyncByte 宿主机发送的重新同步字节（guest-sync-delimited协议）
+// 0xFF不可能出现在合法的UTF-8 JSON中，收到后丢弃所有未完成的输入
+const resyncByte = 0xFF
+
+var (
+	// ErrUnexpectedInput 消息之外出现了非空白字符
+	ErrUnexpectedInput = errors.New("unexpected input outside of a JSON object")
+
+	// ErrMessageTooLarge 消息超过最大长度
+	ErrMessageTooLarge = errors.New("message exceeds the maximum size")
+)
+
+// JSONMessageParser 增量JSON流解析器 - 参考官方实现的json-streamer
+// 按括号层级切分输入流，允许消息之间有任意空白、一次读取包含多条消息，
+// 以及一条消息跨越多次读取。
+type JSONMessageParser struct {
+	buffer     []byte
+	inString   bool
+	escaped    bool
+	braceLevel int
+	discarding bool
+	garbage    bool
+	maxSize    int
+	resyncs    int
+}
+
+// ParsedMessage 解析结果，Data和Err二者只有一个有效
+type ParsedMessage struct {
+	Data []byte
+	Err  error
+}
+
+// NewJSONMessageParser 创建JSON消息解析器
+func NewJSONMessageParser() *JSONMessageParser {
+	return &JSONMessageParser{
+		buffer:  make([]byte, 0, 4096),
+		maxSize: DefaultMaxMessageSize,
+	}
+}
+
+// SetMaxMessageSize 设置单条消息的最大长度，小于等于0时使用默认值
+func (p *JSONMessageParser) SetMaxMessageSize(size int) {
+	if size <= 0 {
+		size = DefaultMaxMessageSize
+	}
+	p.maxSize = size
+}
+
+// Reset 丢弃所有未完成的输入
+func (p *JSONMessageParser) Reset() {
+	p.buffer = p.buffer[:0]
+	p.inString = false
+	p.escaped = false
+	p.braceLevel = 0
+	p.discarding = false
+	p.garbage = false
+}
+
+// Resyncs 返回收到0xFF重新同步字节的次数
+func (p *JSONMessageParser) Resyncs() int {
+	return p.resyncs
+}
+
+// resync 处理0xFF重新同步字节，丢弃未完成的输入
+func (p *JSONMessageParser) resync() {
+	p.resyncs++
+	logrus.WithFields(logrus.Fields{
+		"discarded_bytes": len(p.buffer),
+		"resyncs":         p.resyncs,
+	}).Info("收到0xFF重新同步字节，丢弃未完成的输入")
+	p.Reset()
+}
+
+// Pending 返回缓冲区中未完成消息的字节数
+func (p *JSONMessageParser) Pending() int {
+	return len(p.buffer)
+}
+
+// Feed 输入一段数据，按输入顺序返回其中已经完整的消息和解析错误
+// 未完成的消息保留在解析器中，等待后续数据；遇到0xFF时丢弃未完成的输入
+func (p *JSONMessageParser) Feed(data []byte) []ParsedMessage {
+	var results []ParsedMessage
+
+	for _, c := range data {
+		if c == resyncByte {
+			p.resync()
+			continue
+		}
+
+		if p.braceLevel == 0 {
+			switch c {
+			case ' ', '\t', '\r', '\n':
+				continue
+			case '{', '[':
+				p.garbage = false
+				p.braceLevel = 1
+				p.buffer = append(p.buffer[:0], c)
+			default:
+				// 同一段连续的无效输入只报告一次
+				if !p.garbage {
+					p.garbage = true
+					results = append(results, ParsedMessage{Err: ErrUnexpectedInput})
+				}
+			}
+			continue
+		}
+
+		if !p.discarding {
+			p.buffer = append(p.buffer, c)
+			if len(p.buffer) > p.maxSize {
+				// 继续跟踪括号层级，直到这条超长消息结束
+				p.discarding = true
+				p.buffer = p.buffer[:0]
+				results = append(results, ParsedMessage{
+					Err: fmt.Errorf("%w (%d bytes)", ErrMessageTooLarge, p.maxSize),
+				})
+			}
+		}
+
+		if p.inString {
+			switch {
+			case p.escaped:
+				p.escaped = false
+			case c == '\\':
+				p.escaped = true
+			case c == '"':
+				p.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			p.inString = true
+		case '{', '[':
+			p.braceLevel++
+		case '}', ']':
+			p.braceLevel--
+			if p.braceLevel == 0 {
+				if !p.discarding {
+					message := make([]byte, len(p.buffer))
+					copy(message, p.buffer)
+					results = append(results, ParsedMessage{Data: message})
+				}
+				p.discarding = false
+				p.buffer = p.buffer[:0]
+			}
+		}
+	}
+
+	return results
+}
+
+// ParseMessage 解析JSON消息
+func (p *JSONMessageParser) ParseMessage(data []byte) (*QMPMessage, error) {
+	var message QMPMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
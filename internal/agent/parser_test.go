@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// feedAll 依次输入各段数据，返回全部消息和错误（错误记为 "error: ..."）
+func feedAll(p *JSONMessageParser, chunks []string) []string {
+	var got []string
+	for _, chunk := range chunks {
+		for _, message := range p.Feed([]byte(chunk)) {
+			if message.Err != nil {
+				got = append(got, "error: "+message.Err.Error())
+				continue
+			}
+			got = append(got, string(message.Data))
+		}
+	}
+	return got
+}
+
+func TestParserResync(t *testing.T) {
+	ping := `{"execute":"guest-ping"}`
+	unexpected := "error: " + ErrUnexpectedInput.Error()
+
+	tests := []struct {
+		name    string
+		chunks  []string
+		want    []string
+		resyncs int
+		pending int
+	}{
+		{
+			name:    "no resync",
+			chunks:  []string{`{"execute":`, `"guest-ping"}`},
+			want:    []string{ping},
+			resyncs: 0,
+		},
+		{
+			name:    "0xFF in the middle of an object",
+			chunks:  []string{`{"execute":{"a":`, "\xff", ping},
+			want:    []string{ping},
+			resyncs: 1,
+		},
+		{
+			name:    "0xFF inside a string",
+			chunks:  []string{`{"execute":"guest-` + "\xff" + `ping"}`, ping},
+			want:    []string{unexpected, ping},
+			resyncs: 1,
+		},
+		{
+			name:    "partial message discarded, next one split across reads",
+			chunks:  []string{`{"execute":"guest-info","argu`, "\xff", `{"execute":"guest`, `-ping"}`},
+			want:    []string{ping},
+			resyncs: 1,
+		},
+		{
+			name:    "delimiter before the first message",
+			chunks:  []string{"\xff" + ping + "\n"},
+			want:    []string{ping},
+			resyncs: 1,
+		},
+		{
+			name:    "repeated delimiters are counted",
+			chunks:  []string{"\xff\xff", `{"a":1`, "\xff", ping, "\xff"},
+			want:    []string{ping},
+			resyncs: 4,
+		},
+		{
+			name:    "partial message left after delimiter",
+			chunks:  []string{ping, "\xff", `{"execute":`},
+			want:    []string{ping},
+			resyncs: 1,
+			pending: len(`{"execute":`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewJSONMessageParser()
+			got := feedAll(p, tt.chunks)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if p.Resyncs() != tt.resyncs {
+				t.Errorf("Resyncs() = %d, want %d", p.Resyncs(), tt.resyncs)
+			}
+			if p.Pending() != tt.pending {
+				t.Errorf("Pending() = %d, want %d", p.Pending(), tt.pending)
+			}
+		})
+	}
+}
+
+func TestParserResyncEndsOversizedMessage(t *testing.T) {
+	p := NewJSONMessageParser()
+	p.SetMaxMessageSize(16)
+
+	got := p.Feed([]byte(`{"execute":"guest-info","arguments":{}`))
+	if len(got) != 1 || !errors.Is(got[0].Err, ErrMessageTooLarge) {
+		t.Fatalf("Feed() = %+v, want one ErrMessageTooLarge", got)
+	}
+
+	// 重新同步后不再丢弃输入
+	got = p.Feed([]byte("\xff{\"a\":1}"))
+	if len(got) != 1 || string(got[0].Data) != `{"a":1}` {
+		t.Errorf("Feed() after resync = %+v, want {\"a\":1}", got)
+	}
+}
+
+func TestParserFraming(t *testing.T) {
+	ping := `{"execute":"guest-ping"}`
+	info := `{"execute":"guest-info","id":2}`
+	unexpected := "error: " + ErrUnexpectedInput.Error()
+
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{
+			name:   "several objects in one read",
+			chunks: []string{ping + info + ping},
+			want:   []string{ping, info, ping},
+		},
+		{
+			name:   "object split across reads",
+			chunks: []string{`{"exec`, `ute":"guest-info",`, `"id":2}`},
+			want:   []string{info},
+		},
+		{
+			name:   "one read ends a message and starts the next",
+			chunks: []string{`{"execute":"guest-p`, `ing"}{"execute":"guest-info",`, `"id":2}`},
+			want:   []string{ping, info},
+		},
+		{
+			name:   "braces inside strings",
+			chunks: []string{`{"a":"}{]["}`},
+			want:   []string{`{"a":"}{]["}`},
+		},
+		{
+			name:   "escaped quotes inside strings",
+			chunks: []string{`{"a":"say \"}\" twice"}`, ping},
+			want:   []string{`{"a":"say \"}\" twice"}`, ping},
+		},
+		{
+			name:   "escaped backslash before the closing quote",
+			chunks: []string{`{"path":"C:\\"}`, ping},
+			want:   []string{`{"path":"C:\\"}`, ping},
+		},
+		{
+			name:   "escape split across reads",
+			chunks: []string{`{"a":"x\`, `"}"}`},
+			want:   []string{`{"a":"x\"}"}`},
+		},
+		{
+			name:   "nested objects and arrays",
+			chunks: []string{`{"a":[{"b":[1,2]},{}]}`},
+			want:   []string{`{"a":[{"b":[1,2]},{}]}`},
+		},
+		{
+			name:   "whitespace between messages",
+			chunks: []string{" \r\n\t" + ping + "\n\n", "  \t" + info + "\r\n"},
+			want:   []string{ping, info},
+		},
+		{
+			name:   "garbage between messages is reported once",
+			chunks: []string{ping + "junk", "more" + info},
+			want:   []string{ping, unexpected, info},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := feedAll(NewJSONMessageParser(), tt.chunks)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParserByteAtATime(t *testing.T) {
+	input := `{"a":"}\"{"} [1,{"b":2}]` + "\n" + `{"execute":"guest-ping"}`
+	var chunks []string
+	for i := range input {
+		chunks = append(chunks, input[i:i+1])
+	}
+	got := feedAll(NewJSONMessageParser(), chunks)
+	want := []string{`{"a":"}\"{"}`, `[1,{"b":2}]`, `{"execute":"guest-ping"}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+}
+
+func TestParserOversizedMessageRecovery(t *testing.T) {
+	p := NewJSONMessageParser()
+	p.SetMaxMessageSize(32)
+	ping := `{"execute":"guest-ping"}`
+
+	// 超长消息中的字符串包含括号，丢弃时仍然要正确跟踪层级
+	oversized := `{"execute":"guest-exec","arguments":{"path":"}}}}","arg":["` +
+		strings.Repeat("x", 100) + `"]}}`
+	got := feedAll(p, []string{oversized[:40], oversized[40:] + ping})
+	want := []string{"error: " + ErrMessageTooLarge.Error() + " (32 bytes)", ping}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+	if p.Pending() != 0 {
+		t.Errorf("Pending() = %d after recovery", p.Pending())
+	}
+
+	// 恰好等于上限的消息不会被丢弃
+	exact := `{"a":"` + strings.Repeat("y", 32-len(`{"a":""}`)) + `"}`
+	if got := feedAll(p, []string{exact}); !reflect.DeepEqual(got, []string{exact}) {
+		t.Errorf("message of exactly the limit = %q", got)
+	}
+}
@@ -1,7 +1,7 @@
 package agent
 
 import (
-	"encoding/json"
+	"mac-guest-agent/internal/transport"
 	"sync"
 	"time"
 
@@ -13,7 +13,7 @@ type GAState struct {
 	// 核心组件
 	Parser       *JSONMessageParser
 	MainLoop     *MainLoop
-	Channel      Channel
+	Transport    transport.Transport
 	CommandState *CommandState
 
 	// 状态标志
@@ -47,15 +47,6 @@ type CommandState struct {
 	mutex            sync.RWMutex
 }
 
-// JSONMessageParser JSON消息解析器
-type JSONMessageParser struct {
-	buffer     []byte
-	tokenStart int
-	inString   bool
-	escaped    bool
-	braceLevel int
-}
-
 // MainLoop 主循环管理
 type MainLoop struct {
 	running   bool
@@ -93,13 +84,6 @@ func NewGAState() *GAState {
 	}
 }
 
-// NewJSONMessageParser 创建JSON消息解析器
-func NewJSONMessageParser() *JSONMessageParser {
-	return &JSONMessageParser{
-		buffer: make([]byte, 0, 4096),
-	}
-}
-
 // NewMainLoop 创建主循环
 func NewMainLoop() *MainLoop {
 	return &MainLoop{
@@ -167,10 +151,33 @@ func (s *GAState) GetFdHandle() int64 {
 }
 
 // IsCommandAllowed 检查命令是否被允许
+// 先应用运维配置的黑名单和白名单，冻结状态下再限制为特定命令，
+// 被禁用的命令在冻结时也不能执行
 func (s *GAState) IsCommandAllowed(cmdName string) bool {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	// 检查黑名单
+	for _, blocked := range s.BlockedRPCs {
+		if cmdName == blocked {
+			return false
+		}
+	}
+
+	// 如果有白名单，检查是否在白名单中
+	if len(s.AllowedRPCs) > 0 {
+		listed := false
+		for _, allowed := range s.AllowedRPCs {
+			if cmdName == allowed {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			return false
+		}
+	}
+
 	// 如果处于冻结状态，只允许特定命令
 	if s.Frozen {
 		allowedWhenFrozen := []string{
@@ -189,23 +196,6 @@ func (s *GAState) IsCommandAllowed(cmdName string) bool {
 		return false
 	}
 
-	// 检查黑名单
-	for _, blocked := range s.BlockedRPCs {
-		if cmdName == blocked {
-			return false
-		}
-	}
-
-	// 如果有白名单，检查是否在白名单中
-	if len(s.AllowedRPCs) > 0 {
-		for _, allowed := range s.AllowedRPCs {
-			if cmdName == allowed {
-				return true
-			}
-		}
-		return false
-	}
-
 	return true
 }
 
@@ -243,16 +233,6 @@ func (s *GAState) CleanupCommandState() {
 	}
 }
 
-// ParseMessage 解析JSON消息
-func (p *JSONMessageParser) ParseMessage(data []byte) (*QMPMessage, error) {
-	// 简单的JSON解析实现
-	var message QMPMessage
-	if err := json.Unmarshal(data, &message); err != nil {
-		return nil, err
-	}
-	return &message, nil
-}
-
 // QMPMessage QMP消息结构
 type QMPMessage struct {
 	Execute   string      `json:"execute,omitempty"`
@@ -0,0 +1,83 @@
+package agent
+
+import "testing"
+
+func TestIsCommandAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocked []string
+		allowed []string
+		frozen  bool
+		want    map[string]bool
+	}{
+		{
+			name: "no lists",
+			want: map[string]bool{"guest-ping": true, "guest-exec": true},
+		},
+		{
+			name:    "blocked",
+			blocked: []string{"guest-exec"},
+			want:    map[string]bool{"guest-ping": true, "guest-exec": false},
+		},
+		{
+			name:    "allowed",
+			allowed: []string{"guest-ping", "guest-info"},
+			want:    map[string]bool{"guest-ping": true, "guest-info": true, "guest-exec": false},
+		},
+		{
+			name:    "blocked wins over allowed",
+			blocked: []string{"guest-info"},
+			allowed: []string{"guest-ping", "guest-info"},
+			want:    map[string]bool{"guest-ping": true, "guest-info": false},
+		},
+		{
+			name:   "frozen",
+			frozen: true,
+			want: map[string]bool{
+				"guest-ping":          true,
+				"guest-fsfreeze-thaw": true,
+				"guest-file-write":    false,
+				"guest-exec":          false,
+			},
+		},
+		{
+			// 冻结时允许的命令仍受黑名单限制
+			name:    "frozen and blocked",
+			blocked: []string{"guest-info", "guest-fsfreeze-status"},
+			frozen:  true,
+			want: map[string]bool{
+				"guest-ping":            true,
+				"guest-info":            false,
+				"guest-fsfreeze-status": false,
+				"guest-fsfreeze-thaw":   true,
+			},
+		},
+		{
+			// 冻结时只允许同时在白名单中的命令
+			name:    "frozen and allowed",
+			allowed: []string{"guest-ping", "guest-fsfreeze-thaw", "guest-file-write"},
+			frozen:  true,
+			want: map[string]bool{
+				"guest-ping":            true,
+				"guest-fsfreeze-thaw":   true,
+				"guest-fsfreeze-status": false,
+				"guest-info":            false,
+				"guest-file-write":      false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewGAState()
+			s.BlockedRPCs = tt.blocked
+			s.AllowedRPCs = tt.allowed
+			s.SetFrozen(tt.frozen)
+			for command, want := range tt.want {
+				if got := s.IsCommandAllowed(command); got != want {
+					t.Errorf("IsCommandAllowed(%q) = %v, 期望 %v", command, got, want)
+				}
+			}
+		})
+	}
+}
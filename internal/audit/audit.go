@@ -0,0 +1,186 @@
+// Package audit 以JSON Lines格式记录Agent执行的每条命令，
+// 与普通日志分开保存，便于审计和机器处理。
+//
+// 日志文件超过大小上限时轮转为 <path>.1、<path>.2 ……，
+// 最多保留 MaxBackups 个旧文件，总大小不超过 MaxSize*(MaxBackups+1)。
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPath 默认的审计日志文件
+	DefaultPath = "/var/log/mac-guest-agent-audit.log"
+
+	// DefaultMaxSize 单个审计日志文件的默认大小上限（字节）
+	DefaultMaxSize = 10 << 20
+
+	// DefaultMaxBackups 默认保留的旧审计日志文件数
+	DefaultMaxBackups = 5
+)
+
+// 命令的执行结果
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Entry 一条审计记录
+type Entry struct {
+	Time       time.Time   `json:"time"`
+	Command    string      `json:"command"`
+	ID         interface{} `json:"id,omitempty"`
+	Arguments  interface{} `json:"arguments,omitempty"`
+	Outcome    string      `json:"outcome"`
+	ErrorClass string      `json:"error-class,omitempty"`
+	DurationMs float64     `json:"duration-ms"`
+}
+
+// Config 审计日志的配置
+type Config struct {
+	// Path 审计日志文件路径
+	Path string
+
+	// MaxSize 单个文件的大小上限（字节），为0时使用 DefaultMaxSize
+	MaxSize int64
+
+	// MaxBackups 轮转后保留的旧文件数，为0时不保留
+	MaxBackups int
+}
+
+// Logger 审计日志写入器，可以被多个协程同时使用
+type Logger struct {
+	mu     sync.Mutex
+	config Config
+	file   *os.File
+	size   int64
+}
+
+// Open 打开（必要时创建）审计日志文件，新记录追加到文件末尾
+func Open(config Config) (*Logger, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("审计日志路径为空")
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultMaxSize
+	}
+	if config.MaxBackups < 0 {
+		config.MaxBackups = 0
+	}
+
+	l := &Logger{config: config}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open 打开日志文件并记录其当前大小
+func (l *Logger) open() error {
+	// 审计记录可能包含用户名等信息，只允许root读取
+	file, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Record 写入一条审计记录，必要时先轮转日志文件
+func (l *Logger) Record(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	// 轮转失败时记录仍写入当前文件，不丢失
+	var rotateErr error
+	if l.size > 0 && l.size+int64(len(line)) > l.config.MaxSize {
+		if err := l.rotate(); err != nil {
+			rotateErr = fmt.Errorf("轮转审计日志失败: %v", err)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// rotate 依次重命名旧文件并打开新文件。新文件打开之前一直保留当前文件，
+// 失败时当前文件仍在原路径上可用
+func (l *Logger) rotate() error {
+	if l.config.MaxBackups == 0 {
+		// 不保留旧文件时直接清空当前文件
+		if err := l.file.Truncate(0); err != nil {
+			return err
+		}
+		l.size = 0
+		return nil
+	}
+
+	path := l.config.Path
+	// 最旧的文件被覆盖
+	for i := l.config.MaxBackups - 1; i >= 1; i-- {
+		err := os.Rename(backupPath(path, i), backupPath(path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// 当前文件可能已被外部删除，此时直接打开新文件
+	err := os.Rename(path, backupPath(path, 1))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	renamed := err == nil
+
+	old := l.file
+	if err := l.open(); err != nil {
+		if !renamed {
+			return err
+		}
+		// 把当前文件放回原路径，继续写入
+		if restoreErr := os.Rename(backupPath(path, 1), path); restoreErr != nil {
+			return fmt.Errorf("%v; 恢复 %s 失败: %v", err, path, restoreErr)
+		}
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+// backupPath 返回第n个旧文件的路径
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Close 关闭审计日志文件
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,242 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestLog 在临时目录中打开审计日志，测试结束时关闭
+func openTestLog(t *testing.T, maxSize int64, maxBackups int) (*Logger, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(Config{Path: path, MaxSize: maxSize, MaxBackups: maxBackups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, path
+}
+
+// record 写入第n条记录，命令名为 cmd-n
+func record(t *testing.T, l *Logger, n int) error {
+	t.Helper()
+	return l.Record(Entry{
+		Time:    time.Unix(int64(n), 0).UTC(),
+		Command: fmt.Sprintf("cmd-%d", n),
+		Outcome: OutcomeSuccess,
+	})
+}
+
+// readEntries 读取日志文件中的全部记录，文件不存在时返回nil
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("%s 中的记录无法解析: %q", path, scanner.Text())
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// commands 返回记录的命令名
+func commands(entries []Entry) []string {
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Command)
+	}
+	return names
+}
+
+// lineSize 返回一条记录占用的字节数
+func lineSize(t *testing.T, n int) int64 {
+	t.Helper()
+	line, err := json.Marshal(Entry{Time: time.Unix(int64(n), 0).UTC(), Command: fmt.Sprintf("cmd-%d", n), Outcome: OutcomeSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(len(line) + 1)
+}
+
+func TestRecordFormat(t *testing.T) {
+	l, path := openTestLog(t, 0, 0)
+	err := l.Record(Entry{
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Command:    "guest-file-open",
+		ID:         "req-1",
+		Arguments:  map[string]interface{}{"path": "/tmp/x"},
+		Outcome:    OutcomeError,
+		ErrorClass: "GenericError",
+		DurationMs: 1.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"time":"2024-01-02T03:04:05Z","command":"guest-file-open","id":"req-1","arguments":{"path":"/tmp/x"},"outcome":"error","error-class":"GenericError","duration-ms":1.5}` + "\n"
+	if string(data) != want {
+		t.Errorf("记录 = %s, 期望 %s", data, want)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("审计日志权限 = %v, 期望 0600", mode)
+	}
+}
+
+func TestOpenAppends(t *testing.T) {
+	l, path := openTestLog(t, 0, 0)
+	record(t, l, 1)
+	l.Close()
+
+	l, err := Open(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, l, 2)
+	l.Close()
+
+	if got := commands(readEntries(t, path)); strings.Join(got, ",") != "cmd-1,cmd-2" {
+		t.Errorf("记录 = %v, 期望追加在原有记录之后", got)
+	}
+	// 关闭后不再写入
+	if err := record(t, l, 3); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("关闭后写入的错误 = %v, 期望 os.ErrClosed", err)
+	}
+}
+
+func TestRotateAtMaxSize(t *testing.T) {
+	// 每个文件恰好容纳两条记录
+	maxSize := 2 * lineSize(t, 1)
+	l, path := openTestLog(t, maxSize, 2)
+
+	for n := 1; n <= 7; n++ {
+		if err := record(t, l, n); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() > maxSize {
+			t.Fatalf("第%d条记录后文件大小超过上限: %v", n, err)
+		}
+	}
+
+	// 旧文件依次后移，最旧的记录被丢弃
+	for _, test := range []struct {
+		path string
+		want string
+	}{
+		{path, "cmd-7"},
+		{backupPath(path, 1), "cmd-5,cmd-6"},
+		{backupPath(path, 2), "cmd-3,cmd-4"},
+	} {
+		if got := strings.Join(commands(readEntries(t, test.path)), ","); got != test.want {
+			t.Errorf("%s 中的记录 = %s, 期望 %s", filepath.Base(test.path), got, test.want)
+		}
+	}
+	if _, err := os.Stat(backupPath(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("保留了超过 MaxBackups 个旧文件: %v", err)
+	}
+}
+
+func TestRotateWithoutBackups(t *testing.T) {
+	maxSize := 2 * lineSize(t, 1)
+	l, path := openTestLog(t, maxSize, 0)
+
+	for n := 1; n <= 5; n++ {
+		if err := record(t, l, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Join(commands(readEntries(t, path)), ","); got != "cmd-5" {
+		t.Errorf("记录 = %s, 期望只有 cmd-5", got)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("MaxBackups 为0时留下了旧文件: %v", matches)
+	}
+}
+
+func TestRotateFailureKeepsLogging(t *testing.T) {
+	maxSize := 2 * lineSize(t, 1)
+	l, path := openTestLog(t, maxSize, 1)
+
+	// 旧文件的位置被一个非空目录占用，无法重命名
+	blocker := backupPath(path, 1)
+	if err := os.MkdirAll(filepath.Join(blocker, "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n <= 2; n++ {
+		if err := record(t, l, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := record(t, l, 3)
+	if err == nil || !strings.Contains(err.Error(), "轮转审计日志失败") {
+		t.Fatalf("轮转失败时的错误 = %v", err)
+	}
+	if err := record(t, l, 4); err == nil {
+		t.Error("再次轮转失败时没有报告错误")
+	}
+
+	// 轮转失败不丢失记录
+	if got := strings.Join(commands(readEntries(t, path)), ","); got != "cmd-1,cmd-2,cmd-3,cmd-4" {
+		t.Errorf("记录 = %s, 期望 cmd-1 到 cmd-4", got)
+	}
+
+	// 恢复后继续正常轮转
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := record(t, l, 5); err != nil {
+		t.Fatalf("恢复后轮转失败: %v", err)
+	}
+	if got := strings.Join(commands(readEntries(t, path)), ","); got != "cmd-5" {
+		t.Errorf("轮转后的记录 = %s, 期望 cmd-5", got)
+	}
+	if got := commands(readEntries(t, blocker)); len(got) != 4 {
+		t.Errorf("旧文件中的记录 = %v, 期望4条", got)
+	}
+}
+
+func TestRotateAfterFileRemoved(t *testing.T) {
+	maxSize := 2 * lineSize(t, 1)
+	l, path := openTestLog(t, maxSize, 1)
+
+	record(t, l, 1)
+	record(t, l, 2)
+	// 日志文件被外部删除后，轮转时打开新文件
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := record(t, l, 3); err != nil {
+		t.Fatalf("轮转失败: %v", err)
+	}
+	if got := strings.Join(commands(readEntries(t, path)), ","); got != "cmd-3" {
+		t.Errorf("记录 = %s, 期望 cmd-3", got)
+	}
+}
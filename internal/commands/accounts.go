@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"mac-guest-agent/protocol"
+	"os/user"
+	"strings"
+	"sync"
+)
+
+// AccountBackend changes local user accounts. Commands never edit the
+// directory service directly so that the implementation can be swapped,
+// e.g. for a fake in tests.
+type AccountBackend interface {
+	// Name identifies the backend in logs.
+	Name() string
+
+	// UserExists reports whether a local account exists.
+	UserExists(ctx context.Context, username string) (bool, error)
+
+	// SetPassword sets the password of an existing account. With crypted
+	// the password is already hashed.
+	SetPassword(ctx context.Context, username, password string, crypted bool) error
+}
+
+// DsclBackend manages accounts in the local directory node with dscl(1).
+type DsclBackend struct{}
+
+// Name implements AccountBackend.
+func (DsclBackend) Name() string {
+	return "dscl"
+}
+
+// UserExists implements AccountBackend.
+func (DsclBackend) UserExists(ctx context.Context, username string) (bool, error) {
+	if _, err := user.Lookup(username); err != nil {
+		if _, ok := err.(user.UnknownUserError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// SetPassword implements AccountBackend. The local directory keeps
+// passwords as salted PBKDF2 hashes that cannot be built from a crypt(3)
+// string, so crypted passwords are not supported.
+//
+// dscl is run in interactive mode with the command on its standard input,
+// so the password never appears in the process list.
+func (DsclBackend) SetPassword(ctx context.Context, username, password string, crypted bool) error {
+	if crypted {
+		return NewError(protocol.ErrorClassUnsupported,
+			"crypted passwords are not supported by the macOS directory service")
+	}
+
+	script := fmt.Sprintf("passwd %s %s\n",
+		dsclQuote("/Users/"+username), dsclQuote(password))
+	result, err := run(ctx, Invocation{
+		Name:        "dscl",
+		Args:        []string{"."},
+		Stdin:       []byte(script),
+		MergeOutput: true,
+	})
+	if err != nil {
+		if exitErr, ok := err.(*ExitError); ok {
+			return fmt.Errorf("dscl exited with status %d", exitErr.ExitCode)
+		}
+		return err
+	}
+	// Interactive dscl exits with status 0 even if the command failed and
+	// reports the failure as "passwd: DS Error: ...".
+	for _, line := range strings.Split(string(result.Stdout), "\n") {
+		if i := strings.Index(line, "DS Error"); i >= 0 {
+			return fmt.Errorf("dscl failed to set the password: %s", strings.TrimSpace(line[i:]))
+		}
+	}
+	return nil
+}
+
+// dsclQuote quotes an argument for dscl's interactive command parser.
+func dsclQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+var (
+	accountBackend      AccountBackend = DsclBackend{}
+	accountBackendMutex sync.RWMutex
+)
+
+// SetAccountBackend replaces the backend used to change accounts. A nil
+// backend restores DsclBackend.
+func SetAccountBackend(b AccountBackend) {
+	accountBackendMutex.Lock()
+	defer accountBackendMutex.Unlock()
+	if b == nil {
+		b = DsclBackend{}
+	}
+	accountBackend = b
+}
+
+// currentAccountBackend returns the backend used to change accounts.
+func currentAccountBackend() AccountBackend {
+	accountBackendMutex.RLock()
+	defer accountBackendMutex.RUnlock()
+	return accountBackend
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"mac-guest-agent/protocol"
+	"strings"
+	"testing"
+)
+
+func TestDsclSetPassword(t *testing.T) {
+	tests := []struct {
+		fixture string
+		crypted bool
+		wantErr string
+	}{
+		{fixture: "dscl_passwd.json"},
+		{fixture: "dscl_passwd_ds_error.json", wantErr: "eDSAuthPasswordQualityCheckFailed"},
+		{fixture: "dscl_missing.json", wantErr: "executable file not found"},
+		{fixture: "dscl_passwd.json", crypted: true, wantErr: "crypted passwords are not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			useFixtures(t, tt.fixture)
+			recorder := NewRecordingRunner(CurrentRunner())
+			useRunner(t, recorder)
+
+			err := DsclBackend{}.SetPassword(context.Background(), "admin", `pa"ss\word`, tt.crypted)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("SetPassword() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("SetPassword() error = %v, want %q", err, tt.wantErr)
+			}
+
+			// The password is passed on standard input, never in argv
+			for _, f := range recorder.Fixtures() {
+				if strings.Contains(strings.Join(f.Argv, " "), "word") {
+					t.Errorf("password in argv: %q", f.Argv)
+				}
+			}
+		})
+	}
+}
+
+func TestDsclSetPasswordCryptedIsUnsupported(t *testing.T) {
+	useRunner(t, NewFixtureRunner(nil))
+	err := DsclBackend{}.SetPassword(context.Background(), "admin", "$6$salt$hash", true)
+	var cmdErr *Error
+	if !errors.As(err, &cmdErr) || cmdErr.Class != protocol.ErrorClassUnsupported {
+		t.Errorf("SetPassword() error = %v, want an Unsupported error", err)
+	}
+}
+
+func TestDsclQuote(t *testing.T) {
+	tests := map[string]string{
+		`secret`:      `"secret"`,
+		`pa"ss`:       `"pa\"ss"`,
+		`back\slash`:  `"back\\slash"`,
+		`with space`:  `"with space"`,
+		`/Users/adam`: `"/Users/adam"`,
+	}
+	for in, want := range tests {
+		if got := dsclQuote(in); got != want {
+			t.Errorf("dsclQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"fmt"
+	"mac-guest-agent/internal/audit"
+	"mac-guest-agent/protocol"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	auditLog      *audit.Logger
+	auditLogMutex sync.RWMutex
+)
+
+// SetAuditLog sets the log every executed command is recorded in. A nil
+// logger disables the audit trail.
+func SetAuditLog(l *audit.Logger) {
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+	auditLog = l
+}
+
+// currentAuditLog returns the audit log, or nil.
+func currentAuditLog() *audit.Logger {
+	auditLogMutex.RLock()
+	defer auditLogMutex.RUnlock()
+	return auditLog
+}
+
+// SetArgumentSensitive marks an argument of a command as sensitive, so its
+// value is redacted from logs and the audit trail. It must be called before
+// the agent starts.
+func SetArgumentSensitive(command, arg string) error {
+	cmd, ok := CommandRegistry[command]
+	if !ok {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	for i := range cmd.Args {
+		if cmd.Args[i].Name == arg {
+			cmd.Args[i].Sensitive = true
+			return nil
+		}
+	}
+	return fmt.Errorf("command %s has no argument %q", command, arg)
+}
+
+// recordAudit writes the outcome of a request to the audit log. Heartbeat
+// commands are not recorded; the host sends them every few seconds.
+func recordAudit(req protocol.QMPRequest, resp protocol.QMPResponse, start time.Time) {
+	l := currentAuditLog()
+	if l == nil {
+		return
+	}
+	if cmd, ok := CommandRegistry[req.Execute]; ok && cmd.Class == ExecHeartbeat {
+		return
+	}
+
+	entry := audit.Entry{
+		Time:       start,
+		Command:    req.Execute,
+		ID:         req.ID,
+		Arguments:  RedactArguments(req.Execute, req.Arguments),
+		Outcome:    audit.OutcomeSuccess,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if resp.Error != nil {
+		entry.Outcome = audit.OutcomeError
+		entry.ErrorClass = resp.Error.Class
+	}
+	if err := l.Record(entry); err != nil {
+		log.WithError(err).Error("Failed to write audit log")
+	}
+}
@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"mac-guest-agent/internal/audit"
+	"mac-guest-agent/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useAuditLog records commands in a temporary audit log for the duration of
+// a test and returns its path.
+func useAuditLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := audit.Open(audit.Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetAuditLog(l)
+	t.Cleanup(func() {
+		SetAuditLog(nil)
+		l.Close()
+	})
+	return path
+}
+
+// auditRecords returns the records in the audit log at path as raw lines
+// and decoded entries.
+func auditRecords(t *testing.T, path string) ([]string, []map[string]interface{}) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var lines []string
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid audit record %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, scanner.Text())
+		records = append(records, record)
+	}
+	return lines, records
+}
+
+func TestAuditRedactsSensitiveArguments(t *testing.T) {
+	path := useAuditLog(t)
+	registerTestCommand(t, &Command{
+		Name: "test-audit-secret",
+		Args: []Arg{
+			{Name: "username", Type: TypeString},
+			{Name: "password", Type: TypeString, Sensitive: true},
+		},
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			return protocol.EmptyResponse{}, nil
+		},
+	})
+
+	HandleCommand(context.Background(), protocol.QMPRequest{
+		Execute:   "test-audit-secret",
+		Arguments: map[string]interface{}{"username": "alice", "password": "hunter2"},
+		ID:        "req-1",
+	})
+	// Rejected requests are recorded, redacted, as well
+	HandleCommand(context.Background(), protocol.QMPRequest{
+		Execute:   "test-audit-secret",
+		Arguments: map[string]interface{}{"password": "hunter3"},
+		ID:        "req-2",
+	})
+	// The arguments of unknown commands are redacted as a whole
+	HandleCommand(context.Background(), protocol.QMPRequest{
+		Execute:   "test-no-such-command",
+		Arguments: map[string]interface{}{"token": "hunter4"},
+		ID:        "req-3",
+	})
+
+	lines, records := auditRecords(t, path)
+	if len(records) != 3 {
+		t.Fatalf("got %d audit records, want 3: %q", len(records), lines)
+	}
+	for _, line := range lines {
+		if strings.Contains(line, "hunter") {
+			t.Errorf("audit record holds a secret: %s", line)
+		}
+	}
+
+	args, _ := records[0]["arguments"].(map[string]interface{})
+	if args["username"] != "alice" || args["password"] != redactedValue {
+		t.Errorf("arguments = %v, want the username and a redacted password", records[0]["arguments"])
+	}
+	if records[0]["outcome"] != audit.OutcomeSuccess || records[0]["id"] != "req-1" {
+		t.Errorf("record = %v, want a success for req-1", records[0])
+	}
+	if records[1]["outcome"] != audit.OutcomeError || records[1]["error-class"] != protocol.ErrorClassMissingParameter {
+		t.Errorf("record = %v, want a %s", records[1], protocol.ErrorClassMissingParameter)
+	}
+	if records[2]["arguments"] != redactedValue {
+		t.Errorf("arguments of an unknown command = %v, want %q", records[2]["arguments"], redactedValue)
+	}
+}
+
+func TestAuditSkipsHeartbeats(t *testing.T) {
+	path := useAuditLog(t)
+
+	HandleCommand(context.Background(), protocol.QMPRequest{Execute: "guest-ping"})
+	HandleCommand(context.Background(), protocol.QMPRequest{Execute: "guest-sync", Arguments: map[string]int{"id": 1}})
+	HandleCommand(context.Background(), protocol.QMPRequest{Execute: "guest-info"})
+
+	_, records := auditRecords(t, path)
+	if len(records) != 1 || records[0]["command"] != "guest-info" {
+		t.Errorf("audit records = %v, want only guest-info", records)
+	}
+}
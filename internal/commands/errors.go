@@ -0,0 +1,23 @@
+package commands
+
+import "fmt"
+
+// Error is a command failure that carries a QMP error class. Handlers return
+// it when the host should see something more specific than GenericError.
+type Error struct {
+	Class string
+	Desc  string
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Desc
+}
+
+// NewError creates an Error with a formatted description.
+func NewError(class, format string, args ...interface{}) *Error {
+	return &Error{
+		Class: class,
+		Desc:  fmt.Sprintf(format, args...),
+	}
+}
@@ -1,66 +1,131 @@
 package commands
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
+	"mac-guest-agent/protocol"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// ExecProcess represents a process executed via guest-exec.
-type ExecProcess struct {
-	PID      int       `json:"pid"`
-	Exited   bool      `json:"exited"`
-	ExitCode int       `json:"exitcode"`
-	OutData  string    `json:"out-data,omitempty"`
-	ErrData  string    `json:"err-data,omitempty"`
-	Time     time.Time `json:"-"` // Internal use only
-}
+const (
+	// execMaxOutput caps the captured output per stream, matching upstream
+	// qemu-ga. Anything beyond it is dropped and reported as truncated.
+	execMaxOutput = 16 * 1024 * 1024
 
-// GuestExecArgs represents the arguments for the guest-exec command.
-type GuestExecArgs struct {
-	Path          string   `json:"path"`
-	Arg           []string `json:"arg,omitempty"`
-	Env           []string `json:"env,omitempty"`
-	InputData     string   `json:"input-data,omitempty"`
-	CaptureOutput bool     `json:"capture-output,omitempty"`
-}
+	// execRetention is how long the result of a process nobody asked about
+	// is kept before it is discarded.
+	execRetention = 30 * time.Minute
 
-// GuestExecStatusArgs represents the arguments for the guest-exec-status command.
-type GuestExecStatusArgs struct {
-	PID int `json:"pid"`
-}
+	// DefaultExecTimeout is how long a program started by guest-exec may
+	// run before its process group is killed.
+	DefaultExecTimeout = time.Hour
+
+	// execStopTimeout bounds how long the agent waits for killed programs
+	// to exit when it stops.
+	execStopTimeout = 5 * time.Second
+)
+
+// ExecProcess tracks a process started via guest-exec. PID is the process
+// ID of the program, which also leads its own process group.
+type ExecProcess struct {
+	PID     int
+	Capture protocol.GuestExecCaptureOutputMode
+	Started time.Time
 
-// GuestExecResponse represents the response for the guest-exec command.
-type GuestExecResponse struct {
-	PID int `json:"pid"`
+	// cancel kills the process group; done is closed once the process has
+	// exited and the fields below are set.
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// The fields below are set once the process has exited.
+	Exited bool
+	Ended  time.Time
+	Result *Result
+	// Err is set if the agent could not collect the exit status.
+	Err error
 }
 
 var (
 	execProcesses      = make(map[int]*ExecProcess)
 	execProcessesMutex sync.Mutex
-	nextPID            = 1
+
+	execEnabled      bool
+	execEnabledMutex sync.RWMutex
+
+	execTimeout      = DefaultExecTimeout
+	execTimeoutMutex sync.RWMutex
 )
 
 func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-exec",
 		Handler: handleGuestExec,
+		Returns: protocol.GuestExec{},
+		Args: []Arg{
+			{Name: "path", Type: TypeString},
+			{Name: "arg", Type: TypeArray, Elem: TypeString, Optional: true},
+			{Name: "env", Type: TypeArray, Elem: TypeString, Optional: true, Sensitive: true},
+			{Name: "input-data", Type: TypeString, Optional: true, Sensitive: true},
+			{Name: "capture-output", Type: TypeBool | TypeString, Optional: true,
+				Enum: []string{"none", "stdout", "stderr", "separated", "merged"}},
+		},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-exec-status",
 		Handler: handleGuestExecStatus,
+		Returns: protocol.GuestExecStatus{},
+		Args:    []Arg{{Name: "pid", Type: TypeInt}},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 }
 
+// SetExecEnabled controls whether guest-exec may run programs. It is off by
+// default because it gives the host arbitrary code execution as root.
+func SetExecEnabled(enabled bool) {
+	execEnabledMutex.Lock()
+	defer execEnabledMutex.Unlock()
+	execEnabled = enabled
+}
+
+// ExecEnabled reports whether guest-exec may run programs.
+func ExecEnabled() bool {
+	execEnabledMutex.RLock()
+	defer execEnabledMutex.RUnlock()
+	return execEnabled
+}
+
+// SetExecTimeout changes how long a program started by guest-exec may run.
+// A non-positive value restores DefaultExecTimeout.
+func SetExecTimeout(d time.Duration) {
+	execTimeoutMutex.Lock()
+	defer execTimeoutMutex.Unlock()
+	if d <= 0 {
+		d = DefaultExecTimeout
+	}
+	execTimeout = d
+}
+
+// currentExecTimeout returns how long a program started by guest-exec may
+// run.
+func currentExecTimeout() time.Duration {
+	execTimeoutMutex.RLock()
+	defer execTimeoutMutex.RUnlock()
+	return execTimeout
+}
+
 // handleGuestExec handles the guest-exec command.
-func handleGuestExec(req json.RawMessage) (interface{}, error) {
-	var args GuestExecArgs
+func handleGuestExec(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestExecArgs
 	if err := json.Unmarshal(req, &args); err != nil {
 		return nil, fmt.Errorf("failed to parse arguments for guest-exec: %v", err)
 	}
@@ -70,68 +135,219 @@ func handleGuestExec(req json.RawMessage) (interface{}, error) {
 		"args": args.Arg,
 	}).Info("Guest exec command requested")
 
-	// 由于安全原因，macOS版本的guest-agent不支持执行命令
-	// 返回一个安全错误，而不是实际执行命令
-	return nil, fmt.Errorf("guest-exec is not supported in macOS Guest Agent for security reasons")
-}
+	if !ExecEnabled() {
+		return nil, NewError(protocol.ErrorClassCommandDisabled,
+			"The command guest-exec has been disabled for this instance; start the agent with -allow-exec to enable it")
+	}
+	if args.Path == "" {
+		return nil, fmt.Errorf("guest-exec requires a path")
+	}
 
-// handleGuestExecStatus handles the guest-exec-status command.
-func handleGuestExecStatus(req json.RawMessage) (interface{}, error) {
-	var args GuestExecStatusArgs
-	if err := json.Unmarshal(req, &args); err != nil {
-		return nil, fmt.Errorf("failed to parse arguments for guest-exec-status: %v", err)
+	inv := Invocation{
+		Name:      args.Path,
+		Args:      args.Arg,
+		MaxOutput: execMaxOutput,
+	}
+	if len(args.Env) > 0 {
+		inv.Env = args.Env
+	}
+	if args.InputData != "" {
+		input, err := base64.StdEncoding.DecodeString(args.InputData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 in input-data: %v", err)
+		}
+		inv.Stdin = input
 	}
 
-	logrus.WithField("pid", args.PID).Info("Guest exec status requested")
+	capture := protocol.GuestExecCaptureOutputNone
+	if args.CaptureOutput != nil {
+		capture = args.CaptureOutput.Mode
+	}
+	inv.MergeOutput = capture == protocol.GuestExecCaptureOutputMerged
 
-	// 由于我们不支持guest-exec，所以这里也返回一个错误
-	return nil, fmt.Errorf("guest-exec is not supported in macOS Guest Agent")
+	cleanupOldProcesses()
+	process, err := startProcess(inv, capture)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute %s: %v", args.Path, err)
+	}
+	return protocol.GuestExec{PID: process.PID}, nil
 }
 
-// 以下是实际执行命令的函数，但在macOS版本中不会被调用
-// 保留这些代码是为了未来可能的功能扩展
-func executeCommand(args GuestExecArgs) (*ExecProcess, error) {
-	cmd := exec.Command(args.Path, args.Arg...)
-	cmd.Env = args.Env
+// startProcess starts a program in the background and tracks it under its
+// process ID. An error is returned if the program could not be started.
+//
+// The program is not bound to the request's context: guest-exec returns as
+// soon as it has started and the host polls guest-exec-status. It runs until
+// it exits, the exec timeout passes or the agent stops.
+func startProcess(inv Invocation, capture protocol.GuestExecCaptureOutputMode) (*ExecProcess, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), currentExecTimeout())
 
-	var outData, errData []byte
+	started := make(chan int, 1)
+	inv.Started = func(pid int) { started <- pid }
+
+	var result *Result
 	var err error
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		result, err = CurrentRunner().Run(ctx, inv)
+	}()
 
-	if args.CaptureOutput {
-		outData, err = cmd.Output()
-	} else {
-		err = cmd.Run()
+	var pid int
+	select {
+	case pid = <-started:
+	case <-finished:
+		// A short-lived program may exit before its start is noticed
+		select {
+		case pid = <-started:
+		default:
+			cancel()
+			if err == nil {
+				err = fmt.Errorf("the runner did not report a process ID")
+			}
+			return nil, err
+		}
 	}
 
+	process := &ExecProcess{
+		PID:     pid,
+		Capture: capture,
+		Started: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
 	execProcessesMutex.Lock()
-	defer execProcessesMutex.Unlock()
+	// The ID may have been used by an earlier process whose result nobody
+	// collected; that result is lost.
+	execProcesses[pid] = process
+	execProcessesMutex.Unlock()
 
-	pid := nextPID
-	nextPID++
+	go func() {
+		<-finished
+		cancel()
 
-	process := &ExecProcess{
-		PID:      pid,
-		Exited:   true,
-		ExitCode: 0,
-		Time:     time.Now(),
+		execProcessesMutex.Lock()
+		defer execProcessesMutex.Unlock()
+		defer close(process.done)
+
+		fields := logrus.Fields{"pid": process.PID, "path": inv.Name}
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			// The agent killed the process group; keep what the program
+			// printed until then.
+			logrus.WithFields(fields).WithError(err).Warn("Guest exec process killed")
+			if result == nil {
+				result = &Result{}
+			}
+			result.ExitCode = -1
+			result.Signal = int(syscall.SIGKILL)
+			err = nil
+		}
+		process.Exited = true
+		process.Ended = time.Now()
+		process.Result = result
+		process.Err = err
+
+		if err != nil {
+			logrus.WithFields(fields).WithError(err).Warn("Guest exec process status lost")
+		} else {
+			fields["exitcode"] = result.ExitCode
+			fields["signal"] = result.Signal
+			logrus.WithFields(fields).Info("Guest exec process exited")
+		}
+	}()
+
+	return process, nil
+}
+
+// killExecProcesses kills the process groups of programs started by
+// guest-exec that are still running and waits briefly for them to exit. It
+// runs when the agent stops.
+func killExecProcesses() {
+	execProcessesMutex.Lock()
+	var running []*ExecProcess
+	for _, process := range execProcesses {
+		if !process.Exited {
+			process.cancel()
+			running = append(running, process)
+		}
 	}
+	execProcessesMutex.Unlock()
 
-	if args.CaptureOutput {
-		process.OutData = string(outData)
-		process.ErrData = string(errData)
+	deadline := time.After(execStopTimeout)
+	for _, process := range running {
+		select {
+		case <-process.done:
+		case <-deadline:
+			logrus.WithField("pid", process.PID).Warn("Guest exec process did not exit after being killed")
+			return
+		}
 	}
+}
 
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			process.ExitCode = exitError.ExitCode()
-		} else {
-			process.ExitCode = 1
-			process.ErrData = err.Error()
+// handleGuestExecStatus handles the guest-exec-status command.
+func handleGuestExecStatus(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestExecStatusArgs
+	if err := json.Unmarshal(req, &args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments for guest-exec-status: %v", err)
+	}
+
+	logrus.WithField("pid", args.PID).Debug("Guest exec status requested")
+
+	execProcessesMutex.Lock()
+	defer execProcessesMutex.Unlock()
+
+	process, ok := execProcesses[args.PID]
+	if !ok {
+		return nil, fmt.Errorf("Invalid parameter 'pid'")
+	}
+	if !process.Exited {
+		return protocol.GuestExecStatus{Exited: false}, nil
+	}
+
+	// As upstream, the result is handed out once and then forgotten.
+	delete(execProcesses, args.PID)
+	return process.status(), nil
+}
+
+// status builds the guest-exec-status response of an exited process.
+// Callers must hold execProcessesMutex.
+func (p *ExecProcess) status() protocol.GuestExecStatus {
+	status := protocol.GuestExecStatus{Exited: true}
+
+	if p.Err != nil {
+		// Report it like a shell reports a program it could not run, with
+		// the reason on stderr when the host asked for it.
+		exitCode := 127
+		status.ExitCode = &exitCode
+		if p.Capture != protocol.GuestExecCaptureOutputNone && p.Capture != protocol.GuestExecCaptureOutputStdout {
+			status.ErrData = base64.StdEncoding.EncodeToString([]byte(p.Err.Error() + "\n"))
 		}
+		return status
 	}
 
-	execProcesses[pid] = process
-	return process, nil
+	result := p.Result
+	if result.Signal != 0 {
+		signal := result.Signal
+		status.Signal = &signal
+	} else {
+		exitCode := result.ExitCode
+		status.ExitCode = &exitCode
+	}
+
+	switch p.Capture {
+	case protocol.GuestExecCaptureOutputStdout, protocol.GuestExecCaptureOutputMerged:
+		status.OutData = base64.StdEncoding.EncodeToString(result.Stdout)
+		status.OutTruncated = result.StdoutTruncated
+	case protocol.GuestExecCaptureOutputStderr:
+		status.ErrData = base64.StdEncoding.EncodeToString(result.Stderr)
+		status.ErrTruncated = result.StderrTruncated
+	case protocol.GuestExecCaptureOutputSeparated:
+		status.OutData = base64.StdEncoding.EncodeToString(result.Stdout)
+		status.OutTruncated = result.StdoutTruncated
+		status.ErrData = base64.StdEncoding.EncodeToString(result.Stderr)
+		status.ErrTruncated = result.StderrTruncated
+	}
+	return status
 }
 
 // 清理过期的进程记录
@@ -141,8 +357,8 @@ func cleanupOldProcesses() {
 
 	now := time.Now()
 	for pid, process := range execProcesses {
-		// 清理超过30分钟的进程记录
-		if now.Sub(process.Time) > 30*time.Minute {
+		// 清理结束超过30分钟且无人查询的进程记录
+		if process.Exited && now.Sub(process.Ended) > execRetention {
 			delete(execProcesses, pid)
 		}
 	}
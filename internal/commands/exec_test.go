@@ -0,0 +1,203 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mac-guest-agent/protocol"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// enableExec allows guest-exec with the given timeout for the duration of a
+// test.
+func enableExec(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	SetExecEnabled(true)
+	SetExecTimeout(timeout)
+	t.Cleanup(func() {
+		SetExecEnabled(false)
+		SetExecTimeout(0)
+	})
+}
+
+// guestExec runs guest-exec and returns the PID.
+func guestExec(t *testing.T, args protocol.GuestExecArgs) (int, error) {
+	t.Helper()
+	req, _ := json.Marshal(args)
+	result, err := handleGuestExec(context.Background(), req)
+	if err != nil {
+		return 0, err
+	}
+	return result.(protocol.GuestExec).PID, nil
+}
+
+// waitExecStatus polls guest-exec-status until the process has exited.
+func waitExecStatus(t *testing.T, pid int) protocol.GuestExecStatus {
+	t.Helper()
+	req, _ := json.Marshal(protocol.GuestExecStatusArgs{PID: pid})
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		result, err := handleGuestExecStatus(context.Background(), req)
+		if err != nil {
+			t.Fatalf("guest-exec-status: %v", err)
+		}
+		if status := result.(protocol.GuestExecStatus); status.Exited {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("process %d did not exit", pid)
+	return protocol.GuestExecStatus{}
+}
+
+func TestGuestExecReturnsProcessID(t *testing.T) {
+	enableExec(t, 0)
+	pid, err := guestExec(t, protocol.GuestExecArgs{
+		Path:          "/bin/sh",
+		Arg:           []string{"-c", "echo $$"},
+		CaptureOutput: &protocol.GuestExecCaptureOutput{Mode: protocol.GuestExecCaptureOutputStdout},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitExecStatus(t, pid)
+	out, _ := base64.StdEncoding.DecodeString(status.OutData)
+	if got, _ := strconv.Atoi(strings.TrimSpace(string(out))); got != pid {
+		t.Errorf("guest-exec returned PID %d, the program ran as %q", pid, out)
+	}
+	if status.ExitCode == nil || *status.ExitCode != 0 {
+		t.Errorf("status = %+v, want exit code 0", status)
+	}
+}
+
+func TestGuestExecFailsIfProgramCannotStart(t *testing.T) {
+	enableExec(t, 0)
+	_, err := guestExec(t, protocol.GuestExecArgs{Path: "/nonexistent/program"})
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/program") {
+		t.Errorf("guest-exec error = %v, want a start failure", err)
+	}
+}
+
+func TestGuestExecKillsProcessGroupAfterTimeout(t *testing.T) {
+	enableExec(t, 200*time.Millisecond)
+	// The background child would keep the output pipe open if only the
+	// shell were killed.
+	pid, err := guestExec(t, protocol.GuestExecArgs{
+		Path:          "/bin/sh",
+		Arg:           []string{"-c", "sleep 30 & wait"},
+		CaptureOutput: &protocol.GuestExecCaptureOutput{Mode: protocol.GuestExecCaptureOutputSeparated},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	status := waitExecStatus(t, pid)
+	if status.Signal == nil || *status.Signal != int(syscall.SIGKILL) {
+		t.Errorf("status = %+v, want signal %d", status, syscall.SIGKILL)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("process group was killed after %s", elapsed)
+	}
+}
+
+func TestKillExecProcesses(t *testing.T) {
+	enableExec(t, 0)
+	marker := filepath.Join(t.TempDir(), "survived")
+	pid, err := guestExec(t, protocol.GuestExecArgs{
+		Path: "/bin/sh",
+		Arg:  []string{"-c", `(sleep 1; touch "$0") & wait`, marker},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	killExecProcesses()
+	if elapsed := time.Since(start); elapsed > execStopTimeout {
+		t.Errorf("killExecProcesses took %s", elapsed)
+	}
+	status := waitExecStatus(t, pid)
+	if status.Signal == nil || *status.Signal != int(syscall.SIGKILL) {
+		t.Errorf("status = %+v, want signal %d", status, syscall.SIGKILL)
+	}
+
+	// The child in the background must have been killed with the shell
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("a process in the group survived the agent stopping")
+	}
+}
+
+func TestGuestExecWithFixtures(t *testing.T) {
+	enableExec(t, 0)
+	useRunner(t, NewFixtureRunner([]Fixture{
+		{Argv: []string{"/usr/bin/sw_vers", "-productVersion"}, Stdout: "14.4.1\n"},
+	}))
+
+	pid, err := guestExec(t, protocol.GuestExecArgs{
+		Path:          "/usr/bin/sw_vers",
+		Arg:           []string{"-productVersion"},
+		CaptureOutput: &protocol.GuestExecCaptureOutput{Mode: protocol.GuestExecCaptureOutputStdout},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := waitExecStatus(t, pid)
+	if out, _ := base64.StdEncoding.DecodeString(status.OutData); string(out) != "14.4.1\n" {
+		t.Errorf("out-data = %q", out)
+	}
+
+	if _, err := guestExec(t, protocol.GuestExecArgs{Path: "/usr/bin/false"}); err == nil {
+		t.Error("guest-exec succeeded for a program without a fixture")
+	}
+}
+
+func TestGuestExecKeepsOutputOfKilledProgram(t *testing.T) {
+	enableExec(t, 300*time.Millisecond)
+	pid, err := guestExec(t, protocol.GuestExecArgs{
+		Path:          "/bin/sh",
+		Arg:           []string{"-c", "echo started; echo warning >&2; sleep 30"},
+		CaptureOutput: &protocol.GuestExecCaptureOutput{Mode: protocol.GuestExecCaptureOutputSeparated},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitExecStatus(t, pid)
+	if status.Signal == nil || *status.Signal != int(syscall.SIGKILL) || status.ExitCode != nil {
+		t.Errorf("status = %+v, want signal %d", status, syscall.SIGKILL)
+	}
+	out, _ := base64.StdEncoding.DecodeString(status.OutData)
+	errData, _ := base64.StdEncoding.DecodeString(status.ErrData)
+	if string(out) != "started\n" || string(errData) != "warning\n" {
+		t.Errorf("out-data = %q, err-data = %q; want what the program printed before it was killed", out, errData)
+	}
+}
+
+func TestGuestExecDisabled(t *testing.T) {
+	SetExecEnabled(false)
+	cmd := CommandRegistry["guest-exec"]
+	if advertised(cmd) {
+		t.Error("guest-exec is advertised without -allow-exec")
+	}
+
+	_, err := guestExec(t, protocol.GuestExecArgs{Path: "/bin/true"})
+	var cmdErr *Error
+	if !errors.As(err, &cmdErr) || cmdErr.Class != protocol.ErrorClassCommandDisabled {
+		t.Errorf("guest-exec error = %v, want CommandDisabled", err)
+	}
+
+	enableExec(t, 0)
+	if !advertised(cmd) {
+		t.Error("guest-exec is not advertised with -allow-exec")
+	}
+}
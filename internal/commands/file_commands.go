@@ -0,0 +1,449 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mac-guest-agent/protocol"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxFileHandles caps the number of simultaneously open guest files.
+	maxFileHandles = 128
+
+	// fileHandleIdleTimeout is how long a handle may go unused before it is
+	// considered leaked and closed.
+	fileHandleIdleTimeout = 30 * time.Minute
+
+	// defaultFileReadCount is the number of bytes guest-file-read returns
+	// when the host does not specify a count, as upstream.
+	defaultFileReadCount = 4096
+
+	// maxFileReadCount caps a single guest-file-read, as upstream.
+	maxFileReadCount = 48 * 1024 * 1024
+)
+
+// fileReapInterval is how often idle handles are looked for while any are
+// open. It is guarded by fileHandlesMutex and changed only by tests.
+var fileReapInterval = time.Minute
+
+// guestFile is an open guest-file-* handle.
+//
+// mutex serialises I/O on the handle, so a read blocked on a FIFO only
+// delays other commands using the same handle. The fields below it are
+// guarded by fileHandlesMutex.
+type guestFile struct {
+	file  *os.File
+	path  string
+	mutex sync.Mutex
+	eof   bool // guarded by mutex
+
+	lastUsed time.Time
+	// busy counts commands doing I/O on the handle; busy handles are
+	// never considered idle.
+	busy int
+}
+
+// fileHandleArgs is the schema of commands taking only a handle.
+var fileHandleArgs = []Arg{{Name: "handle", Type: TypeInt}}
+
+var (
+	fileHandles      = make(map[int64]*guestFile)
+	fileHandlesMutex sync.Mutex
+	localFileHandle  int64 = 1000
+
+	// fileReaper closes idle handles; it is armed while any are open.
+	fileReaper *time.Timer
+)
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "guest-file-open",
+		Handler: handleGuestFileOpen,
+		Returns: int64(0),
+		Args: []Arg{
+			{Name: "path", Type: TypeString},
+			{Name: "mode", Type: TypeString, Optional: true},
+		},
+		Enabled: true,
+	})
+	RegisterCommand(&Command{
+		Name:    "guest-file-close",
+		Handler: handleGuestFileClose,
+		Returns: protocol.EmptyResponse{},
+		Args:    fileHandleArgs,
+		Enabled: true,
+	})
+	RegisterCommand(&Command{
+		Name:    "guest-file-read",
+		Handler: handleGuestFileRead,
+		Returns: protocol.GuestFileRead{},
+		Args: []Arg{
+			{Name: "handle", Type: TypeInt},
+			{Name: "count", Type: TypeInt, Optional: true},
+		},
+		Enabled: true,
+	})
+	RegisterCommand(&Command{
+		Name:    "guest-file-write",
+		Handler: handleGuestFileWrite,
+		Returns: protocol.GuestFileWrite{},
+		Args: []Arg{
+			{Name: "handle", Type: TypeInt},
+			{Name: "buf-b64", Type: TypeString, Sensitive: true},
+			{Name: "count", Type: TypeInt, Optional: true},
+		},
+		Enabled: true,
+	})
+	RegisterCommand(&Command{
+		Name:    "guest-file-seek",
+		Handler: handleGuestFileSeek,
+		Returns: protocol.GuestFileSeek{},
+		Args: []Arg{
+			{Name: "handle", Type: TypeInt},
+			{Name: "offset", Type: TypeInt},
+			{Name: "whence", Type: TypeInt | TypeString, Enum: []string{"set", "cur", "end"}},
+		},
+		Enabled: true,
+	})
+	RegisterCommand(&Command{
+		Name:    "guest-file-flush",
+		Handler: handleGuestFileFlush,
+		Returns: protocol.EmptyResponse{},
+		Args:    fileHandleArgs,
+		Enabled: true,
+	})
+}
+
+// fileOpenFlags maps an fopen() style mode to open(2) flags.
+var fileOpenFlags = map[string]int{
+	"r":   os.O_RDONLY,
+	"rb":  os.O_RDONLY,
+	"w":   os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	"wb":  os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	"a":   os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	"ab":  os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	"r+":  os.O_RDWR,
+	"rb+": os.O_RDWR,
+	"r+b": os.O_RDWR,
+	"w+":  os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+	"wb+": os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+	"w+b": os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+	"a+":  os.O_RDWR | os.O_CREATE | os.O_APPEND,
+	"ab+": os.O_RDWR | os.O_CREATE | os.O_APPEND,
+	"a+b": os.O_RDWR | os.O_CREATE | os.O_APPEND,
+}
+
+// handleGuestFileOpen handles the guest-file-open command.
+func handleGuestFileOpen(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestFileOpenArgs
+	if err := json.Unmarshal(req, &args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments for guest-file-open: %v", err)
+	}
+	if args.Mode == "" {
+		args.Mode = "r"
+	}
+
+	flags, ok := fileOpenFlags[args.Mode]
+	if !ok {
+		return nil, fmt.Errorf("invalid file open mode '%s'", args.Mode)
+	}
+
+	closeIdleFileHandles()
+
+	fileHandlesMutex.Lock()
+	defer fileHandlesMutex.Unlock()
+
+	if len(fileHandles) >= maxFileHandles {
+		return nil, fmt.Errorf("too many open files, at most %d handles may be open", maxFileHandles)
+	}
+
+	// Never hand the host a controlling terminal.
+	file, err := os.OpenFile(args.Path, flags|syscall.O_NOCTTY, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file '%s' (mode: '%s'): %v", args.Path, args.Mode, err)
+	}
+
+	handle := nextFileHandle()
+	fileHandles[handle] = &guestFile{
+		file:     file,
+		path:     args.Path,
+		lastUsed: time.Now(),
+	}
+	scheduleFileReaper()
+
+	logrus.WithFields(logrus.Fields{
+		"path":   args.Path,
+		"mode":   args.Mode,
+		"handle": handle,
+	}).Info("Guest file opened")
+	return handle, nil
+}
+
+// handleGuestFileClose handles the guest-file-close command.
+func handleGuestFileClose(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestFileHandleArgs
+	if err := json.Unmarshal(req, &args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments for guest-file-close: %v", err)
+	}
+
+	fileHandlesMutex.Lock()
+	gf, err := lookupFileHandle(args.Handle)
+	if err == nil {
+		delete(fileHandles, args.Handle)
+	}
+	fileHandlesMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// A read blocked on a FIFO or terminal returns once the file is closed,
+	// so closing does not wait for I/O in progress.
+	if err := gf.file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close handle %d: %v", args.Handle, err)
+	}
+	logrus.WithField("handle", args.Handle).Info("Guest file closed")
+	return protocol.EmptyResponse{}, nil
+}
+
+// handleGuestFileRead handles the guest-file-read command.
+func handleGuestFileRead(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestFileReadArgs
+	if err := json.Unmarshal(req, &args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments for guest-file-read: %v", err)
+	}
+
+	count := int64(defaultFileReadCount)
+	if args.Count != nil {
+		count = *args.Count
+	}
+	if count < 0 || count > maxFileReadCount {
+		return nil, fmt.Errorf("value '%d' is invalid for argument count", count)
+	}
+
+	gf, err := useFileHandle(args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	defer gf.release()
+
+	buf := make([]byte, count)
+	n, err := io.ReadFull(gf.file, buf)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		gf.eof = true
+	case err != nil:
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	default:
+		gf.eof = false
+	}
+
+	return protocol.GuestFileRead{
+		Count:  n,
+		BufB64: base64.StdEncoding.EncodeToString(buf[:n]),
+		EOF:    gf.eof,
+	}, nil
+}
+
+// handleGuestFileWrite handles the guest-file-write command.
+func handleGuestFileWrite(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestFileWriteArgs
+	if err := json.Unmarshal(req, &args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments for guest-file-write: %v", err)
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(args.BufB64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 in buf-b64: %v", err)
+	}
+
+	count := int64(len(buf))
+	if args.Count != nil {
+		count = *args.Count
+	}
+	if count < 0 || count > int64(len(buf)) {
+		return nil, fmt.Errorf("value '%d' is invalid for argument count", count)
+	}
+
+	gf, err := useFileHandle(args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	defer gf.release()
+
+	n, err := gf.file.Write(buf[:count])
+	if err != nil {
+		return nil, fmt.Errorf("failed to write to file: %v", err)
+	}
+	gf.eof = false
+
+	return protocol.GuestFileWrite{Count: n, EOF: gf.eof}, nil
+}
+
+// handleGuestFileSeek handles the guest-file-seek command.
+func handleGuestFileSeek(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestFileSeekArgs
+	if err := json.Unmarshal(req, &args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments for guest-file-seek: %v", err)
+	}
+
+	var whence int
+	switch args.Whence {
+	case protocol.GuestFileWhenceSet:
+		whence = io.SeekStart
+	case protocol.GuestFileWhenceCur:
+		whence = io.SeekCurrent
+	case protocol.GuestFileWhenceEnd:
+		whence = io.SeekEnd
+	default:
+		return nil, fmt.Errorf("invalid whence code %d", args.Whence)
+	}
+
+	gf, err := useFileHandle(args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	defer gf.release()
+
+	position, err := gf.file.Seek(args.Offset, whence)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seek file: %v", err)
+	}
+	// As with fseek(), a successful seek clears the end-of-file indicator.
+	gf.eof = false
+
+	return protocol.GuestFileSeek{Position: position, EOF: gf.eof}, nil
+}
+
+// handleGuestFileFlush handles the guest-file-flush command.
+func handleGuestFileFlush(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestFileHandleArgs
+	if err := json.Unmarshal(req, &args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments for guest-file-flush: %v", err)
+	}
+
+	gf, err := useFileHandle(args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	defer gf.release()
+
+	// Writes are unbuffered, so flushing means getting them to disk.
+	if err := gf.file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to flush file: %v", err)
+	}
+	return protocol.EmptyResponse{}, nil
+}
+
+// lookupFileHandle returns the open file for handle and marks it as used.
+// Callers must hold fileHandlesMutex.
+func lookupFileHandle(handle int64) (*guestFile, error) {
+	gf, ok := fileHandles[handle]
+	if !ok {
+		return nil, fmt.Errorf("handle '%d' has not been found", handle)
+	}
+	gf.lastUsed = time.Now()
+	return gf, nil
+}
+
+// useFileHandle returns the open file for handle, locked for I/O. The
+// global lock is not held during I/O; callers must call release when done.
+func useFileHandle(handle int64) (*guestFile, error) {
+	fileHandlesMutex.Lock()
+	gf, err := lookupFileHandle(handle)
+	if err == nil {
+		gf.busy++
+	}
+	fileHandlesMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	gf.mutex.Lock()
+	return gf, nil
+}
+
+// release unlocks a handle returned by useFileHandle.
+func (gf *guestFile) release() {
+	gf.mutex.Unlock()
+
+	fileHandlesMutex.Lock()
+	defer fileHandlesMutex.Unlock()
+	gf.busy--
+	gf.lastUsed = time.Now()
+}
+
+// nextFileHandle returns a new handle, from the agent state when one is
+// registered. Callers must hold fileHandlesMutex.
+func nextFileHandle() int64 {
+	if s := currentState(); s != nil {
+		return s.GetFdHandle()
+	}
+	localFileHandle++
+	return localFileHandle
+}
+
+// scheduleFileReaper arms the timer that closes idle handles unless it is
+// already armed or no handle is open. Callers must hold fileHandlesMutex.
+func scheduleFileReaper() {
+	if fileReaper != nil || len(fileHandles) == 0 {
+		return
+	}
+	fileReaper = time.AfterFunc(fileReapInterval, func() {
+		fileHandlesMutex.Lock()
+		defer fileHandlesMutex.Unlock()
+		fileReaper = nil
+		closeIdleFileHandlesLocked()
+		scheduleFileReaper()
+	})
+}
+
+// closeIdleFileHandles closes handles the host has not used for
+// fileHandleIdleTimeout, on the assumption that it lost track of them.
+// Besides the periodic check, it runs before a file is opened so leaked
+// handles do not count against maxFileHandles.
+func closeIdleFileHandles() {
+	fileHandlesMutex.Lock()
+	defer fileHandlesMutex.Unlock()
+	closeIdleFileHandlesLocked()
+}
+
+// closeIdleFileHandlesLocked is closeIdleFileHandles for callers holding
+// fileHandlesMutex.
+func closeIdleFileHandlesLocked() {
+	now := time.Now()
+	for handle, gf := range fileHandles {
+		if gf.busy == 0 && now.Sub(gf.lastUsed) > fileHandleIdleTimeout {
+			gf.file.Close()
+			delete(fileHandles, handle)
+			logrus.WithFields(logrus.Fields{
+				"handle": handle,
+				"path":   gf.path,
+			}).Warn("Closed idle guest file handle")
+		}
+	}
+}
+
+// closeAllFileHandles closes every open handle. It runs when the agent stops.
+func closeAllFileHandles() {
+	fileHandlesMutex.Lock()
+	defer fileHandlesMutex.Unlock()
+
+	for handle, gf := range fileHandles {
+		gf.file.Close()
+		delete(fileHandles, handle)
+	}
+	if fileReaper != nil {
+		fileReaper.Stop()
+		fileReaper = nil
+	}
+}
@@ -0,0 +1,363 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"mac-guest-agent/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fileCommand runs a guest-file-* handler with args marshalled to JSON.
+func fileCommand(handler func(context.Context, json.RawMessage) (interface{}, error), args interface{}) (interface{}, error) {
+	req, _ := json.Marshal(args)
+	return handler(context.Background(), req)
+}
+
+// openFile runs guest-file-open and closes the handle when the test ends.
+func openFile(t *testing.T, path, mode string) int64 {
+	t.Helper()
+	result, err := fileCommand(handleGuestFileOpen, protocol.GuestFileOpenArgs{Path: path, Mode: mode})
+	if err != nil {
+		t.Fatalf("guest-file-open %s: %v", path, err)
+	}
+	handle := result.(int64)
+	t.Cleanup(func() { fileCommand(handleGuestFileClose, protocol.GuestFileHandleArgs{Handle: handle}) })
+	return handle
+}
+
+// readFile runs guest-file-read; a negative count leaves it out.
+func readFile(t *testing.T, handle int64, count int64) protocol.GuestFileRead {
+	t.Helper()
+	args := protocol.GuestFileReadArgs{Handle: handle}
+	if count >= 0 {
+		args.Count = &count
+	}
+	result, err := fileCommand(handleGuestFileRead, args)
+	if err != nil {
+		t.Fatalf("guest-file-read: %v", err)
+	}
+	return result.(protocol.GuestFileRead)
+}
+
+// seekFile runs guest-file-seek.
+func seekFile(t *testing.T, handle, offset int64, whence protocol.GuestFileWhence) protocol.GuestFileSeek {
+	t.Helper()
+	result, err := fileCommand(handleGuestFileSeek, protocol.GuestFileSeekArgs{Handle: handle, Offset: offset, Whence: whence})
+	if err != nil {
+		t.Fatalf("guest-file-seek: %v", err)
+	}
+	return result.(protocol.GuestFileSeek)
+}
+
+// decoded returns the data of a guest-file-read result.
+func decoded(t *testing.T, read protocol.GuestFileRead) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(read.BufB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// writeTempFile creates a file holding data in a temporary directory.
+func writeTempFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGuestFileReadCountAndEOF(t *testing.T) {
+	handle := openFile(t, writeTempFile(t, "hello world"), "r")
+
+	read := readFile(t, handle, 5)
+	if read.Count != 5 || decoded(t, read) != "hello" || read.EOF {
+		t.Fatalf("first read = %+v, want \"hello\" without eof", read)
+	}
+	// Reading exactly up to the end does not report end of file yet
+	read = readFile(t, handle, 6)
+	if read.Count != 6 || decoded(t, read) != " world" || read.EOF {
+		t.Fatalf("second read = %+v, want \" world\" without eof", read)
+	}
+	read = readFile(t, handle, 6)
+	if read.Count != 0 || !read.EOF {
+		t.Fatalf("read at end of file = %+v, want eof", read)
+	}
+
+	// A short read returns what there is and sets eof
+	seekFile(t, handle, 6, protocol.GuestFileWhenceSet)
+	read = readFile(t, handle, 100)
+	if read.Count != 5 || decoded(t, read) != "world" || !read.EOF {
+		t.Fatalf("short read = %+v, want \"world\" with eof", read)
+	}
+
+	seekFile(t, handle, 0, protocol.GuestFileWhenceSet)
+	if read = readFile(t, handle, 0); read.Count != 0 || read.EOF {
+		t.Fatalf("read of 0 bytes = %+v, want nothing without eof", read)
+	}
+}
+
+func TestGuestFileReadDefaultCount(t *testing.T) {
+	handle := openFile(t, writeTempFile(t, strings.Repeat("x", defaultFileReadCount+10)), "r")
+
+	if read := readFile(t, handle, -1); read.Count != defaultFileReadCount || read.EOF {
+		t.Fatalf("read without count = %d bytes, eof %v; want %d without eof", read.Count, read.EOF, defaultFileReadCount)
+	}
+	if read := readFile(t, handle, -1); read.Count != 10 || !read.EOF {
+		t.Fatalf("second read = %d bytes, eof %v; want 10 with eof", read.Count, read.EOF)
+	}
+}
+
+func TestGuestFileReadInvalidCount(t *testing.T) {
+	handle := openFile(t, writeTempFile(t, "data"), "r")
+
+	for _, count := range []int64{-1, maxFileReadCount + 1} {
+		count := count
+		_, err := fileCommand(handleGuestFileRead, protocol.GuestFileReadArgs{Handle: handle, Count: &count})
+		if err == nil || !strings.Contains(err.Error(), "invalid for argument count") {
+			t.Errorf("read of %d bytes: error = %v, want an invalid count", count, err)
+		}
+	}
+}
+
+func TestGuestFileWriteCount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	handle := openFile(t, path, "w")
+
+	count := int64(3)
+	result, err := fileCommand(handleGuestFileWrite, protocol.GuestFileWriteArgs{
+		Handle: handle,
+		BufB64: base64.StdEncoding.EncodeToString([]byte("hello")),
+		Count:  &count,
+	})
+	if err != nil {
+		t.Fatalf("guest-file-write: %v", err)
+	}
+	if write := result.(protocol.GuestFileWrite); write.Count != 3 {
+		t.Errorf("wrote %d bytes, want 3", write.Count)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "hel" {
+		t.Errorf("file holds %q, want \"hel\"", data)
+	}
+
+	count = 6
+	_, err = fileCommand(handleGuestFileWrite, protocol.GuestFileWriteArgs{
+		Handle: handle,
+		BufB64: base64.StdEncoding.EncodeToString([]byte("hello")),
+		Count:  &count,
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid for argument count") {
+		t.Errorf("write of more than the buffer: error = %v", err)
+	}
+}
+
+func TestGuestFileSeek(t *testing.T) {
+	handle := openFile(t, writeTempFile(t, "0123456789"), "r")
+
+	readFile(t, handle, 100)
+	// Each seek reports the new offset and clears eof
+	for _, test := range []struct {
+		offset   int64
+		whence   protocol.GuestFileWhence
+		position int64
+		next     string
+	}{
+		{3, protocol.GuestFileWhenceSet, 3, "3"},
+		{2, protocol.GuestFileWhenceCur, 6, "6"},
+		{-1, protocol.GuestFileWhenceCur, 6, "6"},
+		{-2, protocol.GuestFileWhenceEnd, 8, "8"},
+	} {
+		seek := seekFile(t, handle, test.offset, test.whence)
+		if seek.Position != test.position || seek.EOF {
+			t.Errorf("seek(%d, %d) = %+v, want position %d without eof", test.offset, test.whence, seek, test.position)
+		}
+		if read := readFile(t, handle, 1); decoded(t, read) != test.next {
+			t.Errorf("after seek(%d, %d) read %q, want %q", test.offset, test.whence, decoded(t, read), test.next)
+		}
+	}
+
+	if _, err := fileCommand(handleGuestFileSeek, protocol.GuestFileSeekArgs{Handle: handle, Offset: -1}); err == nil {
+		t.Error("seek before the start of the file succeeded")
+	}
+}
+
+func TestGuestFileUnknownHandle(t *testing.T) {
+	for name, handler := range map[string]func(context.Context, json.RawMessage) (interface{}, error){
+		"guest-file-close": handleGuestFileClose,
+		"guest-file-read":  handleGuestFileRead,
+		"guest-file-flush": handleGuestFileFlush,
+	} {
+		_, err := fileCommand(handler, protocol.GuestFileHandleArgs{Handle: 1})
+		if err == nil || !strings.Contains(err.Error(), "handle '1' has not been found") {
+			t.Errorf("%s: error = %v, want an unknown handle", name, err)
+		}
+	}
+}
+
+func TestGuestFileHandleLimit(t *testing.T) {
+	path := writeTempFile(t, "data")
+	handles := make([]int64, maxFileHandles)
+	for i := range handles {
+		handles[i] = openFile(t, path, "r")
+	}
+
+	_, err := fileCommand(handleGuestFileOpen, protocol.GuestFileOpenArgs{Path: path})
+	if err == nil || !strings.Contains(err.Error(), "too many open files") {
+		t.Fatalf("guest-file-open beyond the limit: error = %v", err)
+	}
+
+	if _, err := fileCommand(handleGuestFileClose, protocol.GuestFileHandleArgs{Handle: handles[0]}); err != nil {
+		t.Fatal(err)
+	}
+	openFile(t, path, "r")
+}
+
+// useFileReapInterval makes the idle handle check run every interval for
+// the duration of a test.
+func useFileReapInterval(t *testing.T, interval time.Duration) {
+	t.Helper()
+	// Rearm a pending check so it uses the new interval
+	setInterval := func(interval time.Duration) {
+		fileHandlesMutex.Lock()
+		defer fileHandlesMutex.Unlock()
+		fileReapInterval = interval
+		if fileReaper != nil {
+			fileReaper.Stop()
+			fileReaper = nil
+		}
+		scheduleFileReaper()
+	}
+	saved := fileReapInterval
+	setInterval(interval)
+	t.Cleanup(func() { setInterval(saved) })
+}
+
+// makeIdle marks handle as unused for longer than fileHandleIdleTimeout.
+func makeIdle(handle int64) {
+	fileHandlesMutex.Lock()
+	defer fileHandlesMutex.Unlock()
+	fileHandles[handle].lastUsed = time.Now().Add(-fileHandleIdleTimeout - time.Second)
+}
+
+// handleOpen reports whether handle is still open.
+func handleOpen(handle int64) bool {
+	fileHandlesMutex.Lock()
+	defer fileHandlesMutex.Unlock()
+	_, ok := fileHandles[handle]
+	return ok
+}
+
+func TestGuestFileIdleHandlesClosed(t *testing.T) {
+	useFileReapInterval(t, 10*time.Millisecond)
+	path := writeTempFile(t, "data")
+	idle := openFile(t, path, "r")
+	used := openFile(t, path, "r")
+
+	// Handles are closed without the host running any further command
+	makeIdle(idle)
+	deadline := time.Now().Add(5 * time.Second)
+	for handleOpen(idle) {
+		if time.Now().After(deadline) {
+			t.Fatal("idle handle was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !handleOpen(used) {
+		t.Error("a recently used handle was closed")
+	}
+	if _, err := fileCommand(handleGuestFileRead, protocol.GuestFileHandleArgs{Handle: idle}); err == nil {
+		t.Error("guest-file-read on an expired handle succeeded")
+	}
+}
+
+func TestGuestFileIdleHandlesClosedOnOpen(t *testing.T) {
+	path := writeTempFile(t, "data")
+	idle := openFile(t, path, "r")
+
+	makeIdle(idle)
+	openFile(t, path, "r")
+	if handleOpen(idle) {
+		t.Error("idle handle was not closed when a file was opened")
+	}
+}
+
+func TestGuestFileBusyHandleNotIdle(t *testing.T) {
+	handle := openFile(t, writeTempFile(t, "data"), "r")
+
+	gf, err := useFileHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeIdle(handle)
+	closeIdleFileHandles()
+	open := handleOpen(handle)
+	gf.release()
+	if !open {
+		t.Fatal("a handle in use was closed as idle")
+	}
+	// Finishing the command counts as using the handle
+	closeIdleFileHandles()
+	if !handleOpen(handle) {
+		t.Error("a handle was closed as idle right after it was used")
+	}
+}
+
+func TestGuestFileBlockedReadDoesNotStallOthers(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	// Opened for writing as well, so neither the open nor the read sees
+	// the other end missing
+	blocked := openFile(t, fifo, "r+")
+	other := openFile(t, writeTempFile(t, "data"), "r")
+
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := fileCommand(handleGuestFileRead, protocol.GuestFileHandleArgs{Handle: blocked})
+		readDone <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-readDone:
+		t.Fatalf("read of an empty FIFO returned: %v", err)
+	default:
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := fileCommand(handleGuestFileRead, protocol.GuestFileHandleArgs{Handle: other}); err != nil {
+			t.Errorf("read of another handle: %v", err)
+		}
+		if _, err := fileCommand(handleGuestFileFlush, protocol.GuestFileHandleArgs{Handle: other}); err != nil {
+			t.Errorf("flush of another handle: %v", err)
+		}
+		// Closing the blocked handle ends the read
+		if _, err := fileCommand(handleGuestFileClose, protocol.GuestFileHandleArgs{Handle: blocked}); err != nil {
+			t.Errorf("guest-file-close: %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("guest-file-* commands waited for a blocked read")
+	}
+
+	select {
+	case err := <-readDone:
+		if err == nil {
+			t.Error("read of a closed handle succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the handle did not end the blocked read")
+	}
+}
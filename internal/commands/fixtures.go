@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoFixture is returned by FixtureRunner for an invocation that was not
+// recorded.
+var ErrNoFixture = errors.New("no fixture recorded")
+
+// Fixture is the recorded outcome of one invocation, keyed by its argv.
+// Stdin, environment and working directory are not part of the key.
+type Fixture struct {
+	Argv     []string `json:"argv"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exit-code,omitempty"`
+	Signal   int      `json:"signal,omitempty"`
+	// Error is set when the program could not be run at all.
+	Error string `json:"error,omitempty"`
+}
+
+// fixtureKey returns the lookup key for an argument vector.
+func fixtureKey(argv []string) string {
+	return strings.Join(argv, "\x00")
+}
+
+// FixtureRunner replays recorded program output instead of running programs,
+// so that command handlers can be exercised off macOS. Replayed programs are
+// reported to Invocation.Started with made-up process IDs counting from 1.
+type FixtureRunner struct {
+	fixtures map[string]Fixture
+	lastPID  int64
+}
+
+// NewFixtureRunner creates a runner replaying the given fixtures. When
+// several fixtures share an argv the last one wins.
+func NewFixtureRunner(fixtures []Fixture) *FixtureRunner {
+	r := &FixtureRunner{fixtures: make(map[string]Fixture, len(fixtures))}
+	for _, f := range fixtures {
+		r.fixtures[fixtureKey(f.Argv)] = f
+	}
+	return r
+}
+
+// LoadFixtureRunner creates a FixtureRunner from a JSON file written by
+// RecordingRunner.Save.
+func LoadFixtureRunner(path string) (*FixtureRunner, error) {
+	fixtures, err := LoadFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewFixtureRunner(fixtures), nil
+}
+
+// Run implements Runner.
+func (r *FixtureRunner) Run(ctx context.Context, inv Invocation) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, ok := r.fixtures[fixtureKey(inv.Argv())]
+	if !ok {
+		return nil, fmt.Errorf("%w for %q", ErrNoFixture, strings.Join(inv.Argv(), " "))
+	}
+	if f.Error != "" {
+		return nil, errors.New(f.Error)
+	}
+	if inv.Started != nil {
+		inv.Started(int(atomic.AddInt64(&r.lastPID, 1)))
+	}
+	result := &Result{
+		Stdout:   []byte(f.Stdout),
+		Stderr:   []byte(f.Stderr),
+		ExitCode: f.ExitCode,
+		Signal:   f.Signal,
+	}
+	if inv.MergeOutput {
+		result.Stdout = append(result.Stdout, result.Stderr...)
+		result.Stderr = nil
+	}
+	result.Stdout, result.StdoutTruncated = truncateOutput(result.Stdout, inv.MaxOutput)
+	result.Stderr, result.StderrTruncated = truncateOutput(result.Stderr, inv.MaxOutput)
+	return result, nil
+}
+
+// truncateOutput applies Invocation.MaxOutput to replayed output.
+func truncateOutput(data []byte, limit int) ([]byte, bool) {
+	if limit > 0 && len(data) > limit {
+		return data[:limit], true
+	}
+	return data, false
+}
+
+// RecordingRunner runs programs with another runner and records every
+// outcome as a Fixture.
+type RecordingRunner struct {
+	next     Runner
+	mutex    sync.Mutex
+	fixtures []Fixture
+	seen     map[string]int
+}
+
+// NewRecordingRunner creates a runner recording the invocations made
+// through next. A nil next selects ExecRunner.
+func NewRecordingRunner(next Runner) *RecordingRunner {
+	if next == nil {
+		next = ExecRunner{}
+	}
+	return &RecordingRunner{next: next, seen: make(map[string]int)}
+}
+
+// Run implements Runner.
+func (r *RecordingRunner) Run(ctx context.Context, inv Invocation) (*Result, error) {
+	result, err := r.next.Run(ctx, inv)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// A timeout says nothing about the program's output.
+		return result, err
+	}
+
+	f := Fixture{Argv: inv.Argv()}
+	if err != nil {
+		f.Error = err.Error()
+	} else {
+		f.Stdout = string(result.Stdout)
+		f.Stderr = string(result.Stderr)
+		f.ExitCode = result.ExitCode
+		f.Signal = result.Signal
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// Keep only the latest outcome of a repeated invocation.
+	key := fixtureKey(f.Argv)
+	if i, ok := r.seen[key]; ok {
+		r.fixtures[i] = f
+	} else {
+		r.seen[key] = len(r.fixtures)
+		r.fixtures = append(r.fixtures, f)
+	}
+	return result, err
+}
+
+// Fixtures returns the outcomes recorded so far.
+func (r *RecordingRunner) Fixtures() []Fixture {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Fixture(nil), r.fixtures...)
+}
+
+// Save writes the recorded outcomes to path as JSON.
+func (r *RecordingRunner) Save(path string) error {
+	data, err := json.MarshalIndent(r.Fixtures(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// LoadFixtures reads fixtures from a JSON file.
+func LoadFixtures(path string) ([]Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures []Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("invalid fixture file %s: %v", path, err)
+	}
+	return fixtures, nil
+}
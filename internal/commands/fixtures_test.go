@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// useFixtures replays testdata/<name> instead of running programs for the
+// duration of a test.
+func useFixtures(t *testing.T, name string) {
+	t.Helper()
+	r, err := LoadFixtureRunner(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useRunner(t, r)
+}
+
+// useRunner replaces the runner for the duration of a test.
+func useRunner(t *testing.T, r Runner) {
+	t.Helper()
+	previous := CurrentRunner()
+	SetRunner(r)
+	t.Cleanup(func() { SetRunner(previous) })
+}
+
+func TestFixtureRunner(t *testing.T) {
+	r := NewFixtureRunner([]Fixture{
+		{Argv: []string{"echo", "hi"}, Stdout: "hi\n"},
+		{Argv: []string{"false"}, Stderr: "failed\n", ExitCode: 1},
+		{Argv: []string{"both"}, Stdout: "out\n", Stderr: "err\n"},
+		{Argv: []string{"missing"}, Error: "executable file not found"},
+		{Argv: []string{"echo", "hi"}, Stdout: "hello\n"},
+	})
+	useRunner(t, r)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		inv     Invocation
+		stdout  string
+		stderr  string
+		trunc   bool
+		wantErr func(error) bool
+	}{
+		{
+			name:   "last fixture for an argv wins",
+			inv:    Invocation{Name: "echo", Args: []string{"hi"}},
+			stdout: "hello\n",
+		},
+		{
+			name:    "non-zero exit",
+			inv:     Invocation{Name: "false"},
+			stderr:  "failed\n",
+			wantErr: func(err error) bool { var e *ExitError; return errors.As(err, &e) && e.ExitCode == 1 },
+		},
+		{
+			name:   "merged output",
+			inv:    Invocation{Name: "both", MergeOutput: true},
+			stdout: "out\nerr\n",
+		},
+		{
+			name:   "truncated output",
+			inv:    Invocation{Name: "both", MaxOutput: 2},
+			stdout: "ou",
+			stderr: "er",
+			trunc:  true,
+		},
+		{
+			name:    "program that could not run",
+			inv:     Invocation{Name: "missing"},
+			wantErr: func(err error) bool { return err != nil && err.Error() == "executable file not found" },
+		},
+		{
+			name:    "not recorded",
+			inv:     Invocation{Name: "echo", Args: []string{"bye"}},
+			wantErr: func(err error) bool { return errors.Is(err, ErrNoFixture) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := run(ctx, tt.inv)
+			if tt.wantErr != nil {
+				if !tt.wantErr(err) {
+					t.Fatalf("run() error = %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			if result == nil {
+				return
+			}
+			if string(result.Stdout) != tt.stdout || string(result.Stderr) != tt.stderr {
+				t.Errorf("output = %q, %q; want %q, %q", result.Stdout, result.Stderr, tt.stdout, tt.stderr)
+			}
+			if result.StdoutTruncated != tt.trunc || result.StderrTruncated != tt.trunc {
+				t.Errorf("truncated = %v, %v; want %v", result.StdoutTruncated, result.StderrTruncated, tt.trunc)
+			}
+		})
+	}
+}
+
+func TestFixtureRunnerHonoursContext(t *testing.T) {
+	useRunner(t, NewFixtureRunner([]Fixture{{Argv: []string{"true"}}}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := run(ctx, Invocation{Name: "true"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("run() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRecordingRunnerRoundTrip(t *testing.T) {
+	source := NewFixtureRunner([]Fixture{
+		{Argv: []string{"sysctl", "-n", "hw.memsize"}, Stdout: "8589934592\n"},
+		{Argv: []string{"false"}, Stderr: "failed\n", ExitCode: 1},
+		{Argv: []string{"missing"}, Error: "not found"},
+	})
+	recorder := NewRecordingRunner(source)
+	useRunner(t, recorder)
+	ctx := context.Background()
+
+	run(ctx, Invocation{Name: "sysctl", Args: []string{"-n", "hw.memsize"}})
+	run(ctx, Invocation{Name: "false"})
+	run(ctx, Invocation{Name: "missing"})
+	run(ctx, Invocation{Name: "false"})
+
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFixtures(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Fixture{
+		{Argv: []string{"sysctl", "-n", "hw.memsize"}, Stdout: "8589934592\n"},
+		{Argv: []string{"false"}, Stderr: "failed\n", ExitCode: 1},
+		{Argv: []string{"missing"}, Error: "not found"},
+	}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Errorf("recorded fixtures = %+v, want %+v", loaded, want)
+	}
+}
+
+func TestLoadFixturesRejectsInvalidFile(t *testing.T) {
+	if _, err := LoadFixtures(filepath.Join("testdata", "does-not-exist.json")); err == nil {
+		t.Error("LoadFixtures() succeeded for a missing file")
+	}
+}
@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mac-guest-agent/protocol"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// macOS has no equivalent of Linux's FIFREEZE, so a freeze quiesces the
+// guest instead: hook scripts let applications reach a consistent state,
+// every mounted filesystem is flushed down to the disk's own write cache,
+// and the agent refuses all but a few commands until the host thaws.
+// Processes in the guest can still write, so a snapshot is only as
+// consistent as the hooks make it; the freeze commands are therefore
+// registered as emulated.
+
+const (
+	// DefaultFreezeTimeout is how long the guest stays frozen if the host
+	// never sends guest-fsfreeze-thaw.
+	DefaultFreezeTimeout = 5 * time.Minute
+
+	// freezeCommandTimeout bounds freeze and thaw, including their hooks.
+	freezeCommandTimeout = 2 * time.Minute
+)
+
+var (
+	freezeMutex   sync.Mutex
+	frozen        bool
+	frozenMounts  = make(map[string]bool)
+	autoThawTimer *time.Timer
+
+	freezeTimeout      = DefaultFreezeTimeout
+	freezeTimeoutMutex sync.RWMutex
+)
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "guest-fsfreeze-status",
+		Handler: handleFSFreezeStatus,
+		Returns: protocol.FsfreezeStatusThawed,
+		Enabled: true,
+		Class:   ExecConcurrent,
+	})
+	RegisterCommand(&Command{
+		Name:    "guest-fsfreeze-freeze",
+		Handler: handleFSFreezeFreeze,
+		Returns: int64(0),
+		Support: SupportEmulated,
+		Enabled: true,
+		Timeout: freezeCommandTimeout,
+	})
+	RegisterCommand(&Command{
+		Name:    "guest-fsfreeze-freeze-list",
+		Handler: handleFSFreezeFreezeList,
+		Args:    []Arg{{Name: "mountpoints", Type: TypeArray, Elem: TypeString, Optional: true}},
+		Returns: int64(0),
+		Support: SupportEmulated,
+		Enabled: true,
+		Timeout: freezeCommandTimeout,
+	})
+	RegisterCommand(&Command{
+		Name:    "guest-fsfreeze-thaw",
+		Handler: handleFSFreezeThaw,
+		Returns: int64(0),
+		Enabled: true,
+		Timeout: freezeCommandTimeout,
+	})
+}
+
+// SetFreezeTimeout changes how long the guest stays frozen before it thaws
+// itself. A non-positive value restores DefaultFreezeTimeout.
+func SetFreezeTimeout(d time.Duration) {
+	freezeTimeoutMutex.Lock()
+	defer freezeTimeoutMutex.Unlock()
+	if d <= 0 {
+		d = DefaultFreezeTimeout
+	}
+	freezeTimeout = d
+}
+
+// autoThawTimeout returns how long a freeze may last.
+func autoThawTimeout() time.Duration {
+	freezeTimeoutMutex.RLock()
+	defer freezeTimeoutMutex.RUnlock()
+	return freezeTimeout
+}
+
+// handleFSFreezeStatus handles the guest-fsfreeze-status command.
+func handleFSFreezeStatus(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	freezeMutex.Lock()
+	defer freezeMutex.Unlock()
+	if frozen {
+		return protocol.FsfreezeStatusFrozen, nil
+	}
+	return protocol.FsfreezeStatusThawed, nil
+}
+
+// handleFSFreezeFreeze handles the guest-fsfreeze-freeze command. It returns
+// the number of filesystems that were quiesced.
+func handleFSFreezeFreeze(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	return freezeFilesystems(ctx, nil)
+}
+
+// handleFSFreezeFreezeList handles the guest-fsfreeze-freeze-list command,
+// which quiesces only the given mountpoints, or all of them if none are
+// given.
+func handleFSFreezeFreezeList(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.FreezeListArgs
+	if len(req) > 0 {
+		if err := json.Unmarshal(req, &args); err != nil {
+			return nil, fmt.Errorf("failed to parse arguments: %v", err)
+		}
+	}
+	return freezeFilesystems(ctx, args.Mountpoints)
+}
+
+// freezeFilesystems runs the freeze hooks and flushes the selected
+// filesystems, all mounted ones if mountpoints is empty. Unknown mountpoints
+// are rejected before anything is done.
+func freezeFilesystems(ctx context.Context, mountpoints []string) (int, error) {
+	freezeMutex.Lock()
+	defer freezeMutex.Unlock()
+
+	if frozen {
+		return 0, fmt.Errorf("filesystems are already frozen")
+	}
+
+	filesystems, err := localFilesystems()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list filesystems: %v", err)
+	}
+	mounts, err := selectMountpoints(filesystems, mountpoints)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := runFreezeHooks(ctx, "freeze"); err != nil {
+		// Let the applications that did quiesce resume.
+		abortFreeze()
+		return 0, err
+	}
+
+	unix.Sync()
+	for _, mount := range mounts {
+		if err := flushFilesystem(mount); err != nil {
+			abortFreeze()
+			return 0, fmt.Errorf("failed to flush %s: %v", mount, err)
+		}
+	}
+
+	setFrozen(mounts)
+	logrus.WithField("mountpoints", mounts).Info("Filesystems frozen")
+	return len(mounts), nil
+}
+
+// abortFreeze runs the thaw hooks after a failed freeze. The freeze's own
+// context may already have expired, which is often why it failed, so the
+// hooks get a deadline of their own.
+func abortFreeze() {
+	ctx, cancel := context.WithTimeout(context.Background(), freezeCommandTimeout)
+	defer cancel()
+	if err := runFreezeHooks(ctx, "thaw"); err != nil {
+		logrus.WithError(err).Error("Thaw hook failed")
+	}
+}
+
+// selectMountpoints returns the mountpoints to freeze: every mounted
+// filesystem if requested is empty, otherwise the requested ones without
+// duplicates.
+func selectMountpoints(filesystems []mountEntry, requested []string) ([]string, error) {
+	mounted := make(map[string]bool, len(filesystems))
+	var all []string
+	for _, fs := range filesystems {
+		if !mounted[fs.Mountpoint] {
+			mounted[fs.Mountpoint] = true
+			all = append(all, fs.Mountpoint)
+		}
+	}
+	if len(requested) == 0 {
+		return all, nil
+	}
+
+	seen := make(map[string]bool, len(requested))
+	var mounts []string
+	for _, mount := range requested {
+		mount = filepath.Clean(mount)
+		if !mounted[mount] {
+			return nil, NewError(protocol.ErrorClassInvalidParameterValue,
+				"Parameter 'mountpoints' does not accept value '%s', it is not a mounted filesystem", mount)
+		}
+		if !seen[mount] {
+			seen[mount] = true
+			mounts = append(mounts, mount)
+		}
+	}
+	return mounts, nil
+}
+
+// handleFSFreezeThaw handles the guest-fsfreeze-thaw command. It returns the
+// number of filesystems that were frozen, zero if none were.
+func handleFSFreezeThaw(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	freezeMutex.Lock()
+	defer freezeMutex.Unlock()
+	return thaw(ctx), nil
+}
+
+// setFrozen records a completed freeze, blocks other commands and arms the
+// auto-thaw timer. freezeMutex must be held.
+func setFrozen(mounts []string) {
+	frozen = true
+	for _, mount := range mounts {
+		frozenMounts[mount] = true
+	}
+	if s := currentState(); s != nil {
+		s.SetFrozen(true)
+	}
+
+	timeout := autoThawTimeout()
+	autoThawTimer = time.AfterFunc(timeout, func() {
+		freezeMutex.Lock()
+		defer freezeMutex.Unlock()
+		if !frozen {
+			return
+		}
+		logrus.WithField("timeout", timeout).Warn("Host did not thaw filesystems in time, thawing automatically")
+		ctx, cancel := context.WithTimeout(context.Background(), freezeCommandTimeout)
+		defer cancel()
+		thaw(ctx)
+	})
+}
+
+// thaw runs the thaw hooks and unblocks commands. freezeMutex must be held.
+func thaw(ctx context.Context) int {
+	if !frozen {
+		return 0
+	}
+	if autoThawTimer != nil {
+		autoThawTimer.Stop()
+		autoThawTimer = nil
+	}
+
+	// A failing thaw hook must not keep the agent frozen.
+	if err := runFreezeHooks(ctx, "thaw"); err != nil {
+		logrus.WithError(err).Error("Thaw hook failed")
+	}
+
+	count := len(frozenMounts)
+	frozen = false
+	frozenMounts = make(map[string]bool)
+	if s := currentState(); s != nil {
+		s.SetFrozen(false)
+	}
+	logrus.WithField("count", count).Info("Filesystems thawed")
+	return count
+}
+
+// thawOnExit thaws the guest when the agent stops, so applications are not
+// left quiesced.
+func thawOnExit() {
+	freezeMutex.Lock()
+	defer freezeMutex.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), freezeCommandTimeout)
+	defer cancel()
+	thaw(ctx)
+}
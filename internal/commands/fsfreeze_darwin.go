@@ -0,0 +1,28 @@
+package commands
+
+import "golang.org/x/sys/unix"
+
+// flushFilesystem writes all cached data of the filesystem mounted at path to
+// permanent storage. F_FULLFSYNC also flushes the disk's own write cache,
+// which fsync(2) does not on macOS. Read-only filesystems are skipped.
+func flushFilesystem(path string) error {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(fd, &st); err != nil {
+		return err
+	}
+	if st.Flags&unix.MNT_RDONLY != 0 {
+		return nil
+	}
+
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_FULLFSYNC, 0); err != nil {
+		// Some filesystems do not support F_FULLFSYNC.
+		return unix.Fsync(fd)
+	}
+	return nil
+}
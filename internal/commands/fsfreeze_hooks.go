@@ -0,0 +1,218 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultFreezeHookDir holds the scripts run on freeze and thaw.
+	DefaultFreezeHookDir = "/usr/local/etc/mac-guest-agent/fsfreeze-hook.d"
+
+	// DefaultFreezeHookTimeout is how long a single hook may run.
+	DefaultFreezeHookTimeout = 30 * time.Second
+
+	// freezeHookMaxOutput caps the output of a hook kept for the log.
+	freezeHookMaxOutput = 64 * 1024
+)
+
+// FreezeHookPolicy decides what a failing freeze hook does to the freeze.
+// Thaw hooks never stop a thaw.
+type FreezeHookPolicy int
+
+const (
+	// FreezeHookAbort aborts the freeze and runs the thaw hooks, so the
+	// host never takes a snapshot of an application that did not flush.
+	FreezeHookAbort FreezeHookPolicy = iota
+
+	// FreezeHookContinue logs the failure and runs the remaining hooks.
+	FreezeHookContinue
+)
+
+// freezeHookPolicyNames are the names accepted by ParseFreezeHookPolicy.
+var freezeHookPolicyNames = map[FreezeHookPolicy]string{
+	FreezeHookAbort:    "abort",
+	FreezeHookContinue: "continue",
+}
+
+// String returns the configuration name of the policy.
+func (p FreezeHookPolicy) String() string {
+	return freezeHookPolicyNames[p]
+}
+
+// ParseFreezeHookPolicy parses a policy name: abort or continue.
+func ParseFreezeHookPolicy(name string) (FreezeHookPolicy, error) {
+	for policy, n := range freezeHookPolicyNames {
+		if n == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fsfreeze hook failure policy %q, expected abort or continue", name)
+}
+
+// FreezeHookConfig describes the fsfreeze hooks.
+type FreezeHookConfig struct {
+	// Dir holds the hooks. An empty path disables hooks.
+	Dir string
+
+	// Timeout is how long a single hook may run. Zero selects
+	// DefaultFreezeHookTimeout.
+	Timeout time.Duration
+
+	// OnFailure decides what a failing freeze hook does.
+	OnFailure FreezeHookPolicy
+}
+
+var (
+	freezeHooks = FreezeHookConfig{
+		Dir:     DefaultFreezeHookDir,
+		Timeout: DefaultFreezeHookTimeout,
+	}
+	freezeHooksMutex sync.RWMutex
+)
+
+// SetFreezeHooks changes how fsfreeze hooks are run.
+func SetFreezeHooks(config FreezeHookConfig) {
+	freezeHooksMutex.Lock()
+	defer freezeHooksMutex.Unlock()
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultFreezeHookTimeout
+	}
+	freezeHooks = config
+}
+
+// currentFreezeHooks returns the hook configuration.
+func currentFreezeHooks() FreezeHookConfig {
+	freezeHooksMutex.RLock()
+	defer freezeHooksMutex.RUnlock()
+	return freezeHooks
+}
+
+// freezeHookResult is the outcome of running one hook.
+type freezeHookResult struct {
+	Hook     string
+	Action   string
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+// runFreezeHooks runs the executables in the hook directory in lexical order
+// with action ("freeze" or "thaw") as their only argument, each under its own
+// timeout. A failing freeze hook stops the remaining hooks and is returned
+// unless the policy is FreezeHookContinue; failing thaw hooks are logged and
+// the remaining hooks still run. A missing directory means there are no hooks.
+func runFreezeHooks(ctx context.Context, action string) error {
+	config := currentFreezeHooks()
+	hooks, err := listFreezeHooks(config.Dir)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, hook := range hooks {
+		result := runFreezeHook(ctx, hook, action, config.Timeout)
+		if result.Err == nil {
+			continue
+		}
+		if action == "freeze" && config.OnFailure == FreezeHookAbort {
+			return fmt.Errorf("fsfreeze hook %s %s failed: %v", hook, action, result.Err)
+		}
+		failed = append(failed, filepath.Base(hook))
+	}
+	if len(failed) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"action": action,
+			"failed": failed,
+		}).Warn("Some fsfreeze hooks failed, continuing")
+	}
+	return nil
+}
+
+// listFreezeHooks returns the executable regular files in dir, sorted by
+// name. Hidden files and editor backups are skipped.
+func listFreezeHooks(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read hook directory %s: %v", dir, err)
+	}
+
+	var hooks []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode()&0111 == 0 {
+			continue
+		}
+		hooks = append(hooks, filepath.Join(dir, name))
+	}
+	return hooks, nil
+}
+
+// runFreezeHook runs a single hook and logs its result and output.
+func runFreezeHook(ctx context.Context, hook, action string, timeout time.Duration) freezeHookResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	out, err := run(ctx, Invocation{
+		Name:      hook,
+		Args:      []string{action},
+		MaxOutput: freezeHookMaxOutput,
+	})
+	result := freezeHookResult{
+		Hook:     hook,
+		Action:   action,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if out != nil {
+		result.ExitCode = out.ExitCode
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		result.Err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"hook":      hook,
+		"action":    action,
+		"exit_code": result.ExitCode,
+		"duration":  result.Duration,
+	})
+	if out != nil {
+		logHookOutput(log, "stdout", out.Stdout)
+		logHookOutput(log, "stderr", out.Stderr)
+	}
+	if result.Err != nil {
+		log.WithError(result.Err).Error("fsfreeze hook failed")
+	} else {
+		log.Info("fsfreeze hook finished")
+	}
+	return result
+}
+
+// logHookOutput logs each line a hook wrote to one of its output streams.
+func logHookOutput(log *logrus.Entry, stream string, output []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		log.WithField("stream", stream).Info(scanner.Text())
+	}
+}
@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loggingHook is a hook script that appends its name and action to .log in
+// its directory and exits with the status given for the action, 0 if none.
+func loggingHook(freezeStatus, thawStatus int) string {
+	return `#!/bin/sh
+echo "$(basename "$0") $1" >> "$(dirname "$0")/.log"
+case "$1" in
+freeze) exit ` + strconv.Itoa(freezeStatus) + ` ;;
+thaw) exit ` + strconv.Itoa(thawStatus) + ` ;;
+esac
+`
+}
+
+// useHookScripts installs hook scripts, keyed by file name, with the given
+// failure policy for the duration of a test and returns their directory.
+func useHookScripts(t *testing.T, policy FreezeHookPolicy, scripts map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := currentFreezeHooks()
+	SetFreezeHooks(FreezeHookConfig{Dir: dir, Timeout: 10 * time.Second, OnFailure: policy})
+	t.Cleanup(func() { SetFreezeHooks(previous) })
+	return dir
+}
+
+// hookLog returns the hooks that ran, in order, as "name action" lines.
+func hookLog(t *testing.T, dir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, ".log"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestFreezeHooksRunInOrder(t *testing.T) {
+	dir := useHookScripts(t, FreezeHookAbort, map[string]string{
+		"20-database": loggingHook(0, 0),
+		"10-cache":    loggingHook(0, 0),
+		"30-web":      loggingHook(0, 0),
+		// Skipped: hidden, editor backup
+		".40-hidden": loggingHook(0, 0),
+		"50-backup~": loggingHook(0, 0),
+	})
+	// Skipped: not executable
+	if err := os.WriteFile(filepath.Join(dir, "60-notes"), []byte(loggingHook(0, 0)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, action := range []string{"freeze", "thaw"} {
+		if err := runFreezeHooks(context.Background(), action); err != nil {
+			t.Fatalf("%s hooks: %v", action, err)
+		}
+	}
+	want := "10-cache freeze,20-database freeze,30-web freeze,10-cache thaw,20-database thaw,30-web thaw"
+	if got := strings.Join(hookLog(t, dir), ","); got != want {
+		t.Errorf("hooks ran as %s, want %s", got, want)
+	}
+}
+
+func TestFreezeHookFailureAborts(t *testing.T) {
+	dir := useHookScripts(t, FreezeHookAbort, map[string]string{
+		"10-cache":    loggingHook(0, 0),
+		"20-database": loggingHook(3, 0),
+		"30-web":      loggingHook(0, 0),
+	})
+
+	err := runFreezeHooks(context.Background(), "freeze")
+	if err == nil || !strings.Contains(err.Error(), "20-database") {
+		t.Fatalf("freeze hooks error = %v, want the failing hook", err)
+	}
+	// The hooks after the failing one do not run
+	if got, want := strings.Join(hookLog(t, dir), ","), "10-cache freeze,20-database freeze"; got != want {
+		t.Errorf("hooks ran as %s, want %s", got, want)
+	}
+}
+
+func TestFreezeHookFailureContinues(t *testing.T) {
+	dir := useHookScripts(t, FreezeHookContinue, map[string]string{
+		"10-cache":    loggingHook(0, 0),
+		"20-database": loggingHook(3, 0),
+		"30-web":      loggingHook(0, 0),
+	})
+
+	if err := runFreezeHooks(context.Background(), "freeze"); err != nil {
+		t.Fatalf("freeze hooks error = %v with the continue policy", err)
+	}
+	if got := hookLog(t, dir); len(got) != 3 {
+		t.Errorf("hooks ran as %v, want all three", got)
+	}
+}
+
+func TestThawHookFailureDoesNotStopThaw(t *testing.T) {
+	dir := useHookScripts(t, FreezeHookAbort, map[string]string{
+		"10-cache":    loggingHook(0, 1),
+		"20-database": loggingHook(0, 0),
+	})
+
+	if err := runFreezeHooks(context.Background(), "thaw"); err != nil {
+		t.Fatalf("thaw hooks error = %v, want failures only logged", err)
+	}
+	if got, want := strings.Join(hookLog(t, dir), ","), "10-cache thaw,20-database thaw"; got != want {
+		t.Errorf("hooks ran as %s, want %s", got, want)
+	}
+}
+
+func TestFreezeHookTimeout(t *testing.T) {
+	dir := useHookScripts(t, FreezeHookAbort, map[string]string{
+		"10-slow": "#!/bin/sh\nexec sleep 5\n",
+	})
+	SetFreezeHooks(FreezeHookConfig{Dir: dir, Timeout: 100 * time.Millisecond})
+
+	start := time.Now()
+	err := runFreezeHooks(context.Background(), "freeze")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("freeze hooks error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("the hook ran for %s despite its timeout", elapsed)
+	}
+}
+
+func TestFreezeHooksMissingDir(t *testing.T) {
+	previous := currentFreezeHooks()
+	t.Cleanup(func() { SetFreezeHooks(previous) })
+
+	for _, dir := range []string{"", filepath.Join(t.TempDir(), "missing")} {
+		SetFreezeHooks(FreezeHookConfig{Dir: dir})
+		if err := runFreezeHooks(context.Background(), "freeze"); err != nil {
+			t.Errorf("hooks in %q: %v", dir, err)
+		}
+	}
+}
+
+func TestFreezeHookFailureAbortsFreeze(t *testing.T) {
+	dir := useHookScripts(t, FreezeHookAbort, map[string]string{
+		"10-cache":    loggingHook(0, 0),
+		"20-database": loggingHook(1, 0),
+	})
+
+	if _, err := freezeFilesystems(context.Background(), nil); err == nil {
+		t.Fatal("freeze succeeded although a hook failed")
+	}
+	if frozen {
+		t.Error("guest is frozen after a failed freeze hook")
+	}
+	// The applications that did quiesce are thawed again
+	want := "10-cache freeze,20-database freeze,10-cache thaw,20-database thaw"
+	if got := strings.Join(hookLog(t, dir), ","); got != want {
+		t.Errorf("hooks ran as %s, want %s", got, want)
+	}
+}
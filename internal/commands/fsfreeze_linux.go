@@ -0,0 +1,22 @@
+package commands
+
+import "golang.org/x/sys/unix"
+
+// flushFilesystem writes all cached data of the filesystem mounted at path to
+// permanent storage. Read-only filesystems are skipped.
+func flushFilesystem(path string) error {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(fd, &st); err != nil {
+		return err
+	}
+	if st.Flags&unix.ST_RDONLY != 0 {
+		return nil
+	}
+	return unix.Syncfs(fd)
+}
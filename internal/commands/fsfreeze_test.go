@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useFreezeHooks installs a hook script for the duration of a test.
+func useFreezeHooks(t *testing.T, script string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "10-test"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := currentFreezeHooks()
+	SetFreezeHooks(FreezeHookConfig{Dir: dir, Timeout: 10 * time.Second})
+	t.Cleanup(func() { SetFreezeHooks(previous) })
+	return dir
+}
+
+func TestAbortedFreezeThawsAfterDeadline(t *testing.T) {
+	dir := useFreezeHooks(t, `#!/bin/sh
+case "$1" in
+freeze) exec sleep 5 ;;
+thaw) touch "$(dirname "$0")/thawed" ;;
+esac
+`)
+
+	// The freeze hook outlives the command's deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := freezeFilesystems(ctx, nil); err == nil {
+		t.Fatal("freeze succeeded although its hook timed out")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "thawed")); err != nil {
+		t.Errorf("thaw hooks did not run after the aborted freeze: %v", err)
+	}
+	if frozen {
+		t.Error("guest is frozen after an aborted freeze")
+	}
+}
@@ -0,0 +1,242 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/internal/plist"
+	"mac-guest-agent/protocol"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mountEntry is one entry of the mount table with the filesystem's usage in
+// statfs(2) terms.
+type mountEntry struct {
+	Device     string
+	Mountpoint string
+	Type       string
+	ReadOnly   bool
+
+	// NoBrowse is set for the APFS system volumes (VM, Preboot, Update,
+	// ...) macOS hides from the user.
+	NoBrowse bool
+
+	BlockSize uint64
+	Blocks    uint64
+	Free      uint64
+	Avail     uint64
+}
+
+// dataVolumeMountpoint is where the writable half of the APFS system
+// volume group is mounted. It is hidden like the other system volumes but
+// holds all user data, so it is reported.
+const dataVolumeMountpoint = "/System/Volumes/Data"
+
+// snapshotDevice matches the device of a mounted APFS snapshot, such as the
+// sealed system snapshot disk3s1s1 of volume disk3s1.
+var snapshotDevice = regexp.MustCompile(`^(/dev/disk\d+s\d+)s\d+$`)
+
+// mountTable reads the mount table. Tests replace it with a recorded one.
+var mountTable = readMountTable
+
+// localFilesystems returns the mounted filesystems backed by a local disk,
+// the ones reported by guest-get-fsinfo and frozen by guest-fsfreeze-freeze.
+func localFilesystems() ([]mountEntry, error) {
+	entries, err := mountTable()
+	if err != nil {
+		return nil, err
+	}
+
+	var filesystems []mountEntry
+	for _, entry := range entries {
+		// Older releases mount the system snapshot as "name@/dev/diskXsY".
+		if _, device, ok := strings.Cut(entry.Device, "@"); ok {
+			entry.Device = device
+		}
+		if m := snapshotDevice.FindStringSubmatch(entry.Device); m != nil {
+			entry.Device = m[1]
+		}
+		if !strings.HasPrefix(entry.Device, "/dev/") {
+			continue // Virtual and network filesystems
+		}
+		if entry.NoBrowse && entry.Mountpoint != dataVolumeMountpoint {
+			continue
+		}
+		filesystems = append(filesystems, entry)
+	}
+	return filesystems, nil
+}
+
+// getFilesystemInfo retrieves information about mounted filesystems.
+func getFilesystemInfo(ctx context.Context) ([]protocol.GuestFilesystemInfo, error) {
+	entries, err := localFilesystems()
+	if err != nil {
+		return nil, err
+	}
+
+	disks := newDiskResolver(ctx)
+	filesystems := make([]protocol.GuestFilesystemInfo, 0, len(entries))
+	for _, entry := range entries {
+		fs := protocol.GuestFilesystemInfo{
+			Name:       strings.TrimPrefix(entry.Device, "/dev/"),
+			Mountpoint: entry.Mountpoint,
+			Type:       entry.Type,
+			Disk:       disks.addresses(entry.Device),
+		}
+		// Same accounting as upstream: total-bytes is what unprivileged
+		// users can fill, total-bytes-privileged includes the reserve.
+		used := (entry.Blocks - entry.Free) * entry.BlockSize
+		fs.UsedBytes = int64(used)
+		fs.TotalBytes = int64(used + entry.Avail*entry.BlockSize)
+		fs.TotalBytesPrivileged = int64(entry.Blocks * entry.BlockSize)
+		filesystems = append(filesystems, fs)
+	}
+	return filesystems, nil
+}
+
+// diskResolver maps volumes to the physical disks backing them using
+// `diskutil info -plist`. Each device is queried at most once.
+type diskResolver struct {
+	ctx  context.Context
+	info map[string]plist.Dict
+
+	// hardware is read from the IORegistry on first use.
+	hardware map[string]diskHardware
+}
+
+// newDiskResolver returns a resolver running diskutil under ctx.
+func newDiskResolver(ctx context.Context) *diskResolver {
+	return &diskResolver{ctx: ctx, info: make(map[string]plist.Dict)}
+}
+
+// lookup returns the `diskutil info` properties of a device, or nil.
+func (r *diskResolver) lookup(device string) plist.Dict {
+	device = strings.TrimPrefix(device, "/dev/")
+	if info, ok := r.info[device]; ok {
+		return info
+	}
+	var info plist.Dict
+	output, err := runOutput(r.ctx, "diskutil", "info", "-plist", device)
+	if err == nil {
+		info, err = plist.UnmarshalDict(output)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("device", device).Debug("Failed to query diskutil")
+	}
+	r.info[device] = info
+	return info
+}
+
+// physicalDisks returns the whole disks a volume lives on. APFS volumes
+// are followed through their container to its physical stores.
+func (r *diskResolver) physicalDisks(device string) []string {
+	info := r.lookup(device)
+	if info == nil {
+		return nil
+	}
+
+	var stores []string
+	for _, store := range info.Dicts("APFSPhysicalStores") {
+		if id := store.String("APFSPhysicalStore"); id != "" {
+			stores = append(stores, id)
+		}
+	}
+	if len(stores) == 0 {
+		if whole := info.String("ParentWholeDisk"); whole != "" {
+			return []string{whole}
+		}
+		return nil
+	}
+
+	var disks []string
+	seen := make(map[string]bool)
+	for _, store := range stores {
+		whole := store
+		if storeInfo := r.lookup(store); storeInfo.String("ParentWholeDisk") != "" {
+			whole = storeInfo.String("ParentWholeDisk")
+		}
+		if !seen[whole] {
+			seen[whole] = true
+			disks = append(disks, whole)
+		}
+	}
+	return disks
+}
+
+// addresses returns the addresses of the physical disks behind a volume.
+func (r *diskResolver) addresses(device string) []protocol.GuestDiskAddress {
+	addresses := []protocol.GuestDiskAddress{}
+	for _, disk := range r.physicalDisks(device) {
+		addresses = append(addresses, r.address(disk))
+	}
+	return addresses
+}
+
+// address returns the address of a whole disk. The bus type, controller
+// and serial number come from the IORegistry; diskutil's BusProtocol is
+// the fallback for the bus type.
+func (r *diskResolver) address(disk string) protocol.GuestDiskAddress {
+	disk = strings.TrimPrefix(disk, "/dev/")
+	if r.hardware == nil {
+		hardware, err := readDiskHardware(r.ctx)
+		if err != nil {
+			logrus.WithError(err).Debug("Failed to read the IORegistry")
+			hardware = make(map[string]diskHardware)
+		}
+		r.hardware = hardware
+	}
+
+	hw, ok := r.hardware[disk]
+	bus := hw.BusType
+	if !ok || bus == protocol.DiskBusUnknown {
+		bus = diskBusType(r.lookup(disk).String("BusProtocol"))
+	}
+	address := newDiskAddress("/dev/"+disk, bus)
+	if ok {
+		address.PCIController = hw.PCI
+		address.Serial = hw.Serial
+	}
+	return address
+}
+
+// newDiskAddress returns the address of a disk whose controller location is
+// not known.
+func newDiskAddress(dev string, bus protocol.GuestDiskBusType) protocol.GuestDiskAddress {
+	return protocol.GuestDiskAddress{
+		BusType: bus,
+		Bus:     -1,
+		Target:  -1,
+		Unit:    -1,
+		PCIController: protocol.GuestPCIAddress{
+			Domain:   -1,
+			Bus:      -1,
+			Slot:     -1,
+			Function: -1,
+		},
+		Dev: dev,
+	}
+}
+
+// diskBusType maps the BusProtocol reported by diskutil to a bus type.
+func diskBusType(protocolName string) protocol.GuestDiskBusType {
+	p := strings.ToLower(protocolName)
+	switch {
+	case strings.Contains(p, "virtio"):
+		return protocol.DiskBusVirtio
+	case strings.Contains(p, "sata"):
+		return protocol.DiskBusSATA
+	case strings.Contains(p, "usb"):
+		return protocol.DiskBusUSB
+	case strings.Contains(p, "nvme"), strings.Contains(p, "pci-express"):
+		return protocol.DiskBusNVMe
+	case strings.Contains(p, "scsi"), strings.Contains(p, "sas"), strings.Contains(p, "fibre channel"):
+		return protocol.DiskBusSCSI
+	case strings.Contains(p, "secure digital"):
+		return protocol.DiskBusSD
+	case strings.Contains(p, "ata"):
+		return protocol.DiskBusIDE
+	default:
+		return protocol.DiskBusUnknown
+	}
+}
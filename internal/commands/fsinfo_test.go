@@ -0,0 +1,203 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"mac-guest-agent/protocol"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// mountLine matches a line of mount(8) output:
+// "/dev/disk1s5 on /System/Volumes/Data (apfs, local, journaled, nobrowse)".
+var mountLine = regexp.MustCompile(`^(.+) on (.+) \((.+)\)$`)
+
+// loadMountTable builds a mount table from the recorded output of `mount`
+// and `df -k` in testdata/mount_<name>.txt and testdata/df_<name>.txt.
+func loadMountTable(t *testing.T, name string) []mountEntry {
+	t.Helper()
+	var entries []mountEntry
+	readLines(t, "mount_"+name+".txt", func(line string) {
+		m := mountLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("invalid mount line %q", line)
+		}
+		entry := mountEntry{Device: m[1], Mountpoint: m[2], BlockSize: 1024}
+		for i, option := range strings.Split(m[3], ", ") {
+			switch {
+			case i == 0:
+				entry.Type = option
+			case option == "read-only":
+				entry.ReadOnly = true
+			case option == "nobrowse":
+				entry.NoBrowse = true
+			}
+		}
+		entries = append(entries, entry)
+	})
+
+	header := true
+	readLines(t, "df_"+name+".txt", func(line string) {
+		if header {
+			header = false
+			return
+		}
+		// Device and mountpoint may contain spaces; the mountpoint is
+		// found by its suffix and the seven columns before it are numbers.
+		best := -1
+		for i, entry := range entries {
+			if strings.HasSuffix(line, " "+entry.Mountpoint) &&
+				(best < 0 || len(entry.Mountpoint) > len(entries[best].Mountpoint)) {
+				best = i
+			}
+		}
+		if best < 0 {
+			t.Fatalf("df line %q matches no mount", line)
+		}
+		entry := &entries[best]
+		fields := strings.Fields(strings.TrimSuffix(line, entry.Mountpoint))
+		columns := fields[len(fields)-7:]
+		blocks, _ := strconv.ParseUint(columns[0], 10, 64)
+		used, _ := strconv.ParseUint(columns[1], 10, 64)
+		avail, _ := strconv.ParseUint(columns[2], 10, 64)
+		entry.Blocks = blocks
+		entry.Free = blocks - used
+		entry.Avail = avail
+	})
+	return entries
+}
+
+// readLines calls fn for each non-empty line of testdata/<name>.
+func readLines(t *testing.T, name string, fn func(string)) {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			fn(line)
+		}
+	}
+}
+
+// useMountTable replaces the mount table with a recorded one for the
+// duration of a test.
+func useMountTable(t *testing.T, name string) {
+	t.Helper()
+	entries := loadMountTable(t, name)
+	previous := mountTable
+	mountTable = func() ([]mountEntry, error) { return entries, nil }
+	t.Cleanup(func() { mountTable = previous })
+}
+
+// describeAddress summarises a disk address as "bus domain:bus:slot.function
+// serial dev".
+func describeAddress(a protocol.GuestDiskAddress) string {
+	pci := a.PCIController
+	return fmt.Sprintf("%s %d:%d:%d.%d %q %s", a.BusType, pci.Domain, pci.Bus, pci.Slot, pci.Function, a.Serial, a.Dev)
+}
+
+func TestGetFilesystemInfo(t *testing.T) {
+	useMountTable(t, "sonoma")
+	useFixtures(t, "disks_sonoma.json")
+
+	const KiB = 1024
+	systemDisk := `virtio 0:0:4.0 "VDISK-SYS01" /dev/disk0`
+	want := []struct {
+		name, mountpoint, fstype string
+		used, total, privileged  int64
+		disk                     string
+	}{
+		// The sealed system snapshot is reported as its volume
+		{"disk1s1", "/", "apfs", 10485760 * KiB, (10485760 + 38203196) * KiB, 66903048 * KiB, systemDisk},
+		// Hidden, but holds the user data
+		{"disk1s5", "/System/Volumes/Data", "apfs", 18874368 * KiB, (18874368 + 38203196) * KiB, 66903048 * KiB, systemDisk},
+		// A mounted disk image has no hardware behind it
+		{"disk2s1", "/Volumes/Installer", "hfs", 1562012 * KiB, (1562012 + 10812) * KiB, 1572824 * KiB, `unknown -1:-1:-1.-1 "" /dev/disk2`},
+		// virtio disk without a serial number whose PCI address is only
+		// in the reg property
+		{"disk4s1", "/Volumes/Data Disk", "apfs", 4194304 * KiB, (4194304 + 16532120) * KiB, 20766680 * KiB, `virtio 0:0:5.0 "" /dev/disk3`},
+	}
+
+	got, err := getFilesystemInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		var names []string
+		for _, fs := range got {
+			names = append(names, fs.Mountpoint)
+		}
+		t.Fatalf("guest-get-fsinfo returned %q, want %d filesystems", names, len(want))
+	}
+	for i, w := range want {
+		fs := got[i]
+		if fs.Name != w.name || fs.Mountpoint != w.mountpoint || fs.Type != w.fstype {
+			t.Errorf("filesystem %d = %s on %s (%s), want %s on %s (%s)",
+				i, fs.Name, fs.Mountpoint, fs.Type, w.name, w.mountpoint, w.fstype)
+		}
+		if fs.UsedBytes != w.used || fs.TotalBytes != w.total || fs.TotalBytesPrivileged != w.privileged {
+			t.Errorf("%s: used %d, total %d, privileged %d; want %d, %d, %d",
+				w.mountpoint, fs.UsedBytes, fs.TotalBytes, fs.TotalBytesPrivileged, w.used, w.total, w.privileged)
+		}
+		if len(fs.Disk) != 1 || describeAddress(fs.Disk[0]) != w.disk {
+			var disks []string
+			for _, d := range fs.Disk {
+				disks = append(disks, describeAddress(d))
+			}
+			t.Errorf("%s: disks %q, want %q", w.mountpoint, disks, w.disk)
+		}
+	}
+}
+
+func TestGetFilesystemInfoWithoutDiskutil(t *testing.T) {
+	useMountTable(t, "sonoma")
+	useRunner(t, NewFixtureRunner(nil))
+
+	got, err := getFilesystemInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fs := range got {
+		if fs.Disk == nil || len(fs.Disk) != 0 {
+			t.Errorf("%s: disks %+v, want an empty list", fs.Mountpoint, fs.Disk)
+		}
+	}
+}
+
+func TestLocalFilesystems(t *testing.T) {
+	previous := mountTable
+	defer func() { mountTable = previous }()
+	mountTable = func() ([]mountEntry, error) {
+		return []mountEntry{
+			// Catalina and Big Sur name the system snapshot with an @
+			{Device: "com.apple.os.update-3B1BA6@/dev/disk1s5", Mountpoint: "/"},
+			{Device: "/dev/disk1s1", Mountpoint: "/System/Volumes/Data", NoBrowse: true},
+			{Device: "/dev/disk1s4", Mountpoint: "/private/var/vm", NoBrowse: true},
+			{Device: "//guest@fileserver/share", Mountpoint: "/Volumes/share"},
+			{Device: "/dev/disk1s1s1", Mountpoint: "/Volumes/Snapshot"},
+		}, nil
+	}
+
+	got, err := localFilesystems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var devices []string
+	for _, fs := range got {
+		devices = append(devices, fs.Device+" "+fs.Mountpoint)
+	}
+	want := []string{"/dev/disk1s5 /", "/dev/disk1s1 /System/Volumes/Data", "/dev/disk1s1 /Volumes/Snapshot"}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("localFilesystems() = %q, want %q", devices, want)
+	}
+}
@@ -1,11 +1,14 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
-	"os/exec"
+	"fmt"
+	"mac-guest-agent/internal/plist"
+	"mac-guest-agent/protocol"
+	"regexp"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -14,13 +17,21 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-get-disks",
 		Handler: handleGetDisks,
+		Returns: []protocol.GuestDiskInfo{},
 		Enabled: true,
+		Class:   ExecConcurrent,
+		// diskutil is queried once per disk and can be slow to answer.
+		Timeout: 60 * time.Second,
 	})
 }
 
+// partitionNumber matches the slice number at the end of a partition's
+// device identifier, such as the 2 of disk0s2.
+var partitionNumber = regexp.MustCompile(`s(\d+)$`)
+
 // handleGetDisks handles the guest-get-disks command.
-func handleGetDisks(req json.RawMessage) (interface{}, error) {
-	disks, err := getDisks()
+func handleGetDisks(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	disks, err := getDisks(ctx)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get disk information")
 		return nil, err
@@ -29,131 +40,78 @@ func handleGetDisks(req json.RawMessage) (interface{}, error) {
 	return disks, nil
 }
 
-// getDisks retrieves information about disks.
-func getDisks() ([]protocol.GuestDiskInfo, error) {
-	// On macOS, use diskutil list to get disk information.
-	cmd := exec.Command("diskutil", "list", "-plist")
-	output, err := cmd.Output()
+// getDisks retrieves information about disks from `diskutil list -plist`.
+// Whole disks are listed with their partitions following them. An APFS
+// container is listed as a disk depending on its physical stores, and its
+// volumes as partitions depending on the container.
+func getDisks(ctx context.Context) ([]protocol.GuestDiskInfo, error) {
+	output, err := runOutput(ctx, "diskutil", "list", "-plist")
 	if err != nil {
 		return nil, err
 	}
-
-	// Since parsing plist is complex without additional dependencies,
-	// we'll use diskutil list in text format instead.
-	cmd = exec.Command("diskutil", "list")
-	output, err = cmd.Output()
+	list, err := plist.UnmarshalDict(output)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to parse diskutil output: %v", err)
 	}
 
-	return parseDiskUtilOutput(string(output))
-}
-
-// parseDiskUtilOutput parses the output of diskutil list.
-func parseDiskUtilOutput(output string) ([]protocol.GuestDiskInfo, error) {
-	var disks []protocol.GuestDiskInfo
-	var currentDisk *protocol.GuestDiskInfo
-
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+	resolver := newDiskResolver(ctx)
+	disks := []protocol.GuestDiskInfo{}
+	for _, entry := range list.Dicts("AllDisksAndPartitions") {
+		id := entry.String("DeviceIdentifier")
+		if id == "" {
 			continue
 		}
 
-		// Check if this is a new disk
-		if strings.HasPrefix(line, "/dev/disk") && !strings.Contains(line, "s") {
-			// If we were processing a disk, add it to the list
-			if currentDisk != nil {
-				disks = append(disks, *currentDisk)
-			}
+		disk := protocol.GuestDiskInfo{
+			Name:     "/dev/" + id,
+			HasMedia: entry.Int("Size") > 0,
+			Size:     entry.Int("Size"),
+		}
 
-			// Start a new disk
-			diskName := strings.Split(line, " ")[0]
-			currentDisk = &protocol.GuestDiskInfo{
-				Name:      diskName,
-				Partition: false,
-				HasMedia:  true,
-				Address: &protocol.GuestDiskAddress{
-					BusType: protocol.DiskBusUnknown,
-					Bus:     -1,
-					Target:  -1,
-					Unit:    -1,
-					PCIController: protocol.GuestPCIAddress{
-						Domain:   -1,
-						Bus:      -1,
-						Slot:     -1,
-						Function: -1,
-					},
-					Dev: diskName,
-				},
+		var children []plist.Dict
+		if stores := entry.Dicts("APFSPhysicalStores"); len(stores) > 0 {
+			for _, store := range stores {
+				if storeID := store.String("DeviceIdentifier"); storeID != "" {
+					disk.Dependencies = append(disk.Dependencies, "/dev/"+storeID)
+				}
 			}
+			children = entry.Dicts("APFSVolumes")
+		} else {
+			address := resolver.address(id)
+			disk.Address = &address
+			children = entry.Dicts("Partitions")
+		}
 
-			// Try to get disk size
-			if sizeStr := getDiskSize(diskName); sizeStr != "" {
-				if size, err := strconv.ParseInt(sizeStr, 10, 64); err == nil {
-					currentDisk.Size = size
-				}
+		partitions := make([]protocol.GuestDiskInfo, 0, len(children))
+		for _, child := range children {
+			childID := child.String("DeviceIdentifier")
+			if childID == "" {
+				continue
 			}
-		} else if currentDisk != nil && strings.Contains(line, ":") {
-			// This might be a partition
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 && strings.Contains(parts[0], " ") {
-				numParts := strings.Fields(parts[0])
-				if len(numParts) > 0 {
-					numStr := strings.Trim(numParts[0], " ")
-					if num, err := strconv.Atoi(numStr); err == nil {
-						name := strings.TrimSpace(parts[1])
-						partition := protocol.GuestPartitionInfo{
-							Number: num,
-							Name:   name,
-						}
-						currentDisk.Partitions = append(currentDisk.Partitions, partition)
-					}
-				}
+			name := child.String("VolumeName")
+			if name == "" {
+				name = child.String("Content")
+			}
+			number := 0
+			if m := partitionNumber.FindStringSubmatch(childID); m != nil {
+				number, _ = strconv.Atoi(m[1])
 			}
+			disk.Partitions = append(disk.Partitions, protocol.GuestPartitionInfo{
+				Number: number,
+				Name:   name,
+				Size:   child.Int("Size"),
+			})
+			partitions = append(partitions, protocol.GuestDiskInfo{
+				Name:         "/dev/" + childID,
+				Partition:    true,
+				Dependencies: []string{disk.Name},
+				HasMedia:     disk.HasMedia,
+				Size:         child.Int("Size"),
+			})
 		}
-	}
 
-	// Add the last disk if we were processing one
-	if currentDisk != nil {
-		disks = append(disks, *currentDisk)
+		disks = append(disks, disk)
+		disks = append(disks, partitions...)
 	}
-
 	return disks, nil
 }
-
-// getDiskSize retrieves the size of a disk in bytes.
-func getDiskSize(diskName string) string {
-	cmd := exec.Command("diskutil", "info", "-plist", diskName)
-	output, err := cmd.Output()
-	if err != nil {
-		return ""
-	}
-
-	// Since parsing plist is complex without additional dependencies,
-	// we'll use diskutil info in text format instead.
-	cmd = exec.Command("diskutil", "info", diskName)
-	output, err = cmd.Output()
-	if err != nil {
-		return ""
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "Disk Size") {
-			parts := strings.Split(line, ":")
-			if len(parts) >= 2 {
-				sizeStr := strings.TrimSpace(parts[1])
-				// Extract the bytes part from something like "500.3 GB (500277108736 Bytes)"
-				if strings.Contains(sizeStr, "(") && strings.Contains(sizeStr, "Bytes)") {
-					bytesStr := strings.Split(strings.Split(sizeStr, "(")[1], " ")[0]
-					return bytesStr
-				}
-			}
-		}
-	}
-
-	return ""
-}
@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/protocol"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetDisks(t *testing.T) {
+	useFixtures(t, "disks_sonoma.json")
+
+	got, err := getDisks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// name, dependencies and address of every entry, in order
+	want := []struct {
+		name    string
+		deps    string
+		address string
+	}{
+		{"/dev/disk0", "", `virtio 0:0:4.0 "VDISK-SYS01" /dev/disk0`},
+		{"/dev/disk0s1", "/dev/disk0", ""},
+		{"/dev/disk0s2", "/dev/disk0", ""},
+		// The synthesized APFS container depends on its physical store
+		{"/dev/disk1", "/dev/disk0s2", ""},
+		{"/dev/disk1s1", "/dev/disk1", ""},
+		{"/dev/disk1s2", "/dev/disk1", ""},
+		{"/dev/disk1s3", "/dev/disk1", ""},
+		{"/dev/disk1s4", "/dev/disk1", ""},
+		{"/dev/disk1s5", "/dev/disk1", ""},
+		{"/dev/disk1s6", "/dev/disk1", ""},
+		// Disk image: diskutil's BusProtocol is not a bus
+		{"/dev/disk2", "", `unknown -1:-1:-1.-1 "" /dev/disk2`},
+		{"/dev/disk2s1", "/dev/disk2", ""},
+		// Second virtio disk without a serial number
+		{"/dev/disk3", "", `virtio 0:0:5.0 "" /dev/disk3`},
+		{"/dev/disk3s1", "/dev/disk3", ""},
+		{"/dev/disk3s2", "/dev/disk3", ""},
+		{"/dev/disk4", "/dev/disk3s2", ""},
+		{"/dev/disk4s1", "/dev/disk4", ""},
+	}
+	if len(got) != len(want) {
+		var names []string
+		for _, disk := range got {
+			names = append(names, disk.Name)
+		}
+		t.Fatalf("guest-get-disks returned %q, want %d entries", names, len(want))
+	}
+	for i, w := range want {
+		disk := got[i]
+		address := ""
+		if disk.Address != nil {
+			address = describeAddress(*disk.Address)
+		}
+		deps := strings.Join(disk.Dependencies, ",")
+		if disk.Name != w.name || deps != w.deps || address != w.address {
+			t.Errorf("entry %d = %s (deps %q, address %q), want %s (deps %q, address %q)",
+				i, disk.Name, deps, address, w.name, w.deps, w.address)
+		}
+		if disk.Partition != partitionNumber.MatchString(w.name) {
+			t.Errorf("%s: partition = %v", disk.Name, disk.Partition)
+		}
+		if !disk.HasMedia || disk.Size == 0 {
+			t.Errorf("%s: has-media %v, size %d", disk.Name, disk.HasMedia, disk.Size)
+		}
+	}
+
+	wantPartitions := []protocol.GuestPartitionInfo{
+		{Number: 1, Name: "EFI", Size: 209715200},
+		{Number: 2, Name: "Apple_APFS", Size: 68509720576},
+	}
+	if !reflect.DeepEqual(got[0].Partitions, wantPartitions) {
+		t.Errorf("disk0 partitions = %+v, want %+v", got[0].Partitions, wantPartitions)
+	}
+	var volumes []string
+	for _, p := range got[3].Partitions {
+		volumes = append(volumes, p.Name)
+	}
+	wantVolumes := []string{"Macintosh HD", "Preboot", "Recovery", "VM", "Macintosh HD - Data", "Update"}
+	if !reflect.DeepEqual(volumes, wantVolumes) {
+		t.Errorf("disk1 volumes = %q, want %q", volumes, wantVolumes)
+	}
+}
+
+func TestGetDisksWithoutIORegistry(t *testing.T) {
+	fixtures, err := LoadFixtures("testdata/disks_sonoma.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var withoutIoreg []Fixture
+	for _, f := range fixtures {
+		if f.Argv[0] != "ioreg" {
+			withoutIoreg = append(withoutIoreg, f)
+		}
+	}
+	useRunner(t, NewFixtureRunner(withoutIoreg))
+
+	got, err := getDisks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// diskutil's BusProtocol of a virtio disk is "PCI", which says
+	// nothing about the bus; no serial or PCI address is known.
+	if address := got[0].Address; address == nil || describeAddress(*address) != `unknown -1:-1:-1.-1 "" /dev/disk0` {
+		t.Errorf("disk0 address = %+v", address)
+	}
+}
+
+func TestGetDisksFails(t *testing.T) {
+	useRunner(t, NewFixtureRunner([]Fixture{
+		{Argv: []string{"diskutil", "list", "-plist"}, Stdout: "Unable to run because unable to use the DiskManagement framework.\n"},
+	}))
+	if _, err := getDisks(context.Background()); err == nil {
+		t.Error("guest-get-disks succeeded with output that is not a property list")
+	}
+}
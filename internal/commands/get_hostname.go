@@ -1,8 +1,9 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
+	"mac-guest-agent/protocol"
 	"os"
 
 	"github.com/sirupsen/logrus"
@@ -12,18 +13,22 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-get-hostname",
 		Handler: handleGetHostname,
+		Returns: protocol.GuestHostName{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 	// Register the hyphenated version for backward compatibility with some clients.
 	RegisterCommand(&Command{
 		Name:    "guest-get-host-name",
 		Handler: handleGetHostname,
+		Returns: protocol.GuestHostName{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 }
 
 // handleGetHostname handles the guest-get-hostname command.
-func handleGetHostname(req json.RawMessage) (interface{}, error) {
+func handleGetHostname(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get hostname")
@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/protocol"
+	"os"
+	"testing"
+)
+
+func TestGetHostname(t *testing.T) {
+	want, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	// guest-get-host-name is kept for older hosts
+	for _, name := range []string{"guest-get-hostname", "guest-get-host-name"} {
+		cmd, ok := LookupCommand(name)
+		if !ok {
+			t.Fatalf("%s is not registered", name)
+		}
+		result, err := cmd.Handler(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := result.(protocol.GuestHostName).HostName; got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -3,10 +3,9 @@ package commands
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
-	"os/exec"
-	"runtime"
+	"mac-guest-agent/protocol"
 	"strings"
 	"sync"
 
@@ -23,20 +22,22 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-get-osinfo",
 		Handler: handleGetOSInfo,
+		Returns: protocol.GuestOSInfo{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 }
 
 // handleGetOSInfo handles the guest-get-osinfo command.
-func handleGetOSInfo(req json.RawMessage) (interface{}, error) {
+func handleGetOSInfo(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	osInfo := &protocol.GuestOSInfo{
 		ID:         "macos",
 		Name:       "macOS",
 		Variant:    "desktop",
 		VariantID:  "desktop",
-		PrettyName: getOSInfoField("ProductName") + " " + getOSInfoField("ProductVersion"),
-		Version:    getOSInfoField("ProductVersion"),
-		VersionID:  getOSInfoField("BuildVersion"),
+		PrettyName: getOSInfoField(ctx, "ProductName") + " " + getOSInfoField(ctx, "ProductVersion"),
+		Version:    getOSInfoField(ctx, "ProductVersion"),
+		VersionID:  getOSInfoField(ctx, "BuildVersion"),
 	}
 
 	if uname, err := getUnameInfo(); err == nil {
@@ -84,29 +85,33 @@ func getUnameInfo() (*UnameInfo, error) {
 	return info, nil
 }
 
-// getOSInfoField retrieves a specific field from the `sw_vers` command output.
-// It calls the command only once and caches the result for subsequent calls.
-func getOSInfoField(field string) string {
+// getOSInfoField returns a field printed by `sw_vers`. The command is run
+// only once; the result is cached for subsequent calls.
+func getOSInfoField(ctx context.Context, field string) string {
 	swVersOnce.Do(func() {
-		swVersOutput = make(map[string]string)
-		if runtime.GOOS != "darwin" {
-			return
-		}
-		cmd := exec.Command("sw_vers")
-		output, err := cmd.Output()
+		fields, err := readSwVers(ctx)
 		if err != nil {
-			return
-		}
-		scanner := bufio.NewScanner(strings.NewReader(string(output)))
-		for scanner.Scan() {
-			line := scanner.Text()
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-				swVersOutput[key] = value
-			}
+			logrus.WithError(err).Debug("Failed to run sw_vers")
+			fields = make(map[string]string)
 		}
+		swVersOutput = fields
 	})
 	return swVersOutput[field]
 }
+
+// readSwVers runs `sw_vers` and returns its "Key: value" lines as a map.
+func readSwVers(ctx context.Context) (map[string]string, error) {
+	output, err := runOutput(ctx, "sw_vers")
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok {
+			fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/protocol"
+	"sync"
+	"testing"
+)
+
+func TestGetOSInfo(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		prettyName string
+		version    string
+		versionID  string
+	}{
+		{"sw_vers_sonoma.json", "macOS 14.4.1", "14.4.1", "23E224"},
+		{"sw_vers_catalina.json", "Mac OS X 10.15.7", "10.15.7", "19H2026"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			useFixtures(t, tt.fixture)
+			swVersOnce = sync.Once{}
+			t.Cleanup(func() { swVersOnce = sync.Once{} })
+
+			result, err := handleGetOSInfo(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info := result.(*protocol.GuestOSInfo)
+			if info.ID != "macos" || info.PrettyName != tt.prettyName ||
+				info.Version != tt.version || info.VersionID != tt.versionID {
+				t.Errorf("guest-get-osinfo = %+v, want %q %q %q", info, tt.prettyName, tt.version, tt.versionID)
+			}
+		})
+	}
+}
+
+func TestReadSwVersFailure(t *testing.T) {
+	useRunner(t, NewFixtureRunner(nil))
+	if _, err := readSwVers(context.Background()); err == nil {
+		t.Error("readSwVers() succeeded without sw_vers")
+	}
+}
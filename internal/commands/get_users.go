@@ -2,9 +2,10 @@ package commands
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
-	"os/exec"
+	"mac-guest-agent/protocol"
+	"sort"
 	"strings"
 	"time"
 
@@ -15,13 +16,15 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-get-users",
 		Handler: handleGetUsers,
+		Returns: []protocol.GuestUser{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 }
 
 // handleGetUsers handles the guest-get-users command.
-func handleGetUsers(req json.RawMessage) (interface{}, error) {
-	users, err := getLoggedInUsers()
+func handleGetUsers(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	users, err := getLoggedInUsers(ctx)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get logged-in users")
 		return nil, err
@@ -32,9 +35,8 @@ func handleGetUsers(req json.RawMessage) (interface{}, error) {
 
 // getLoggedInUsers retrieves the currently logged-in users.
 // It uses the `who` command, which is standard and reliable.
-func getLoggedInUsers() ([]protocol.GuestUser, error) {
-	cmd := exec.Command("who")
-	output, err := cmd.Output()
+func getLoggedInUsers(ctx context.Context) ([]protocol.GuestUser, error) {
+	output, err := runOutput(ctx, "who")
 	if err != nil {
 		return nil, err
 	}
@@ -62,6 +64,7 @@ func getLoggedInUsers() ([]protocol.GuestUser, error) {
 	for _, user := range userMap {
 		users = append(users, user)
 	}
+	sort.Slice(users, func(i, j int) bool { return users[i].User < users[j].User })
 
 	return users, nil
 }
@@ -71,7 +74,7 @@ func parseWhoLine(line string) *protocol.GuestUser {
 	// `who` output format: username terminal date time
 	// Example: user1 console  Jun 29 12:00
 	fields := strings.Fields(line)
-	if len(fields) < 4 {
+	if len(fields) < 5 {
 		return nil
 	}
 
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/protocol"
+	"testing"
+	"time"
+)
+
+func TestGetUsers(t *testing.T) {
+	tests := []struct {
+		fixture string
+		users   []string
+		logins  []string // "Jan _2 15:04" in UTC
+	}{
+		{
+			// admin is logged in three times; the earliest login is kept
+			fixture: "who.json",
+			users:   []string{"admin", "builder"},
+			logins:  []string{"Mar 27 18:45", "Mar 29 10:02"},
+		},
+		{
+			fixture: "who_empty.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			useFixtures(t, tt.fixture)
+			result, err := handleGetUsers(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			users := result.([]protocol.GuestUser)
+			if len(users) != len(tt.users) {
+				t.Fatalf("guest-get-users = %+v, want users %v", users, tt.users)
+			}
+			for i, user := range users {
+				login := time.Unix(int64(user.LoginTime), 0).UTC().Format("Jan _2 15:04")
+				if user.User != tt.users[i] || login != tt.logins[i] {
+					t.Errorf("user %d = %s logged in %s, want %s at %s", i, user.User, login, tt.users[i], tt.logins[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseWhoLine(t *testing.T) {
+	tests := []struct {
+		line string
+		user string
+	}{
+		{"admin    console  Mar 28 09:14", "admin"},
+		{"builder  ttys001  Mar  2 10:02 \t(192.168.64.1)", "builder"},
+		{"admin    console  Mar", ""},
+		{"admin    console  Mar 28", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		user := parseWhoLine(tt.line)
+		switch {
+		case tt.user == "" && user != nil:
+			t.Errorf("parseWhoLine(%q) = %+v, want nil", tt.line, user)
+		case tt.user != "" && (user == nil || user.User != tt.user):
+			t.Errorf("parseWhoLine(%q) = %+v, want user %s", tt.line, user, tt.user)
+		}
+	}
+}
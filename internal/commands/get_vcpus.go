@@ -1,9 +1,9 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
-	"os/exec"
+	"mac-guest-agent/protocol"
 	"runtime"
 	"strconv"
 	"strings"
@@ -15,13 +15,15 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-get-vcpus",
 		Handler: handleGetVCPUs,
+		Returns: []protocol.GuestLogicalProcessor{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 }
 
 // handleGetVCPUs handles the guest-get-vcpus command.
-func handleGetVCPUs(req json.RawMessage) (interface{}, error) {
-	vcpus, err := getVirtualCPUs()
+func handleGetVCPUs(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	vcpus, err := getVirtualCPUs(ctx)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get vCPU information")
 		return nil, err
@@ -33,7 +35,7 @@ func handleGetVCPUs(req json.RawMessage) (interface{}, error) {
 // getVirtualCPUs retrieves information about the virtual CPUs.
 // On macOS, all logical processors are reported as online and cannot be
 // hot-unplugged.
-func getVirtualCPUs() ([]protocol.GuestLogicalProcessor, error) {
+func getVirtualCPUs(ctx context.Context) ([]protocol.GuestLogicalProcessor, error) {
 	numCPU := runtime.NumCPU()
 	vcpus := make([]protocol.GuestLogicalProcessor, numCPU)
 
@@ -46,7 +48,7 @@ func getVirtualCPUs() ([]protocol.GuestLogicalProcessor, error) {
 	}
 
 	// 尝试从系统获取更详细的CPU信息
-	if detailedVCPUs := getDetailedCPUInfo(); detailedVCPUs != nil {
+	if detailedVCPUs := getDetailedCPUInfo(ctx); detailedVCPUs != nil {
 		return detailedVCPUs, nil
 	}
 
@@ -54,10 +56,9 @@ func getVirtualCPUs() ([]protocol.GuestLogicalProcessor, error) {
 }
 
 // getDetailedCPUInfo 获取详细的CPU信息
-func getDetailedCPUInfo() []protocol.GuestLogicalProcessor {
+func getDetailedCPUInfo(ctx context.Context) []protocol.GuestLogicalProcessor {
 	// 在macOS上使用sysctl获取CPU信息
-	cmd := exec.Command("sysctl", "-n", "machdep.cpu.thread_count")
-	output, err := cmd.Output()
+	output, err := runOutput(ctx, "sysctl", "-n", "machdep.cpu.thread_count")
 	if err != nil {
 		logrus.WithError(err).Debug("无法获取CPU线程数")
 		return nil
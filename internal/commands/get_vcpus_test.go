@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/protocol"
+	"runtime"
+	"testing"
+)
+
+func TestGetVCPUs(t *testing.T) {
+	tests := []struct {
+		fixture string
+		count   int
+	}{
+		{"sysctl_vcpus.json", 4},
+		// Falls back to the number of CPUs the Go runtime sees
+		{"sysctl_vcpus_unknown.json", runtime.NumCPU()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			useFixtures(t, tt.fixture)
+			result, err := handleGetVCPUs(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vcpus := result.([]protocol.GuestLogicalProcessor)
+			if len(vcpus) != tt.count {
+				t.Fatalf("guest-get-vcpus returned %d vCPUs, want %d", len(vcpus), tt.count)
+			}
+			for i, vcpu := range vcpus {
+				if vcpu.LogicalID != i || !vcpu.Online || vcpu.CanOffline {
+					t.Errorf("vCPU %d = %+v", i, vcpu)
+				}
+			}
+		})
+	}
+}
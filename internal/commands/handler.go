@@ -2,9 +2,15 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"mac-guest-agent/internal/protocol"
+	"mac-guest-agent/internal/metrics"
+	"mac-guest-agent/protocol"
+	"sort"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -17,11 +23,110 @@ type Command struct {
 
 	// Handler is the function that executes the command's logic.
 	// It takes a json.RawMessage as input for arguments and returns the result
-	// or an error.
-	Handler func(req json.RawMessage) (interface{}, error)
+	// or an error. The context carries the command's deadline and must be
+	// passed to any external process the handler starts. A serial handler
+	// that outlives its deadline delays the next serial command until it
+	// returns.
+	Handler func(ctx context.Context, req json.RawMessage) (interface{}, error)
 
 	// Enabled controls whether the command is active and can be executed.
 	Enabled bool
+
+	// Class controls how the agent schedules the command relative to other
+	// requests. The zero value, ExecSerial, is the safe default for
+	// commands that change guest state.
+	Class ExecClass
+
+	// Args declares the arguments the command accepts. They are validated
+	// before the handler runs; a command without Args accepts none.
+	Args []Arg
+
+	// Timeout is the default execution deadline for the command. Zero
+	// selects DefaultTimeout. Operators may override it with
+	// SetCommandTimeout.
+	Timeout time.Duration
+
+	// Returns is a zero value of the command's result type. It is only used
+	// to describe the command in guest-query-schema; nil means any value.
+	Returns interface{}
+
+	// Support tells how faithfully the command is implemented on macOS.
+	// The zero value, SupportNative, means it does what upstream does.
+	Support SupportLevel
+}
+
+// DefaultTimeout is the execution deadline for commands that do not set
+// their own Timeout.
+const DefaultTimeout = 30 * time.Second
+
+var (
+	defaultTimeout   = DefaultTimeout
+	timeoutOverrides = make(map[string]time.Duration)
+	timeoutMutex     sync.RWMutex
+)
+
+// SetDefaultTimeout changes the deadline used for commands without their own
+// Timeout. A non-positive value restores DefaultTimeout.
+func SetDefaultTimeout(d time.Duration) {
+	timeoutMutex.Lock()
+	defer timeoutMutex.Unlock()
+	if d <= 0 {
+		d = DefaultTimeout
+	}
+	defaultTimeout = d
+}
+
+// SetCommandTimeout overrides the deadline of a single command. A
+// non-positive value removes the override.
+func SetCommandTimeout(name string, d time.Duration) {
+	timeoutMutex.Lock()
+	defer timeoutMutex.Unlock()
+	if d <= 0 {
+		delete(timeoutOverrides, name)
+		return
+	}
+	timeoutOverrides[name] = d
+}
+
+// commandTimeout returns the effective deadline for cmd.
+func commandTimeout(cmd *Command) time.Duration {
+	timeoutMutex.RLock()
+	defer timeoutMutex.RUnlock()
+	if d, ok := timeoutOverrides[cmd.Name]; ok {
+		return d
+	}
+	if cmd.Timeout > 0 {
+		return cmd.Timeout
+	}
+	return defaultTimeout
+}
+
+// ExecClass describes how a command may be scheduled by the dispatcher.
+type ExecClass int
+
+const (
+	// ExecSerial commands run one at a time, in the order they arrived.
+	ExecSerial ExecClass = iota
+
+	// ExecConcurrent commands only read guest state and may run in parallel
+	// with each other and with serial commands.
+	ExecConcurrent
+
+	// ExecHeartbeat commands are cheap liveness checks that are answered
+	// directly on the read path so they never queue behind slow commands.
+	ExecHeartbeat
+)
+
+// String returns a human readable name for the class.
+func (c ExecClass) String() string {
+	switch c {
+	case ExecConcurrent:
+		return "concurrent"
+	case ExecHeartbeat:
+		return "heartbeat"
+	default:
+		return "serial"
+	}
 }
 
 // CommandRegistry holds all registered commands for the agent.
@@ -38,8 +143,62 @@ func RegisterCommand(cmd *Command) {
 	CommandRegistry[cmd.Name] = cmd
 }
 
+// LookupCommand returns the registered command with the given name.
+func LookupCommand(name string) (*Command, bool) {
+	cmd, ok := CommandRegistry[name]
+	return cmd, ok
+}
+
+// CommandNames returns the names of all registered commands, sorted.
+func CommandNames() []string {
+	names := make([]string, 0, len(CommandRegistry))
+	for name := range CommandRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetCommandEnabled enables or disables a registered command. It reports
+// whether the command exists. It must be called before the agent starts.
+func SetCommandEnabled(name string, enabled bool) bool {
+	cmd, ok := CommandRegistry[name]
+	if ok {
+		cmd.Enabled = enabled
+	}
+	return ok
+}
+
 // HandleCommand processes an incoming command request using the CommandRegistry.
-func HandleCommand(req protocol.QMPRequest) protocol.QMPResponse {
+// The handler runs under a deadline derived from ctx and the command's
+// timeout; if it does not return in time a Timeout error is reported to the
+// host even if the handler itself ignores the context. The outcome is
+// recorded in the audit log and the command metrics.
+func HandleCommand(ctx context.Context, req protocol.QMPRequest) protocol.QMPResponse {
+	start := time.Now()
+	resp := handleCommand(ctx, req)
+	recordAudit(req, resp, start)
+	recordMetrics(req, resp, start)
+	return resp
+}
+
+// recordMetrics counts a handled request in the command metrics. Names the
+// host sent that are not registered share one label value so they cannot
+// grow the metrics without bound.
+func recordMetrics(req protocol.QMPRequest, resp protocol.QMPResponse, start time.Time) {
+	name := req.Execute
+	if _, ok := CommandRegistry[name]; !ok {
+		name = "unknown"
+	}
+	metrics.CommandRequests.Inc(name)
+	metrics.CommandDuration.Observe(time.Since(start).Seconds(), name)
+	if resp.Error != nil {
+		metrics.CommandErrors.Inc(name, resp.Error.Class)
+	}
+}
+
+// handleCommand runs a request for HandleCommand.
+func handleCommand(ctx context.Context, req protocol.QMPRequest) protocol.QMPResponse {
 	// 对于高频心跳命令使用Debug级别，其他命令使用Info级别
 	if req.Execute == "guest-ping" || req.Execute == "guest-sync" || req.Execute == "guest-sync-delimited" {
 		log.Debugf("Handling command: %s", req.Execute)
@@ -50,12 +209,19 @@ func HandleCommand(req protocol.QMPRequest) protocol.QMPResponse {
 	cmd, ok := CommandRegistry[req.Execute]
 	if !ok || !cmd.Enabled {
 		log.Errorf("Command not found or disabled: %s", req.Execute)
-		return protocol.QMPResponse{
-			Error: &protocol.QMPError{
-				Class: "CommandNotFound",
-				Desc:  fmt.Sprintf("The command %s has not been found", req.Execute),
-			},
-		}
+		return errorResponse(NewError(protocol.ErrorClassCommandNotFound,
+			"The command %s has not been found", req.Execute))
+	}
+
+	if !commandAllowed(cmd.Name) {
+		log.Warnf("Command disabled: %s", req.Execute)
+		return errorResponse(NewError(protocol.ErrorClassCommandDisabled,
+			"The command %s has been disabled for this instance", req.Execute))
+	}
+
+	if err := checkSupport(cmd); err != nil {
+		log.Warnf("Refusing command %s: %v", req.Execute, err)
+		return errorResponse(err)
 	}
 
 	// The arguments in QMPRequest are an interface{}, but our handlers expect
@@ -69,27 +235,125 @@ func HandleCommand(req protocol.QMPRequest) protocol.QMPResponse {
 			argBytes, err := json.Marshal(req.Arguments)
 			if err != nil {
 				log.Errorf("Failed to marshal arguments for command %s: %v", req.Execute, err)
-				return protocol.QMPResponse{
-					Error: &protocol.QMPError{Class: "InvalidParameter", Desc: "could not marshal arguments"},
-				}
+				return errorResponse(NewError(protocol.ErrorClassGeneric, "could not marshal arguments"))
 			}
 			argsJSON = argBytes
 		}
 	}
 
+	if err := validateArgs(cmd, argsJSON); err != nil {
+		log.Errorf("Invalid arguments for command %s: %v", req.Execute, err)
+		return errorResponse(err)
+	}
+
+	timeout := commandTimeout(cmd)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	serial := cmd.Class == ExecSerial
+	if serial {
+		if err := waitSerialIdle(ctx); err != nil {
+			log.Errorf("Command %s timed out waiting for an earlier command", req.Execute)
+			return errorResponse(NewError(protocol.ErrorClassTimeout,
+				"command %s timed out after %s waiting for an earlier command to finish", req.Execute, timeout))
+		}
+	}
+
 	// Execute the command handler.
-	result, err := cmd.Handler(argsJSON)
-	if err != nil {
-		log.Errorf("Error executing command %s: %v", req.Execute, err)
-		return protocol.QMPResponse{
-			Error: &protocol.QMPError{
-				Class: "GenericError",
-				Desc:  err.Error(),
-			},
+	type handlerResult struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan handlerResult, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer func() {
+			if r := recover(); r != nil {
+				done <- handlerResult{err: fmt.Errorf("internal error while executing %s: %v", req.Execute, r)}
+			}
+		}()
+		value, err := cmd.Handler(ctx, argsJSON)
+		done <- handlerResult{value: value, err: err}
+	}()
+
+	var result handlerResult
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result.err = ctx.Err()
+		if serial {
+			// The handler keeps running; the next serial command must not
+			// start until it has returned.
+			setSerialBusy(finished)
 		}
 	}
 
+	if result.err != nil {
+		if errors.Is(result.err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Errorf("Command %s timed out after %s", req.Execute, timeout)
+			return errorResponse(NewError(protocol.ErrorClassTimeout,
+				"command %s timed out after %s", req.Execute, timeout))
+		}
+		log.Errorf("Error executing command %s: %v", req.Execute, result.err)
+		return errorResponse(result.err)
+	}
+
+	return protocol.QMPResponse{
+		Return: result.value,
+	}
+}
+
+var (
+	// serialBusy is closed when the serial handler that last outlived its
+	// deadline returns; nil when there is none.
+	serialBusy      chan struct{}
+	serialBusyMutex sync.Mutex
+)
+
+// setSerialBusy records a serial handler that is still running after its
+// command timed out.
+func setSerialBusy(finished chan struct{}) {
+	serialBusyMutex.Lock()
+	defer serialBusyMutex.Unlock()
+	serialBusy = finished
+}
+
+// waitSerialIdle waits until no timed-out serial handler is still running,
+// or ctx is done.
+func waitSerialIdle(ctx context.Context) error {
+	serialBusyMutex.Lock()
+	busy := serialBusy
+	serialBusyMutex.Unlock()
+	if busy == nil {
+		return nil
+	}
+
+	log.Warn("Waiting for a timed-out serial command to return")
+	select {
+	case <-busy:
+		serialBusyMutex.Lock()
+		if serialBusy == busy {
+			serialBusy = nil
+		}
+		serialBusyMutex.Unlock()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// errorResponse converts a handler error into a QMP error response.
+func errorResponse(err error) protocol.QMPResponse {
+	class := protocol.ErrorClassGeneric
+	var cmdErr *Error
+	if errors.As(err, &cmdErr) {
+		class = cmdErr.Class
+	}
 	return protocol.QMPResponse{
-		Return: result,
+		Error: &protocol.QMPError{
+			Class: class,
+			Desc:  err.Error(),
+		},
 	}
 }
@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"mac-guest-agent/protocol"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// registerTestCommand registers cmd for the duration of a test.
+func registerTestCommand(t *testing.T, cmd *Command) {
+	t.Helper()
+	cmd.Enabled = true
+	RegisterCommand(cmd)
+	t.Cleanup(func() { delete(CommandRegistry, cmd.Name) })
+}
+
+func TestSerialCommandWaitsForTimedOutHandler(t *testing.T) {
+	release := make(chan struct{})
+	var running int32
+	registerTestCommand(t, &Command{
+		Name:    "test-slow-serial",
+		Timeout: 50 * time.Millisecond,
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			<-release // ignores ctx, like a handler stuck in a syscall
+			return nil, nil
+		},
+	})
+	registerTestCommand(t, &Command{
+		Name: "test-next-serial",
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			return atomic.LoadInt32(&running), nil
+		},
+	})
+
+	resp := HandleCommand(context.Background(), protocol.QMPRequest{Execute: "test-slow-serial"})
+	if resp.Error == nil || resp.Error.Class != protocol.ErrorClassTimeout {
+		t.Fatalf("slow command returned %+v, want a Timeout error", resp)
+	}
+
+	next := make(chan protocol.QMPResponse, 1)
+	go func() {
+		next <- HandleCommand(context.Background(), protocol.QMPRequest{Execute: "test-next-serial"})
+	}()
+
+	select {
+	case resp := <-next:
+		t.Fatalf("next serial command ran while the previous handler was running: %+v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case resp := <-next:
+		if resp.Error != nil || resp.Return != int32(0) {
+			t.Errorf("next serial command returned %+v, want 0 handlers running", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("next serial command did not run after the previous handler returned")
+	}
+}
+
+func TestSerialCommandTimesOutWaitingForHandler(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	registerTestCommand(t, &Command{
+		Name:    "test-stuck-serial",
+		Timeout: 20 * time.Millisecond,
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			<-release
+			return nil, nil
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		resp := HandleCommand(context.Background(), protocol.QMPRequest{Execute: "test-stuck-serial"})
+		if resp.Error == nil || resp.Error.Class != protocol.ErrorClassTimeout {
+			t.Fatalf("call %d returned %+v, want a Timeout error", i, resp)
+		}
+	}
+}
+
+func TestConcurrentCommandDoesNotWait(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	registerTestCommand(t, &Command{
+		Name:    "test-stuck-serial-2",
+		Timeout: 20 * time.Millisecond,
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			<-release
+			return nil, nil
+		},
+	})
+	registerTestCommand(t, &Command{
+		Name:  "test-concurrent",
+		Class: ExecConcurrent,
+		Handler: func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+			return "ok", nil
+		},
+	})
+
+	HandleCommand(context.Background(), protocol.QMPRequest{Execute: "test-stuck-serial-2"})
+	resp := HandleCommand(context.Background(), protocol.QMPRequest{Execute: "test-concurrent"})
+	if resp.Error != nil || resp.Return != "ok" {
+		t.Errorf("concurrent command returned %+v", resp)
+	}
+}
@@ -1,9 +1,9 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
-	"sort"
+	"mac-guest-agent/protocol"
 
 	"github.com/sirupsen/logrus"
 )
@@ -16,13 +16,15 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-info",
 		Handler: handleGuestInfo,
+		Returns: protocol.GuestAgentInfo{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 }
 
 // handleGuestInfo handles the guest-info command, returning information
 // about the guest agent and its supported commands.
-func handleGuestInfo(req json.RawMessage) (interface{}, error) {
+func handleGuestInfo(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	supportedCommands := getSupportedCommands()
 	agentInfo := &protocol.GuestAgentInfo{
 		Version:           AgentVersion,
@@ -41,18 +43,15 @@ func handleGuestInfo(req json.RawMessage) (interface{}, error) {
 // from the CommandRegistry.
 func getSupportedCommands() []protocol.GuestAgentCommandInfo {
 	// Ensure the list is sorted for consistent output.
-	commandNames := make([]string, 0, len(CommandRegistry))
-	for name := range CommandRegistry {
-		commandNames = append(commandNames, name)
-	}
-	sort.Strings(commandNames)
+	commandNames := CommandNames()
 
 	commands := make([]protocol.GuestAgentCommandInfo, 0, len(commandNames))
 	for _, name := range commandNames {
 		cmd := CommandRegistry[name]
 		commandInfo := protocol.GuestAgentCommandInfo{
 			Name:    cmd.Name,
-			Enabled: cmd.Enabled,
+			Enabled: advertised(cmd),
+			Support: cmd.Support.String(),
 			// QEMU guest agent expects this field, defaulting to true.
 			SuccessResponse: true,
 		}
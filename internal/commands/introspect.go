@@ -0,0 +1,259 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"mac-guest-agent/protocol"
+	"reflect"
+	"strings"
+)
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "guest-query-schema",
+		Handler: handleGuestQuerySchema,
+		Returns: []interface{}{},
+		Enabled: true,
+		Class:   ExecConcurrent,
+	})
+}
+
+// schemaBuiltins maps the builtin type names to their JSON types.
+var schemaBuiltins = []struct{ name, jsonType string }{
+	{"str", "string"},
+	{"int", "int"},
+	{"number", "number"},
+	{"bool", "boolean"},
+	{"any", "value"},
+}
+
+// schemaEnums lists the values of the string types used in results. Types
+// not listed here are described as plain strings.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(protocol.GuestFsfreezeStatus("")): {
+		string(protocol.FsfreezeStatusThawed),
+		string(protocol.FsfreezeStatusFrozen),
+	},
+	reflect.TypeOf(protocol.GuestIpAddressType("")): {
+		string(protocol.IPv4),
+		string(protocol.IPv6),
+	},
+	reflect.TypeOf(protocol.GuestDiskBusType("")): {
+		string(protocol.DiskBusIDE), string(protocol.DiskBusFDC),
+		string(protocol.DiskBusSCSI), string(protocol.DiskBusVirtio),
+		string(protocol.DiskBusXen), string(protocol.DiskBusUSB),
+		string(protocol.DiskBusSATA), string(protocol.DiskBusSD),
+		string(protocol.DiskBusNVMe), string(protocol.DiskBusUnknown),
+	},
+}
+
+// handleGuestQuerySchema handles the guest-query-schema command. Like QMP's
+// query-qmp-schema it returns a flat list of entities: one per command,
+// followed by every type they refer to.
+func handleGuestQuerySchema(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	b := &schemaBuilder{seen: make(map[string]bool)}
+	var commands []interface{}
+	for _, name := range CommandNames() {
+		cmd := CommandRegistry[name]
+		commands = append(commands, protocol.SchemaInfoCommand{
+			Name:     cmd.Name,
+			MetaType: protocol.SchemaMetaTypeCommand,
+			ArgType:  b.argsType(cmd.Name+"-arg", cmd.Args),
+			RetType:  b.returnType(cmd.Returns),
+			Support:  cmd.Support.String(),
+			Enabled:  advertised(cmd),
+		})
+	}
+	for _, builtin := range schemaBuiltins {
+		b.entities = append(b.entities, protocol.SchemaInfoBuiltin{
+			Name:     builtin.name,
+			MetaType: protocol.SchemaMetaTypeBuiltin,
+			JSONType: builtin.jsonType,
+		})
+	}
+	return append(commands, b.entities...), nil
+}
+
+// schemaBuilder collects the type entities referred to by commands. Each
+// type is emitted once, the first time it is seen.
+type schemaBuilder struct {
+	entities []interface{}
+	seen     map[string]bool
+}
+
+// add records an entity and reports whether it was new.
+func (b *schemaBuilder) add(name string) bool {
+	if b.seen[name] {
+		return false
+	}
+	b.seen[name] = true
+	return true
+}
+
+// argsType describes a command's argument schema as an object type.
+func (b *schemaBuilder) argsType(name string, args []Arg) string {
+	members := make([]protocol.SchemaInfoObjectMember, 0, len(args))
+	for _, arg := range args {
+		members = append(members, protocol.SchemaInfoObjectMember{
+			Name:     arg.Name,
+			Type:     b.argType(name+"-"+arg.Name, arg),
+			Optional: arg.Optional,
+		})
+	}
+	b.add(name)
+	b.entities = append(b.entities, protocol.SchemaInfoObject{
+		Name:     name,
+		MetaType: protocol.SchemaMetaTypeObject,
+		Members:  members,
+	})
+	return name
+}
+
+// argType describes a single argument. Arguments accepting several JSON
+// types are described as alternates named after the argument.
+func (b *schemaBuilder) argType(name string, arg Arg) string {
+	var types []string
+	for _, n := range argTypeNames {
+		if arg.Type&n.t == 0 {
+			continue
+		}
+		switch n.t {
+		case TypeString:
+			if len(arg.Enum) == 0 {
+				types = append(types, "str")
+				break
+			}
+			enum := name + "-value"
+			if b.add(enum) {
+				b.entities = append(b.entities, protocol.SchemaInfoEnum{
+					Name:     enum,
+					MetaType: protocol.SchemaMetaTypeEnum,
+					Values:   arg.Enum,
+				})
+			}
+			types = append(types, enum)
+		case TypeInt:
+			types = append(types, "int")
+		case TypeNumber:
+			types = append(types, "number")
+		case TypeBool:
+			types = append(types, "bool")
+		case TypeArray:
+			elem := "any"
+			if arg.Elem != 0 {
+				elem = b.argType(name+"-elem", Arg{Type: arg.Elem})
+			}
+			types = append(types, b.arrayType(elem))
+		case TypeObject:
+			types = append(types, "any")
+		}
+	}
+
+	if len(types) == 1 {
+		return types[0]
+	}
+	if b.add(name) {
+		members := make([]protocol.SchemaInfoAlternateMember, 0, len(types))
+		for _, t := range types {
+			members = append(members, protocol.SchemaInfoAlternateMember{Type: t})
+		}
+		b.entities = append(b.entities, protocol.SchemaInfoAlternate{
+			Name:     name,
+			MetaType: protocol.SchemaMetaTypeAlternate,
+			Members:  members,
+		})
+	}
+	return name
+}
+
+// arrayType returns the name of the array type of elem.
+func (b *schemaBuilder) arrayType(elem string) string {
+	name := "[" + elem + "]"
+	if b.add(name) {
+		b.entities = append(b.entities, protocol.SchemaInfoArray{
+			Name:        name,
+			MetaType:    protocol.SchemaMetaTypeArray,
+			ElementType: elem,
+		})
+	}
+	return name
+}
+
+// returnType describes a command's result from a zero value of its type.
+func (b *schemaBuilder) returnType(v interface{}) string {
+	if v == nil {
+		return "any"
+	}
+	return b.goType(reflect.TypeOf(v))
+}
+
+// goType describes a Go type using the field names of its JSON encoding.
+func (b *schemaBuilder) goType(t reflect.Type) string {
+	if values, ok := schemaEnums[t]; ok {
+		if b.add(t.Name()) {
+			b.entities = append(b.entities, protocol.SchemaInfoEnum{
+				Name:     t.Name(),
+				MetaType: protocol.SchemaMetaTypeEnum,
+				Values:   values,
+			})
+		}
+		return t.Name()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.goType(t.Elem())
+	case reflect.String:
+		return "str"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return b.arrayType(b.goType(t.Elem()))
+	case reflect.Struct:
+		return b.structType(t)
+	default:
+		// Maps and interfaces have no fixed shape.
+		return "any"
+	}
+}
+
+// structType describes a struct as an object type. Pointer fields and
+// fields tagged omitempty are optional.
+func (b *schemaBuilder) structType(t reflect.Type) string {
+	name := t.Name()
+	if !b.add(name) {
+		return name
+	}
+	// Reserve the position so the object precedes the types of its members.
+	index := len(b.entities)
+	b.entities = append(b.entities, nil)
+
+	members := []protocol.SchemaInfoObjectMember{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.PkgPath != "" || tag == "-" {
+			continue
+		}
+		fieldName, opts, _ := strings.Cut(tag, ",")
+		if fieldName == "" {
+			fieldName = field.Name
+		}
+		members = append(members, protocol.SchemaInfoObjectMember{
+			Name:     fieldName,
+			Type:     b.goType(field.Type),
+			Optional: field.Type.Kind() == reflect.Ptr || strings.Contains(opts, "omitempty"),
+		})
+	}
+	b.entities[index] = protocol.SchemaInfoObject{
+		Name:     name,
+		MetaType: protocol.SchemaMetaTypeObject,
+		Members:  members,
+	}
+	return name
+}
@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"context"
+	"encoding/binary"
+	"mac-guest-agent/internal/plist"
+	"mac-guest-agent/protocol"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// diskHardware is what the IORegistry knows about the hardware behind a
+// whole disk.
+type diskHardware struct {
+	BusType protocol.GuestDiskBusType
+	PCI     protocol.GuestPCIAddress
+	Serial  string
+}
+
+// pciDebug matches the "bus:device:function" location IOPCIFamily
+// publishes in the pcidebug property of a PCI device.
+var pciDebug = regexp.MustCompile(`^(\d+):(\d+):(\d+)`)
+
+// readDiskHardware walks the IORegistry below every PCI device and returns
+// the hardware of each whole disk, keyed by BSD name (disk0). Only the
+// subtrees of PCI devices are requested since every disk the host can
+// attach hangs off a PCI controller; a disk inherits the address of the
+// nearest PCI device above it.
+func readDiskHardware(ctx context.Context) (map[string]diskHardware, error) {
+	output, err := runOutput(ctx, "ioreg", "-a", "-l", "-r", "-c", "IOPCIDevice")
+	if err != nil {
+		return nil, err
+	}
+	root, err := plist.Unmarshal(output)
+	if err != nil {
+		return nil, err
+	}
+
+	disks := make(map[string]diskHardware)
+	walkIORegistry(root, diskHardware{
+		BusType: protocol.DiskBusUnknown,
+		PCI:     protocol.GuestPCIAddress{Domain: -1, Bus: -1, Slot: -1, Function: -1},
+	}, disks)
+	return disks, nil
+}
+
+// walkIORegistry visits an IORegistry entry, or an array of them, and its
+// children. hw holds what was learned from the entries above it.
+func walkIORegistry(node interface{}, hw diskHardware, disks map[string]diskHardware) {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			walkIORegistry(child, hw, disks)
+		}
+		return
+	case plist.Dict:
+		class := n.String("IOObjectClass")
+
+		if class == "IOPCIDevice" {
+			if pci, ok := pciAddress(n); ok {
+				// Anything learned above belongs to a bridge, not to
+				// this controller.
+				hw = diskHardware{BusType: protocol.DiskBusUnknown, PCI: pci}
+			}
+		}
+		if interconnect := n.Dict("Protocol Characteristics").String("Physical Interconnect"); interconnect != "" {
+			if bus := diskBusType(interconnect); bus != protocol.DiskBusUnknown {
+				hw.BusType = bus
+			}
+		}
+		if strings.Contains(strings.ToLower(class), "virtio") {
+			hw.BusType = protocol.DiskBusVirtio
+		}
+		if serial := ioregSerial(n); serial != "" {
+			hw.Serial = serial
+		}
+
+		if class == "IOMedia" && n.Bool("Whole") {
+			if name := n.String("BSD Name"); name != "" {
+				disks[name] = hw
+			}
+		}
+		walkIORegistry(n["IORegistryEntryChildren"], hw, disks)
+	}
+}
+
+// pciAddress returns the location of a PCI device from its pcidebug
+// property, or failing that from the config space address in its reg
+// property. macOS does not expose PCI segments, so the domain is 0.
+func pciAddress(node plist.Dict) (protocol.GuestPCIAddress, bool) {
+	if m := pciDebug.FindStringSubmatch(node.String("pcidebug")); m != nil {
+		bus, _ := strconv.Atoi(m[1])
+		slot, _ := strconv.Atoi(m[2])
+		function, _ := strconv.Atoi(m[3])
+		return protocol.GuestPCIAddress{Domain: 0, Bus: bus, Slot: slot, Function: function}, true
+	}
+	if reg, ok := node["reg"].([]byte); ok && len(reg) >= 4 {
+		addr := binary.LittleEndian.Uint32(reg)
+		return protocol.GuestPCIAddress{
+			Domain:   0,
+			Bus:      int(addr >> 16 & 0xff),
+			Slot:     int(addr >> 11 & 0x1f),
+			Function: int(addr >> 8 & 0x7),
+		}, true
+	}
+	return protocol.GuestPCIAddress{}, false
+}
+
+// ioregSerial returns the serial number an entry publishes, either in its
+// Device Characteristics (SATA, virtio) or directly (NVMe controllers).
+func ioregSerial(node plist.Dict) string {
+	serial := node.Dict("Device Characteristics").String("Serial Number")
+	if serial == "" {
+		serial = node.String("Serial Number")
+	}
+	return strings.TrimSpace(serial)
+}
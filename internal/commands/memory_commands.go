@@ -2,9 +2,9 @@ package commands
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
-	"os/exec"
+	"mac-guest-agent/protocol"
 	"strconv"
 	"strings"
 
@@ -15,29 +15,40 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-get-memory-blocks",
 		Handler: handleGetMemoryBlocks,
+		Returns: []protocol.GuestMemoryBlock{},
+		Support: SupportEmulated,
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-get-memory-block-info",
 		Handler: handleGetMemoryBlockInfo,
+		Returns: protocol.GuestMemoryBlockInfo{},
+		Support: SupportEmulated,
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 	// guest-get-memory-info is an alias in some agents
 	RegisterCommand(&Command{
 		Name:    "guest-get-memory-info",
 		Handler: handleGetMemoryInfo,
+		Returns: map[string]int64{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-set-memory-blocks",
 		Handler: handleSetMemoryBlocks,
+		Returns: protocol.EmptyResponse{},
+		Support: SupportEmulated,
+		Args:    []Arg{{Name: "mem-blks", Type: TypeArray, Elem: TypeObject}},
 		Enabled: true,
 	})
 }
 
 // handleGetMemoryBlocks handles the guest-get-memory-blocks command.
-func handleGetMemoryBlocks(req json.RawMessage) (interface{}, error) {
-	blocks, err := getMemoryBlocks()
+func handleGetMemoryBlocks(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	blocks, err := getMemoryBlocks(ctx)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get memory blocks")
 		return nil, err
@@ -47,8 +58,8 @@ func handleGetMemoryBlocks(req json.RawMessage) (interface{}, error) {
 }
 
 // handleGetMemoryBlockInfo handles the guest-get-memory-block-info command.
-func handleGetMemoryBlockInfo(req json.RawMessage) (interface{}, error) {
-	info, err := getMemoryBlockInfo()
+func handleGetMemoryBlockInfo(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	info, err := getMemoryBlockInfo(ctx)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get memory block info")
 		return nil, err
@@ -60,22 +71,22 @@ func handleGetMemoryBlockInfo(req json.RawMessage) (interface{}, error) {
 // handleGetMemoryInfo handles the guest-get-memory-info command.
 // This is a more comprehensive command that is not part of the standard,
 // but useful for macOS.
-func handleGetMemoryInfo(req json.RawMessage) (interface{}, error) {
-	return getMemoryInfo()
+func handleGetMemoryInfo(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	return getMemoryInfo(ctx)
 }
 
 // handleSetMemoryBlocks handles the guest-set-memory-blocks command.
 // This is a no-op on macOS as memory hotplug is not supported.
-func handleSetMemoryBlocks(req json.RawMessage) (interface{}, error) {
+func handleSetMemoryBlocks(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	logrus.Warn("guest-set-memory-blocks is not supported on macOS")
 	return protocol.EmptyResponse{}, nil
 }
 
 // getMemoryBlocks retrieves information about memory blocks.
-func getMemoryBlocks() ([]protocol.GuestMemoryBlock, error) {
+func getMemoryBlocks(ctx context.Context) ([]protocol.GuestMemoryBlock, error) {
 	// macOS does not support memory hot-plugging in the same way as Linux.
 	// This provides a simulated implementation.
-	totalMemory, err := getTotalMemory()
+	totalMemory, err := getTotalMemory(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -150,9 +161,9 @@ func getMemoryBlocks() ([]protocol.GuestMemoryBlock, error) {
 }
 
 // getMemoryBlockInfo retrieves information about memory block size.
-func getMemoryBlockInfo() (*protocol.GuestMemoryBlockInfo, error) {
+func getMemoryBlockInfo(ctx context.Context) (*protocol.GuestMemoryBlockInfo, error) {
 	// 获取动态计算的内存块大小，与getMemoryBlocks保持一致
-	totalMemory, err := getTotalMemory()
+	totalMemory, err := getTotalMemory(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -195,10 +206,9 @@ func getMemoryBlockInfo() (*protocol.GuestMemoryBlockInfo, error) {
 }
 
 // getTotalMemory retrieves the total system memory.
-func getTotalMemory() (int64, error) {
+func getTotalMemory(ctx context.Context) (int64, error) {
 	// On macOS, use sysctl to get memory information.
-	cmd := exec.Command("sysctl", "-n", "hw.memsize")
-	output, err := cmd.Output()
+	output, err := runOutput(ctx, "sysctl", "-n", "hw.memsize")
 	if err != nil {
 		return 0, err
 	}
@@ -207,9 +217,8 @@ func getTotalMemory() (int64, error) {
 }
 
 // getMemoryInfo retrieves detailed memory information from `vm_stat`.
-func getMemoryInfo() (map[string]int64, error) {
-	cmd := exec.Command("vm_stat")
-	output, err := cmd.Output()
+func getMemoryInfo(ctx context.Context) (map[string]int64, error) {
+	output, err := runOutput(ctx, "vm_stat")
 	if err != nil {
 		return nil, err
 	}
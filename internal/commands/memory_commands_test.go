@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/protocol"
+	"testing"
+)
+
+func TestGetMemoryBlocks(t *testing.T) {
+	const MB = 1024 * 1024
+	tests := []struct {
+		fixture   string
+		blocks    int
+		blockSize int64
+	}{
+		{"memory_2g.json", 8, 256 * MB},
+		{"memory_8g.json", 16, 512 * MB},
+		{"memory_64g.json", 32, 2048 * MB},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			useFixtures(t, tt.fixture)
+			ctx := context.Background()
+
+			result, err := handleGetMemoryBlocks(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks := result.([]protocol.GuestMemoryBlock)
+			if len(blocks) != tt.blocks {
+				t.Errorf("guest-get-memory-blocks returned %d blocks, want %d", len(blocks), tt.blocks)
+			}
+			for i, block := range blocks {
+				if block.PhysIndex != i || !block.Online || block.CanOffline {
+					t.Errorf("block %d = %+v", i, block)
+				}
+			}
+
+			result, err = handleGetMemoryBlockInfo(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := result.(*protocol.GuestMemoryBlockInfo).Size; size != tt.blockSize {
+				t.Errorf("guest-get-memory-block-info size = %d, want %d", size, tt.blockSize)
+			}
+		})
+	}
+}
+
+func TestGetMemoryInfo(t *testing.T) {
+	useFixtures(t, "memory_8g.json")
+	result, err := handleGetMemoryInfo(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := result.(map[string]int64)
+	want := map[string]int64{
+		"Pages free":                   60524,
+		"Pages active":                 829135,
+		"Pages wired down":             243018,
+		`"Translation faults"`:         176463902,
+		"Pages occupied by compressor": 47319,
+		"Swapouts":                     0,
+	}
+	for key, value := range want {
+		if stats[key] != value {
+			t.Errorf("%s = %d, want %d", key, stats[key], value)
+		}
+	}
+	if _, ok := stats["Mach Virtual Memory Statistics"]; ok {
+		t.Error("the vm_stat header was parsed as a counter")
+	}
+}
+
+func TestGetMemoryBlocksWithoutSysctl(t *testing.T) {
+	useRunner(t, NewFixtureRunner(nil))
+	if _, err := handleGetMemoryBlocks(context.Background(), nil); err == nil {
+		t.Error("guest-get-memory-blocks succeeded without hw.memsize")
+	}
+}
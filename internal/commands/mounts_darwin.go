@@ -0,0 +1,34 @@
+package commands
+
+import "golang.org/x/sys/unix"
+
+// readMountTable returns every mounted filesystem with its usage, read with
+// a single getfsstat(2) call.
+func readMountTable() ([]mountEntry, error) {
+	n, err := unix.Getfsstat(nil, unix.MNT_NOWAIT)
+	if err != nil {
+		return nil, err
+	}
+	// Leave room for filesystems mounted between the two calls.
+	buf := make([]unix.Statfs_t, n+8)
+	n, err = unix.Getfsstat(buf, unix.MNT_NOWAIT)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]mountEntry, 0, n)
+	for _, st := range buf[:n] {
+		entries = append(entries, mountEntry{
+			Device:     unix.ByteSliceToString(st.Mntfromname[:]),
+			Mountpoint: unix.ByteSliceToString(st.Mntonname[:]),
+			Type:       unix.ByteSliceToString(st.Fstypename[:]),
+			ReadOnly:   st.Flags&unix.MNT_RDONLY != 0,
+			NoBrowse:   st.Flags&unix.MNT_DONTBROWSE != 0,
+			BlockSize:  uint64(st.Bsize),
+			Blocks:     st.Blocks,
+			Free:       st.Bfree,
+			Avail:      st.Bavail,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readMountTable returns every mounted filesystem with its usage, read from
+// /proc/self/mounts.
+func readMountTable() ([]mountEntry, error) {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []mountEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		entry := mountEntry{
+			Device:     unescapeMountField(fields[0]),
+			Mountpoint: unescapeMountField(fields[1]),
+			Type:       fields[2],
+		}
+		var st unix.Statfs_t
+		if err := unix.Statfs(entry.Mountpoint, &st); err == nil {
+			entry.ReadOnly = st.Flags&unix.ST_RDONLY != 0
+			entry.BlockSize = uint64(st.Frsize)
+			entry.Blocks = st.Blocks
+			entry.Free = st.Bfree
+			entry.Avail = st.Bavail
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes /proc/self/mounts uses for
+// spaces and other special characters.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			valid := true
+			for _, d := range s[i+1 : i+4] {
+				if d < '0' || d > '7' {
+					valid = false
+					break
+				}
+				c = c*8 + byte(d-'0')
+			}
+			if valid {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
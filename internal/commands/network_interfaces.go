@@ -2,10 +2,10 @@ package commands
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
+	"mac-guest-agent/protocol"
 	"net"
-	"os/exec"
 	"strconv"
 	"strings"
 
@@ -16,13 +16,15 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-network-get-interfaces",
 		Handler: handleNetworkGetInterfaces,
+		Returns: []protocol.GuestNetworkInterface{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 }
 
 // handleNetworkGetInterfaces handles the guest-network-get-interfaces command.
-func handleNetworkGetInterfaces(req json.RawMessage) (interface{}, error) {
-	interfaces, err := getNetworkInterfaces()
+func handleNetworkGetInterfaces(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	interfaces, err := getNetworkInterfaces(ctx)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get network interfaces")
 		return nil, err
@@ -33,7 +35,7 @@ func handleNetworkGetInterfaces(req json.RawMessage) (interface{}, error) {
 }
 
 // getNetworkInterfaces retrieves network interface information.
-func getNetworkInterfaces() ([]protocol.GuestNetworkInterface, error) {
+func getNetworkInterfaces(ctx context.Context) ([]protocol.GuestNetworkInterface, error) {
 	var interfaces []protocol.GuestNetworkInterface
 
 	netInterfaces, err := net.Interfaces()
@@ -65,7 +67,7 @@ func getNetworkInterfaces() ([]protocol.GuestNetworkInterface, error) {
 			guestIface.IPAddresses = ipAddresses
 		}
 
-		if stats := getInterfaceStatistics(iface.Name); stats != nil {
+		if stats := getInterfaceStatistics(ctx, iface.Name); stats != nil {
 			guestIface.Statistics = stats
 		}
 
@@ -108,9 +110,8 @@ func parseIPAddress(addr net.Addr) *protocol.GuestIpAddress {
 }
 
 // getInterfaceStatistics retrieves statistics for a given interface.
-func getInterfaceStatistics(ifaceName string) *protocol.GuestNetworkInterfaceStat {
-	cmd := exec.Command("netstat", "-ibn")
-	output, err := cmd.Output()
+func getInterfaceStatistics(ctx context.Context, ifaceName string) *protocol.GuestNetworkInterfaceStat {
+	output, err := runOutput(ctx, "netstat", "-ibn")
 	if err != nil {
 		logrus.WithError(err).WithField("interface", ifaceName).Debug("Failed to get network statistics")
 		return nil
@@ -118,36 +119,33 @@ func getInterfaceStatistics(ifaceName string) *protocol.GuestNetworkInterfaceSta
 	return parseNetstatOutput(string(output), ifaceName)
 }
 
-// parseNetstatOutput parses the output of the netstat command.
+// parseNetstatOutput parses the output of `netstat -ibn`. Each interface
+// has a <Link#n> line with the counters of the whole interface, followed by
+// one line per address. The Address column is empty for interfaces without
+// a hardware address, so the counters are taken from the end of the line:
+//
+//	Name  Mtu   Network     Address            Ipkts Ierrs  Ibytes  Opkts Oerrs  Obytes  Coll
 func parseNetstatOutput(output, ifaceName string) *protocol.GuestNetworkInterfaceStat {
 	scanner := bufio.NewScanner(strings.NewReader(output))
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		fields := strings.Fields(line)
-		if len(fields) < 10 || fields[0] != ifaceName {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[0] != ifaceName || !strings.HasPrefix(fields[2], "<Link#") {
 			continue
 		}
 
-		stats := &protocol.GuestNetworkInterfaceStat{}
-		if val, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
-			stats.RxPackets = val
+		counters := fields[len(fields)-7:]
+		value := func(i int) int64 {
+			v, _ := strconv.ParseInt(counters[i], 10, 64)
+			return v
 		}
-		if val, err := strconv.ParseInt(fields[5], 10, 64); err == nil {
-			stats.RxErrs = val
+		return &protocol.GuestNetworkInterfaceStat{
+			RxPackets: value(0),
+			RxErrs:    value(1),
+			RxBytes:   value(2),
+			TxPackets: value(3),
+			TxErrs:    value(4),
+			TxBytes:   value(5),
 		}
-		if val, err := strconv.ParseInt(fields[6], 10, 64); err == nil {
-			stats.RxBytes = val
-		}
-		if val, err := strconv.ParseInt(fields[7], 10, 64); err == nil {
-			stats.TxPackets = val
-		}
-		if val, err := strconv.ParseInt(fields[8], 10, 64); err == nil {
-			stats.TxErrs = val
-		}
-		if val, err := strconv.ParseInt(fields[9], 10, 64); err == nil {
-			stats.TxBytes = val
-		}
-		return stats
 	}
 	return nil
 }
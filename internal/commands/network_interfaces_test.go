@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/protocol"
+	"testing"
+)
+
+func TestInterfaceStatistics(t *testing.T) {
+	useFixtures(t, "netstat.json")
+
+	tests := []struct {
+		iface string
+		want  *protocol.GuestNetworkInterfaceStat
+	}{
+		{"en0", &protocol.GuestNetworkInterfaceStat{
+			RxPackets: 1832519, RxErrs: 3, RxBytes: 2398271634,
+			TxPackets: 711932, TxBytes: 64410255,
+		}},
+		{"lo0", &protocol.GuestNetworkInterfaceStat{
+			RxPackets: 48102, RxBytes: 9153216, TxPackets: 48102, TxBytes: 9153216,
+		}},
+		// No hardware address, so the Address column is empty
+		{"utun0", &protocol.GuestNetworkInterfaceStat{TxPackets: 3, TxBytes: 324}},
+		{"en1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.iface, func(t *testing.T) {
+			got := getInterfaceStatistics(context.Background(), tt.iface)
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("statistics = %+v, want nil", got)
+			case tt.want != nil && (got == nil || *got != *tt.want):
+				t.Errorf("statistics = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
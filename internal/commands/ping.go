@@ -1,21 +1,24 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
+	"mac-guest-agent/protocol"
 )
 
 func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-ping",
 		Handler: handleGuestPing,
+		Returns: protocol.EmptyResponse{},
 		Enabled: true,
+		Class:   ExecHeartbeat,
 	})
 }
 
 // handleGuestPing handles the guest-ping command.
 // It's a no-op command that simply returns an empty object to confirm the
 // guest agent is alive and responding.
-func handleGuestPing(req json.RawMessage) (interface{}, error) {
+func handleGuestPing(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	return protocol.EmptyResponse{}, nil
 }
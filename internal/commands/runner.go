@@ -0,0 +1,242 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mac-guest-agent/internal/metrics"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Invocation describes an external program run on behalf of a command.
+type Invocation struct {
+	// Name is the program to run, looked up in PATH if it has no slash.
+	Name string
+
+	// Args are the arguments passed to the program, excluding Name.
+	Args []string
+
+	// Stdin is fed to the program's standard input when non-nil.
+	Stdin []byte
+
+	// Env replaces the program's environment when non-nil.
+	Env []string
+
+	// Dir is the working directory; empty means the agent's.
+	Dir string
+
+	// MergeOutput sends standard error to the Stdout buffer.
+	MergeOutput bool
+
+	// MaxOutput caps the bytes kept per output stream; zero means no limit.
+	// Output beyond the cap is read and discarded.
+	MaxOutput int
+
+	// Started, when non-nil, is called with the process ID once the
+	// program has started and before Run waits for it to exit. It is not
+	// called if the program could not be started.
+	Started func(pid int)
+}
+
+// Argv returns the full argument vector of the invocation.
+func (inv Invocation) Argv() []string {
+	return append([]string{inv.Name}, inv.Args...)
+}
+
+// Result is the outcome of a program that ran to completion.
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+
+	// Signal is the signal that terminated the program, or zero if it
+	// exited normally. ExitCode is -1 in that case.
+	Signal int
+
+	// StdoutTruncated and StderrTruncated report output dropped because
+	// of Invocation.MaxOutput.
+	StdoutTruncated bool
+	StderrTruncated bool
+}
+
+// Runner runs external programs. Handlers never start processes directly so
+// that the implementation can be swapped, e.g. for fixture replay.
+//
+// Run returns an error only when the program could not be run at all or ctx
+// expired; a program exiting with a non-zero status is reported through
+// Result.ExitCode. When ctx expires after the program has started, the
+// output captured until then is returned along with ctx's error.
+type Runner interface {
+	Run(ctx context.Context, inv Invocation) (*Result, error)
+}
+
+// ExitError reports a program that exited with a non-zero status.
+type ExitError struct {
+	Argv     []string
+	ExitCode int
+	Stderr   []byte
+}
+
+// Error implements the error interface.
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("%s exited with status %d", strings.Join(e.Argv, " "), e.ExitCode)
+	if stderr := strings.TrimSpace(string(e.Stderr)); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// killWaitDelay bounds how long Run waits for the output of a killed
+// program.
+const killWaitDelay = time.Second
+
+// ExecRunner runs programs with os/exec.
+type ExecRunner struct{}
+
+// Run implements Runner.
+func (ExecRunner) Run(ctx context.Context, inv Invocation) (*Result, error) {
+	cmd := exec.CommandContext(ctx, inv.Name, inv.Args...)
+	// Run the program in its own process group and kill the whole group
+	// when the deadline passes, so children left behind by scripts do not
+	// keep the output pipes open.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = killWaitDelay
+	cmd.Env = inv.Env
+	cmd.Dir = inv.Dir
+	if inv.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(inv.Stdin)
+	}
+
+	stdout := &limitedBuffer{limit: inv.MaxOutput}
+	stderr := &limitedBuffer{limit: inv.MaxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if inv.MergeOutput {
+		cmd.Stderr = stdout
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if inv.Started != nil {
+		inv.Started(cmd.Process.Pid)
+	}
+	err := cmd.Wait()
+
+	result := &Result{
+		Stdout:          stdout.buf.Bytes(),
+		Stderr:          stderr.buf.Bytes(),
+		StdoutTruncated: stdout.truncated,
+		StderrTruncated: stderr.truncated,
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// The process was killed because the deadline passed; report that
+		// rather than the resulting "signal: killed", with what it printed.
+		return result, ctxErr
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			result.Signal = int(status.Signal())
+		}
+	}
+	return result, nil
+}
+
+// limitedBuffer keeps at most limit bytes of what is written to it, or
+// everything when limit is zero.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// Write implements io.Writer. It never fails, so the writing process is not
+// blocked or killed by a full buffer.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit > 0 {
+		if room := b.limit - b.buf.Len(); len(p) > room {
+			p = p[:room]
+			b.truncated = true
+		}
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+var (
+	runner      Runner = ExecRunner{}
+	runnerMutex sync.RWMutex
+)
+
+// SetRunner replaces the runner used by all commands. A nil runner restores
+// ExecRunner.
+func SetRunner(r Runner) {
+	runnerMutex.Lock()
+	defer runnerMutex.Unlock()
+	if r == nil {
+		r = ExecRunner{}
+	}
+	runner = r
+}
+
+// CurrentRunner returns the runner used by all commands.
+func CurrentRunner() Runner {
+	runnerMutex.RLock()
+	defer runnerMutex.RUnlock()
+	return runner
+}
+
+// run runs inv with the current runner and converts a non-zero exit status
+// into an *ExitError. The result is returned in both cases. Failures are
+// counted in the process failure metric.
+func run(ctx context.Context, inv Invocation) (*Result, error) {
+	program := filepath.Base(inv.Name)
+	result, err := CurrentRunner().Run(ctx, inv)
+	if err != nil {
+		reason := "error"
+		if errors.Is(err, context.DeadlineExceeded) {
+			reason = "timeout"
+		}
+		metrics.ProcessFailures.Inc(program, reason)
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		metrics.ProcessFailures.Inc(program, "exit")
+		return result, &ExitError{
+			Argv:     inv.Argv(),
+			ExitCode: result.ExitCode,
+			Stderr:   result.Stderr,
+		}
+	}
+	return result, nil
+}
+
+// runOutput runs a program and returns its standard output.
+func runOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	result, err := run(ctx, Invocation{Name: name, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return result.Stdout, nil
+}
+
+// runCommand runs a program, discarding its output.
+func runCommand(ctx context.Context, name string, args ...string) error {
+	_, err := run(ctx, Invocation{Name: name, Args: args})
+	return err
+}
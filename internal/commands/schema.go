@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mac-guest-agent/protocol"
+	"sort"
+	"strings"
+)
+
+// ArgType is the JSON type of a command argument. Types may be combined with
+// | for arguments that accept alternates, such as guest-exec's
+// capture-output which is either a boolean or a string.
+type ArgType int
+
+const (
+	TypeString ArgType = 1 << iota
+	TypeInt
+	TypeNumber
+	TypeBool
+	TypeArray
+	TypeObject
+)
+
+// argTypeNames gives the names used in error descriptions, in the order
+// they are listed.
+var argTypeNames = []struct {
+	t    ArgType
+	name string
+}{
+	{TypeString, "string"},
+	{TypeInt, "integer"},
+	{TypeNumber, "number"},
+	{TypeBool, "boolean"},
+	{TypeArray, "array"},
+	{TypeObject, "object"},
+}
+
+// String returns the QMP name of the type, e.g. "boolean or string".
+func (t ArgType) String() string {
+	var names []string
+	for _, n := range argTypeNames {
+		if t&n.t != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+// Arg declares one argument of a command.
+type Arg struct {
+	// Name is the JSON member name.
+	Name string
+
+	// Type is the accepted JSON type or types.
+	Type ArgType
+
+	// Optional arguments may be omitted.
+	Optional bool
+
+	// Enum restricts a string argument to the listed values.
+	Enum []string
+
+	// Elem is the element type of an array argument.
+	Elem ArgType
+
+	// Sensitive arguments, such as passwords, are never written to logs.
+	Sensitive bool
+}
+
+// redactedValue replaces sensitive argument values in logs.
+const redactedValue = "[redacted]"
+
+// RedactArguments returns the arguments of a request to the named command
+// with the values of its sensitive arguments replaced, for logging. Other
+// values are returned unchanged. The arguments of unknown commands are
+// redacted as a whole since nothing is known about them.
+func RedactArguments(name string, args interface{}) interface{} {
+	if args == nil {
+		return nil
+	}
+	cmd, ok := CommandRegistry[name]
+	if !ok {
+		return redactedValue
+	}
+	var sensitive []string
+	for _, arg := range cmd.Args {
+		if arg.Sensitive {
+			sensitive = append(sensitive, arg.Name)
+		}
+	}
+	if len(sensitive) == 0 {
+		return args
+	}
+
+	var members map[string]interface{}
+	switch a := args.(type) {
+	case map[string]interface{}:
+		members = a
+	default:
+		data, err := json.Marshal(args)
+		if err != nil || json.Unmarshal(data, &members) != nil {
+			return redactedValue
+		}
+	}
+	redacted := make(map[string]interface{}, len(members))
+	for k, v := range members {
+		redacted[k] = v
+	}
+	for _, name := range sensitive {
+		if _, ok := redacted[name]; ok {
+			redacted[name] = redactedValue
+		}
+	}
+	return redacted
+}
+
+// validateArgs checks the raw arguments of a request against the command's
+// declared schema. Commands without a schema accept no arguments. The error
+// classes and descriptions follow the ones upstream QEMU reports.
+func validateArgs(cmd *Command, raw json.RawMessage) error {
+	var members map[string]json.RawMessage
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && !bytes.Equal(trimmed, []byte("null")) {
+		if trimmed[0] != '{' {
+			return NewError(protocol.ErrorClassGeneric, "QMP input member 'arguments' must be an object")
+		}
+		if err := json.Unmarshal(trimmed, &members); err != nil {
+			return NewError(protocol.ErrorClassGeneric, "QMP input member 'arguments' must be an object")
+		}
+	}
+
+	declared := make(map[string]bool, len(cmd.Args))
+	for _, arg := range cmd.Args {
+		declared[arg.Name] = true
+		value, ok := members[arg.Name]
+		if !ok {
+			if !arg.Optional {
+				return NewError(protocol.ErrorClassMissingParameter, "Parameter '%s' is missing", arg.Name)
+			}
+			continue
+		}
+		if err := arg.validate(arg.Name, value); err != nil {
+			return err
+		}
+	}
+
+	var unexpected []string
+	for name := range members {
+		if !declared[name] {
+			unexpected = append(unexpected, name)
+		}
+	}
+	if len(unexpected) > 0 {
+		// Report the same member every time.
+		sort.Strings(unexpected)
+		return NewError(protocol.ErrorClassInvalidParameter, "Invalid parameter '%s'", unexpected[0])
+	}
+	return nil
+}
+
+// validate checks a single argument value. path names the value in errors.
+func (a Arg) validate(path string, value json.RawMessage) error {
+	actual := jsonType(value)
+	if actual&a.Type == 0 {
+		// An integral number is acceptable where a float is expected.
+		if !(actual == TypeInt && a.Type&TypeNumber != 0) {
+			return NewError(protocol.ErrorClassInvalidParameterType,
+				"Invalid parameter type for '%s', expected: %s", path, a.Type)
+		}
+	}
+
+	switch actual {
+	case TypeString:
+		if len(a.Enum) == 0 {
+			return nil
+		}
+		var s string
+		json.Unmarshal(value, &s)
+		for _, allowed := range a.Enum {
+			if s == allowed {
+				return nil
+			}
+		}
+		return NewError(protocol.ErrorClassInvalidParameterValue,
+			"Parameter '%s' does not accept value '%s', expected one of: %s", path, s, strings.Join(a.Enum, ", "))
+
+	case TypeArray:
+		if a.Elem == 0 {
+			return nil
+		}
+		var elems []json.RawMessage
+		json.Unmarshal(value, &elems)
+		elem := Arg{Type: a.Elem}
+		for i, e := range elems {
+			if err := elem.validate(fmt.Sprintf("%s[%d]", path, i), e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonType returns the type of a JSON value. Numbers without a fraction or
+// exponent are reported as TypeInt.
+func jsonType(value json.RawMessage) ArgType {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+	switch value[0] {
+	case '"':
+		return TypeString
+	case 't', 'f':
+		return TypeBool
+	case '[':
+		return TypeArray
+	case '{':
+		return TypeObject
+	case 'n':
+		return 0
+	}
+	if bytes.ContainsAny(value, ".eE") {
+		return TypeNumber
+	}
+	return TypeInt
+}
@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"mac-guest-agent/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestValidateArgs(t *testing.T) {
+	cmd := &Command{
+		Name: "test-schema",
+		Args: []Arg{
+			{Name: "path", Type: TypeString},
+			{Name: "count", Type: TypeInt, Optional: true},
+			{Name: "ratio", Type: TypeNumber, Optional: true},
+			{Name: "mode", Type: TypeString, Optional: true, Enum: []string{"read", "write"}},
+			{Name: "arg", Type: TypeArray, Elem: TypeString, Optional: true},
+			{Name: "options", Type: TypeObject, Optional: true},
+			// Like guest-exec's capture-output
+			{Name: "capture", Type: TypeBool | TypeString, Optional: true,
+				Enum: []string{"none", "stdout", "merged"}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		args      string
+		wantClass string
+		wantDesc  string
+	}{
+		{"required only", `{"path":"/tmp"}`, "", ""},
+		{"all members", `{"path":"/tmp","count":3,"ratio":0.5,"mode":"write","arg":["-l"],"options":{},"capture":"merged"}`, "", ""},
+		{"integer as number", `{"path":"/tmp","ratio":2}`, "", ""},
+		{"empty array", `{"path":"/tmp","arg":[]}`, "", ""},
+
+		{"missing arguments", ``, protocol.ErrorClassMissingParameter, "Parameter 'path' is missing"},
+		{"null arguments", `null`, protocol.ErrorClassMissingParameter, "Parameter 'path' is missing"},
+		{"missing required", `{"count":1}`, protocol.ErrorClassMissingParameter, "Parameter 'path' is missing"},
+		{"not an object", `["/tmp"]`, protocol.ErrorClassGeneric, "QMP input member 'arguments' must be an object"},
+		{"unknown member", `{"path":"/tmp","zeta":1,"alpha":2}`, protocol.ErrorClassInvalidParameter, "Invalid parameter 'alpha'"},
+
+		{"string for integer", `{"path":"/tmp","count":"3"}`, protocol.ErrorClassInvalidParameterType, "Invalid parameter type for 'count', expected: integer"},
+		{"number for integer", `{"path":"/tmp","count":1.5}`, protocol.ErrorClassInvalidParameterType, "Invalid parameter type for 'count', expected: integer"},
+		{"null for string", `{"path":null}`, protocol.ErrorClassInvalidParameterType, "Invalid parameter type for 'path', expected: string"},
+		{"object for array", `{"path":"/tmp","arg":{}}`, protocol.ErrorClassInvalidParameterType, "Invalid parameter type for 'arg', expected: array"},
+		{"wrong element", `{"path":"/tmp","arg":["-l",2]}`, protocol.ErrorClassInvalidParameterType, "Invalid parameter type for 'arg[1]', expected: string"},
+		{"array for object", `{"path":"/tmp","options":[]}`, protocol.ErrorClassInvalidParameterType, "Invalid parameter type for 'options', expected: object"},
+
+		{"enum violation", `{"path":"/tmp","mode":"append"}`, protocol.ErrorClassInvalidParameterValue, "Parameter 'mode' does not accept value 'append', expected one of: read, write"},
+
+		{"union boolean", `{"path":"/tmp","capture":true}`, "", ""},
+		{"union string", `{"path":"/tmp","capture":"stdout"}`, "", ""},
+		{"union enum violation", `{"path":"/tmp","capture":"stdin"}`, protocol.ErrorClassInvalidParameterValue, "Parameter 'capture' does not accept value 'stdin', expected one of: none, stdout, merged"},
+		{"union wrong type", `{"path":"/tmp","capture":1}`, protocol.ErrorClassInvalidParameterType, "Invalid parameter type for 'capture', expected: string or boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateArgs(cmd, json.RawMessage(tt.args))
+			if tt.wantClass == "" {
+				if err != nil {
+					t.Fatalf("validateArgs(%s) = %v", tt.args, err)
+				}
+				return
+			}
+			var qmpErr *Error
+			if !errors.As(err, &qmpErr) {
+				t.Fatalf("validateArgs(%s) = %v, want a %s", tt.args, err, tt.wantClass)
+			}
+			if qmpErr.Class != tt.wantClass || qmpErr.Desc != tt.wantDesc {
+				t.Errorf("validateArgs(%s) = %s %q, want %s %q", tt.args, qmpErr.Class, qmpErr.Desc, tt.wantClass, tt.wantDesc)
+			}
+		})
+	}
+}
+
+func TestValidateArgsWithoutSchema(t *testing.T) {
+	cmd := &Command{Name: "test-no-args"}
+	for _, args := range []string{``, `null`, `{}`} {
+		if err := validateArgs(cmd, json.RawMessage(args)); err != nil {
+			t.Errorf("validateArgs(%s) = %v", args, err)
+		}
+	}
+	if err := validateArgs(cmd, json.RawMessage(`{"force":true}`)); err == nil {
+		t.Error("a command without arguments accepted one")
+	}
+}
+
+func TestRedactArguments(t *testing.T) {
+	registerTestCommand(t, &Command{
+		Name: "test-redact",
+		Args: []Arg{
+			{Name: "username", Type: TypeString},
+			{Name: "password", Type: TypeString, Sensitive: true},
+			{Name: "keys", Type: TypeArray, Elem: TypeString, Optional: true, Sensitive: true},
+		},
+	})
+	registerTestCommand(t, &Command{
+		Name: "test-plain",
+		Args: []Arg{{Name: "path", Type: TypeString}},
+	})
+
+	tests := []struct {
+		name    string
+		command string
+		args    interface{}
+		want    interface{}
+	}{
+		{
+			name:    "sensitive members",
+			command: "test-redact",
+			args:    map[string]interface{}{"username": "alice", "password": "secret", "keys": []string{"ssh-ed25519 AAAA"}},
+			want:    map[string]interface{}{"username": "alice", "password": redactedValue, "keys": redactedValue},
+		},
+		{
+			name:    "absent sensitive member",
+			command: "test-redact",
+			args:    map[string]interface{}{"username": "alice"},
+			want:    map[string]interface{}{"username": "alice"},
+		},
+		{
+			name:    "struct arguments",
+			command: "test-redact",
+			args:    protocol.GuestSetUserPasswordArgs{Username: "alice", Password: "c2VjcmV0"},
+			want:    map[string]interface{}{"username": "alice", "password": redactedValue, "crypted": false},
+		},
+		{
+			name:    "raw arguments",
+			command: "test-redact",
+			args:    json.RawMessage(`{"username":"alice","password":"secret"}`),
+			want:    map[string]interface{}{"username": "alice", "password": redactedValue},
+		},
+		{
+			name:    "not an object",
+			command: "test-redact",
+			args:    []string{"secret"},
+			want:    redactedValue,
+		},
+		{
+			name:    "nothing sensitive",
+			command: "test-plain",
+			args:    map[string]interface{}{"path": "/tmp"},
+			want:    map[string]interface{}{"path": "/tmp"},
+		},
+		{
+			name:    "unknown command",
+			command: "test-no-such-command",
+			args:    map[string]interface{}{"token": "secret"},
+			want:    redactedValue,
+		},
+		{
+			name:    "no arguments",
+			command: "test-redact",
+			args:    nil,
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactArguments(tt.command, tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RedactArguments() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	// The request itself is left untouched
+	args := map[string]interface{}{"username": "alice", "password": "secret"}
+	RedactArguments("test-redact", args)
+	if args["password"] != "secret" {
+		t.Error("RedactArguments modified the request's arguments")
+	}
+}
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mac-guest-agent/protocol"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// validUsername matches the short names of macOS accounts.
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,254}$`)
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "guest-set-user-password",
+		Handler: handleSetUserPassword,
+		Args: []Arg{
+			{Name: "username", Type: TypeString},
+			{Name: "password", Type: TypeString, Sensitive: true},
+			{Name: "crypted", Type: TypeBool},
+		},
+		Returns: protocol.EmptyResponse{},
+		Enabled: true,
+	})
+}
+
+// handleSetUserPassword handles the guest-set-user-password command. The
+// password is never logged; every attempt is recorded with its outcome.
+func handleSetUserPassword(ctx context.Context, req json.RawMessage) (interface{}, error) {
+	var args protocol.GuestSetUserPasswordArgs
+	if err := json.Unmarshal(req, &args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments: %v", err)
+	}
+
+	backend := currentAccountBackend()
+	log := logrus.WithFields(logrus.Fields{
+		"username": args.Username,
+		"crypted":  args.Crypted,
+		"backend":  backend.Name(),
+	})
+
+	if err := setUserPassword(ctx, backend, args); err != nil {
+		log.WithError(err).Warn("Password change failed")
+		return nil, err
+	}
+	log.Info("Password changed")
+	return protocol.EmptyResponse{}, nil
+}
+
+// setUserPassword validates the arguments and sets the password through
+// backend.
+func setUserPassword(ctx context.Context, backend AccountBackend, args protocol.GuestSetUserPasswordArgs) error {
+	if !validUsername.MatchString(args.Username) {
+		return NewError(protocol.ErrorClassInvalidParameterValue,
+			"Parameter 'username' does not accept value '%s'", args.Username)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(args.Password)
+	if err != nil {
+		return NewError(protocol.ErrorClassInvalidParameterValue,
+			"Parameter 'password' is not valid base64: %v", err)
+	}
+	password := string(raw)
+	if password == "" {
+		return NewError(protocol.ErrorClassInvalidParameterValue,
+			"Parameter 'password' must not be empty")
+	}
+	if strings.ContainsAny(password, "\x00\r\n") {
+		return fmt.Errorf("forbidden characters in raw password")
+	}
+
+	exists, err := backend.UserExists(ctx, args.Username)
+	if err != nil {
+		return fmt.Errorf("failed to look up user %s: %v", args.Username, err)
+	}
+	if !exists {
+		return NewError(protocol.ErrorClassInvalidParameterValue,
+			"Parameter 'username' does not accept value '%s', no such user", args.Username)
+	}
+
+	return backend.SetPassword(ctx, args.Username, password, args.Crypted)
+}
@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mac-guest-agent/protocol"
+	"testing"
+)
+
+// fakeAccounts is an AccountBackend that keeps passwords in memory.
+type fakeAccounts struct {
+	passwords map[string]string
+}
+
+func (f *fakeAccounts) Name() string { return "fake" }
+
+func (f *fakeAccounts) UserExists(ctx context.Context, username string) (bool, error) {
+	_, ok := f.passwords[username]
+	return ok, nil
+}
+
+func (f *fakeAccounts) SetPassword(ctx context.Context, username, password string, crypted bool) error {
+	if crypted {
+		// Same answer as the directory service
+		return DsclBackend{}.SetPassword(ctx, username, password, crypted)
+	}
+	f.passwords[username] = password
+	return nil
+}
+
+// useAccounts replaces the account backend for the duration of a test.
+func useAccounts(t *testing.T, b AccountBackend) {
+	t.Helper()
+	SetAccountBackend(b)
+	t.Cleanup(func() { SetAccountBackend(nil) })
+}
+
+func TestSetUserPassword(t *testing.T) {
+	encode := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		name      string
+		args      protocol.GuestSetUserPasswordArgs
+		wantClass string
+		wantSet   string
+	}{
+		{
+			name:    "changes the password",
+			args:    protocol.GuestSetUserPasswordArgs{Username: "admin", Password: encode([]byte("n3w pass"))},
+			wantSet: "n3w pass",
+		},
+		{
+			name:      "bad username",
+			args:      protocol.GuestSetUserPasswordArgs{Username: "../admin", Password: encode([]byte("secret"))},
+			wantClass: protocol.ErrorClassInvalidParameterValue,
+		},
+		{
+			name:      "bad base64",
+			args:      protocol.GuestSetUserPasswordArgs{Username: "admin", Password: "not base64!"},
+			wantClass: protocol.ErrorClassInvalidParameterValue,
+		},
+		{
+			name:      "empty password",
+			args:      protocol.GuestSetUserPasswordArgs{Username: "admin", Password: ""},
+			wantClass: protocol.ErrorClassInvalidParameterValue,
+		},
+		{
+			name:      "newline in password",
+			args:      protocol.GuestSetUserPasswordArgs{Username: "admin", Password: encode([]byte("a\nb"))},
+			wantClass: protocol.ErrorClassGeneric,
+		},
+		{
+			name:      "crypted",
+			args:      protocol.GuestSetUserPasswordArgs{Username: "admin", Password: encode([]byte("$6$salt$hash")), Crypted: true},
+			wantClass: protocol.ErrorClassUnsupported,
+		},
+		{
+			name:      "unknown user",
+			args:      protocol.GuestSetUserPasswordArgs{Username: "nobody2", Password: encode([]byte("secret"))},
+			wantClass: protocol.ErrorClassInvalidParameterValue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := &fakeAccounts{passwords: map[string]string{"admin": "old"}}
+			useAccounts(t, accounts)
+			useRunner(t, NewFixtureRunner(nil))
+
+			req, _ := json.Marshal(tt.args)
+			_, err := handleSetUserPassword(context.Background(), req)
+
+			if tt.wantClass == "" {
+				if err != nil {
+					t.Fatalf("handleSetUserPassword() error = %v", err)
+				}
+			} else if class := errorClass(err); class != tt.wantClass {
+				t.Fatalf("handleSetUserPassword() error = %v (%s), want %s", err, class, tt.wantClass)
+			}
+
+			want := tt.wantSet
+			if want == "" {
+				want = "old"
+			}
+			if got := accounts.passwords["admin"]; got != want {
+				t.Errorf("password = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// errorClass returns the QMP class an error is reported with.
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var cmdErr *Error
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Class
+	}
+	return protocol.ErrorClassGeneric
+}
@@ -4,8 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"mac-guest-agent/internal/protocol"
-	"os/exec"
+	"mac-guest-agent/protocol"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +12,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// forceCommandTimeout bounds the `shutdown` fallback commands.
+const forceCommandTimeout = 5 * time.Second
+
 // ShutdownArgs defines the arguments for the guest-shutdown command.
 type ShutdownArgs struct {
 	Mode string `json:"mode"`
@@ -22,12 +24,16 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-shutdown",
 		Handler: handleGuestShutdown,
+		Returns: protocol.EmptyResponse{},
+		Args: []Arg{
+			{Name: "mode", Type: TypeString, Optional: true, Enum: []string{"powerdown", "halt", "reboot"}},
+		},
 		Enabled: true,
 	})
 }
 
 // handleGuestShutdown handles the guest-shutdown command.
-func handleGuestShutdown(req json.RawMessage) (interface{}, error) {
+func handleGuestShutdown(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	args := ShutdownArgs{Mode: "powerdown"} // Default mode
 	if len(req) > 0 && string(req) != "null" {
 		if err := json.Unmarshal(req, &args); err != nil {
@@ -68,10 +74,10 @@ func executePowerDown() {
 	gracefulDone := make(chan bool, 1)
 	go func() {
 		// Clean up application states and restore settings.
-		clearAllApplicationStates()
+		clearAllApplicationStates(ctx)
 
 		// Use the fastest shutdown method.
-		performImmediateShutdown()
+		performImmediateShutdown(ctx)
 		gracefulDone <- true
 	}()
 
@@ -106,10 +112,10 @@ func executeReboot() {
 	gracefulDone := make(chan bool, 1)
 	go func() {
 		// Clean up application states and restore settings.
-		clearAllApplicationStates()
+		clearAllApplicationStates(ctx)
 
 		// Use the fastest reboot method.
-		performImmediateReboot()
+		performImmediateReboot(ctx)
 		gracefulDone <- true
 	}()
 
@@ -133,26 +139,26 @@ func executeReboot() {
 }
 
 // clearAllApplicationStates cleans up application states and restores settings.
-func clearAllApplicationStates() {
+func clearAllApplicationStates(ctx context.Context) {
 	logrus.Info("Starting graceful application state cleanup...")
 
 	// 1. Disable system resume features immediately.
-	disableAllResumeFeatures()
+	disableAllResumeFeatures(ctx)
 
 	// 2. Gracefully close user applications, allowing them to save.
-	gracefullyCloseUserApplications()
+	gracefullyCloseUserApplications(ctx)
 
 	// 3. Clean all saved state files.
-	removeAllSavedStates()
+	removeAllSavedStates(ctx)
 
 	// 4. Clean system resume-related files.
-	clearSystemResumeFiles()
+	clearSystemResumeFiles(ctx)
 
 	logrus.Info("Application state cleanup completed")
 }
 
 // disableAllResumeFeatures disables all resume features.
-func disableAllResumeFeatures() {
+func disableAllResumeFeatures(ctx context.Context) {
 	logrus.Info("Disabling all resume features...")
 
 	// System-level settings
@@ -165,8 +171,7 @@ func disableAllResumeFeatures() {
 	}
 
 	for _, cmdArgs := range systemCommands {
-		cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-		err := cmd.Run()
+		err := runCommand(ctx, cmdArgs[0], cmdArgs[1:]...)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"command": cmdArgs,
@@ -176,15 +181,15 @@ func disableAllResumeFeatures() {
 	}
 
 	// Immediately sync settings to disk.
-	exec.Command("sync").Run()
+	runCommand(ctx, "sync")
 }
 
 // gracefullyCloseUserApplications gracefully closes user applications, allowing them to save.
-func gracefullyCloseUserApplications() {
+func gracefullyCloseUserApplications(ctx context.Context) {
 	logrus.Info("Gracefully closing user applications with concurrent processing...")
 
 	// Get the list of all running applications.
-	appList := getRunningApplications()
+	appList := getRunningApplications(ctx)
 	if len(appList) == 0 {
 		logrus.Info("No user applications to close")
 		return
@@ -205,9 +210,9 @@ func gracefullyCloseUserApplications() {
 		go func(app string) {
 			defer wg.Done()
 			if app == "Finder" {
-				closeFinderConcurrently(app)
+				closeFinderConcurrently(ctx, app)
 			} else {
-				closeApplicationConcurrently(app)
+				closeApplicationConcurrently(ctx, app)
 			}
 		}(appName)
 	}
@@ -229,7 +234,7 @@ func gracefullyCloseUserApplications() {
 }
 
 // removeAllSavedStates removes all saved application state files.
-func removeAllSavedStates() {
+func removeAllSavedStates(ctx context.Context) {
 	logrus.Info("Removing all saved application states...")
 
 	// List of cleanup commands.
@@ -243,8 +248,7 @@ func removeAllSavedStates() {
 	}
 
 	for _, cmdStr := range cleanupCommands {
-		cmd := exec.Command("sh", "-c", cmdStr)
-		err := cmd.Run()
+		err := runCommand(ctx, "sh", "-c", cmdStr)
 		if err != nil {
 			logrus.WithField("command", cmdStr).WithError(err).Warn("Cleanup command failed")
 		}
@@ -252,21 +256,19 @@ func removeAllSavedStates() {
 }
 
 // clearSystemResumeFiles clears system resume-related files.
-func clearSystemResumeFiles() {
+func clearSystemResumeFiles(ctx context.Context) {
 	logrus.Info("Clearing system resume files...")
-	cmd := exec.Command("rm", "-rf", "/var/vm/sleepimage")
-	err := cmd.Run()
+	err := runCommand(ctx, "rm", "-rf", "/var/vm/sleepimage")
 	if err != nil {
 		logrus.WithError(err).Warn("Failed to remove sleepimage")
 	}
 }
 
 // performImmediateShutdown performs an immediate shutdown.
-func performImmediateShutdown() {
+func performImmediateShutdown(ctx context.Context) {
 	logrus.Info("Performing immediate shutdown via osascript...")
 	script := "tell app \"System Events\" to shut down"
-	cmd := exec.Command("osascript", "-e", script)
-	err := cmd.Run()
+	err := runCommand(ctx, "osascript", "-e", script)
 	if err != nil {
 		logrus.WithError(err).Error("osascript shutdown failed, trying fallback")
 		performForceShutdown()
@@ -274,42 +276,44 @@ func performImmediateShutdown() {
 }
 
 // performImmediateReboot performs an immediate reboot.
-func performImmediateReboot() {
+func performImmediateReboot(ctx context.Context) {
 	logrus.Info("Performing immediate reboot via osascript...")
 	script := "tell app \"System Events\" to restart"
-	cmd := exec.Command("osascript", "-e", script)
-	err := cmd.Run()
+	err := runCommand(ctx, "osascript", "-e", script)
 	if err != nil {
 		logrus.WithError(err).Error("osascript reboot failed, trying fallback")
 		performForceReboot()
 	}
 }
 
-// performForceShutdown performs a forced shutdown.
+// performForceShutdown performs a forced shutdown. It uses its own context
+// because it is also the fallback once the graceful path has timed out.
 func performForceShutdown() {
 	logrus.Warning("Performing force shutdown via 'shutdown -h now'...")
-	cmd := exec.Command("shutdown", "-h", "now")
-	err := cmd.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), forceCommandTimeout)
+	defer cancel()
+	err := runCommand(ctx, "shutdown", "-h", "now")
 	if err != nil {
 		logrus.WithError(err).Error("Force shutdown command failed")
 	}
 }
 
-// performForceReboot performs a forced reboot.
+// performForceReboot performs a forced reboot. It uses its own context
+// because it is also the fallback once the graceful path has timed out.
 func performForceReboot() {
 	logrus.Warning("Performing force reboot via 'shutdown -r now'...")
-	cmd := exec.Command("shutdown", "-r", "now")
-	err := cmd.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), forceCommandTimeout)
+	defer cancel()
+	err := runCommand(ctx, "shutdown", "-r", "now")
 	if err != nil {
 		logrus.WithError(err).Error("Force reboot command failed")
 	}
 }
 
 // getRunningApplications gets the list of running applications.
-func getRunningApplications() []string {
+func getRunningApplications(ctx context.Context) []string {
 	script := "tell application \"System Events\" to get name of every process whose background only is false"
-	cmd := exec.Command("osascript", "-e", script)
-	out, err := cmd.Output()
+	out, err := runOutput(ctx, "osascript", "-e", script)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get running applications")
 		return nil
@@ -322,78 +326,70 @@ func getRunningApplications() []string {
 }
 
 // closeApplicationConcurrently closes an application concurrently.
-func closeApplicationConcurrently(appName string) {
+func closeApplicationConcurrently(ctx context.Context, appName string) {
 	logrus.WithField("app", appName).Info("Attempting to close application")
-	if !gracefulQuitApplication(appName) {
+	if !gracefulQuitApplication(ctx, appName) {
 		logrus.WithField("app", appName).Warning("Graceful quit failed, forcing quit")
-		if !forceQuitApplication(appName) {
+		if !forceQuitApplication(ctx, appName) {
 			logrus.WithField("app", appName).Error("Force quit failed, killing process")
-			killApplication(appName)
+			killApplication(ctx, appName)
 		}
 	}
 }
 
 // gracefulQuitApplication gracefully quits an application.
-func gracefulQuitApplication(appName string) bool {
+func gracefulQuitApplication(ctx context.Context, appName string) bool {
 	script := fmt.Sprintf("quit app \"%s\"", appName)
-	cmd := exec.Command("osascript", "-e", script)
-	err := cmd.Run()
+	err := runCommand(ctx, "osascript", "-e", script)
 	return err == nil
 }
 
 // forceQuitApplication forcefully quits an application.
-func forceQuitApplication(appName string) bool {
+func forceQuitApplication(ctx context.Context, appName string) bool {
 	script := fmt.Sprintf("tell application \"System Events\" to unix id of process \"%s\"", appName)
-	cmd := exec.Command("osascript", "-e", script)
-	pid, err := cmd.Output()
+	pid, err := runOutput(ctx, "osascript", "-e", script)
 	if err != nil {
 		return false
 	}
 	pidStr := strings.TrimSpace(string(pid))
-	killCmd := exec.Command("kill", "-9", pidStr)
-	return killCmd.Run() == nil
+	return runCommand(ctx, "kill", "-9", pidStr) == nil
 }
 
 // killApplication kills an application process.
-func killApplication(appName string) {
-	killCmd := exec.Command("killall", appName)
-	killCmd.Run()
+func killApplication(ctx context.Context, appName string) {
+	runCommand(ctx, "killall", appName)
 }
 
 // closeFinderConcurrently closes the Finder application concurrently.
-func closeFinderConcurrently(appName string) {
+func closeFinderConcurrently(ctx context.Context, appName string) {
 	logrus.Info("Attempting to close Finder")
-	if !gracefulQuitFinder() {
+	if !gracefulQuitFinder(ctx) {
 		logrus.Warning("Graceful quit for Finder failed, forcing quit")
-		if !forceQuitFinder() {
+		if !forceQuitFinder(ctx) {
 			logrus.Error("Force quit for Finder failed, killing process")
-			killFinder()
+			killFinder(ctx)
 		}
 	}
 }
 
 // gracefulQuitFinder gracefully quits the Finder.
-func gracefulQuitFinder() bool {
+func gracefulQuitFinder(ctx context.Context) bool {
 	script := "tell application \"Finder\" to quit"
-	cmd := exec.Command("osascript", "-e", script)
-	return cmd.Run() == nil
+	return runCommand(ctx, "osascript", "-e", script) == nil
 }
 
 // forceQuitFinder forcefully quits the Finder.
-func forceQuitFinder() bool {
+func forceQuitFinder(ctx context.Context) bool {
 	script := "tell application \"System Events\" to unix id of process \"Finder\""
-	cmd := exec.Command("osascript", "-e", script)
-	pid, err := cmd.Output()
+	pid, err := runOutput(ctx, "osascript", "-e", script)
 	if err != nil {
 		return false
 	}
 	pidStr := strings.TrimSpace(string(pid))
-	killCmd := exec.Command("kill", "-9", pidStr)
-	return killCmd.Run() == nil
+	return runCommand(ctx, "kill", "-9", pidStr) == nil
 }
 
 // killFinder kills the Finder process.
-func killFinder() {
-	killCmd := exec.Command("killall", "Finder")
-	killCmd.Run()
+func killFinder(ctx context.Context) {
+	runCommand(ctx, "killall", "Finder")
 }
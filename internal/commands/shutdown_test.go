@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// watchRunner replays fixtures and reports the argv of every program it has
+// run, so tests can follow the work guest-shutdown does in the background.
+type watchRunner struct {
+	*FixtureRunner
+	ran chan string
+}
+
+func (r watchRunner) Run(ctx context.Context, inv Invocation) (*Result, error) {
+	result, err := r.FixtureRunner.Run(ctx, inv)
+	r.ran <- strings.Join(inv.Argv(), " ")
+	return result, err
+}
+
+// watchShutdown replays testdata/shutdown.json for the duration of a test.
+func watchShutdown(t *testing.T) watchRunner {
+	t.Helper()
+	fixtures, err := LoadFixtureRunner("testdata/shutdown.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := watchRunner{FixtureRunner: fixtures, ran: make(chan string, 100)}
+	useRunner(t, r)
+	return r
+}
+
+// waitFor collects the programs run until last has run.
+func (r watchRunner) waitFor(t *testing.T, last string) map[string]bool {
+	t.Helper()
+	ran := make(map[string]bool)
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case argv := <-r.ran:
+			ran[argv] = true
+			if argv == last {
+				return ran
+			}
+		case <-timeout:
+			t.Fatalf("%q was not run; ran %v", last, ran)
+		}
+	}
+}
+
+func TestShutdownPowerdown(t *testing.T) {
+	r := watchShutdown(t)
+
+	result, err := handleGuestShutdown(context.Background(), nil)
+	if err != nil || result == nil {
+		t.Fatalf("guest-shutdown = %v, %v", result, err)
+	}
+
+	ran := r.waitFor(t, `osascript -e tell app "System Events" to shut down`)
+	for _, argv := range []string{
+		"defaults write -g NSQuitAlwaysKeepsWindows -bool false",
+		`osascript -e tell application "Finder" to quit`,
+		`osascript -e quit app "Safari"`,
+		// Terminal refuses to quit and is killed
+		"kill -9 812",
+		"rm -rf /var/vm/sleepimage",
+	} {
+		if !ran[argv] {
+			t.Errorf("%q was not run", argv)
+		}
+	}
+	if ran["shutdown -h now"] {
+		t.Error("fell back to shutdown -h now although osascript succeeded")
+	}
+}
+
+func TestShutdownRebootFallsBack(t *testing.T) {
+	r := watchShutdown(t)
+
+	if _, err := handleGuestShutdown(context.Background(), []byte(`{"mode":"reboot"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fixture makes the osascript restart fail
+	ran := r.waitFor(t, "shutdown -r now")
+	if !ran[`osascript -e tell app "System Events" to restart`] {
+		t.Error("reboot did not try osascript first")
+	}
+}
+
+func TestShutdownRejectsUnknownMode(t *testing.T) {
+	cmd, _ := LookupCommand("guest-shutdown")
+	if err := validateArgs(cmd, []byte(`{"mode":"hibernate"}`)); err == nil {
+		t.Error("guest-shutdown accepted mode \"hibernate\"")
+	}
+}
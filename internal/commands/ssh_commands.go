@@ -1,198 +1,433 @@
 package commands
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"mac-guest-agent/internal/protocol"
+	"mac-guest-agent/protocol"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
+const (
+	// authorizedKeysName is the key file in the user's ~/.ssh.
+	authorizedKeysName = "authorized_keys"
+
+	// authorizedKeysMaxSize bounds the key file the agent reads.
+	authorizedKeysMaxSize = 1 << 20
+)
+
+// sshKeyTypes are the public key algorithms accepted in authorized_keys.
+var sshKeyTypes = map[string]bool{
+	"ssh-rsa":                            true,
+	"ssh-dss":                            true,
+	"ssh-ed25519":                        true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// errSymlink reports a ~/.ssh or authorized_keys that is a symbolic link.
+var errSymlink = errors.New("refusing to follow a symbolic link")
+
+// lookupUser looks up an account. Tests replace it to give a user a
+// temporary home directory.
+var lookupUser = user.Lookup
+
 func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-ssh-get-authorized-keys",
 		Handler: handleSSHGetAuthorizedKeys,
+		Returns: protocol.GuestSSHInfo{},
+		Args:    []Arg{{Name: "username", Type: TypeString}},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-ssh-add-authorized-keys",
 		Handler: handleSSHAddAuthorizedKeys,
+		Returns: protocol.EmptyResponse{},
+		Args: []Arg{
+			{Name: "username", Type: TypeString},
+			{Name: "keys", Type: TypeArray, Elem: TypeString, Sensitive: true},
+			{Name: "reset", Type: TypeBool, Optional: true},
+		},
 		Enabled: true,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-ssh-remove-authorized-keys",
 		Handler: handleSSHRemoveAuthorizedKeys,
+		Returns: protocol.EmptyResponse{},
+		Args: []Arg{
+			{Name: "username", Type: TypeString},
+			{Name: "keys", Type: TypeArray, Elem: TypeString, Sensitive: true},
+		},
 		Enabled: true,
 	})
 }
 
 // handleSSHGetAuthorizedKeys handles the guest-ssh-get-authorized-keys command.
-func handleSSHGetAuthorizedKeys(req json.RawMessage) (interface{}, error) {
+func handleSSHGetAuthorizedKeys(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	var args protocol.GuestSSHGetKeysArgs
 	if err := json.Unmarshal(req, &args); err != nil {
 		return nil, fmt.Errorf("failed to parse arguments: %v", err)
 	}
 
-	logrus.WithField("username", args.Username).Info("SSH get authorized keys requested")
-
-	// 由于安全原因，macOS版本的guest-agent不支持SSH密钥管理
-	// 返回一个安全错误，而不是实际执行操作
-	return nil, fmt.Errorf("SSH key management is not supported in macOS Guest Agent for security reasons")
+	keys, err := getAuthorizedKeys(args.Username)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.GuestSSHInfo{Keys: keys}, nil
 }
 
 // handleSSHAddAuthorizedKeys handles the guest-ssh-add-authorized-keys command.
-func handleSSHAddAuthorizedKeys(req json.RawMessage) (interface{}, error) {
+// Keys already present are not added again; with reset the file is replaced
+// by the given keys.
+func handleSSHAddAuthorizedKeys(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	var args protocol.GuestSSHAddKeysArgs
 	if err := json.Unmarshal(req, &args); err != nil {
 		return nil, fmt.Errorf("failed to parse arguments: %v", err)
 	}
 
+	if err := addAuthorizedKeys(args.Username, args.Keys, args.Reset); err != nil {
+		return nil, err
+	}
 	logrus.WithFields(logrus.Fields{
 		"username":  args.Username,
 		"key_count": len(args.Keys),
-	}).Info("SSH add authorized keys requested")
-
-	// 由于安全原因，macOS版本的guest-agent不支持SSH密钥管理
-	// 返回一个安全错误，而不是实际执行操作
-	return nil, fmt.Errorf("SSH key management is not supported in macOS Guest Agent for security reasons")
+		"reset":     args.Reset,
+	}).Info("SSH authorized keys added")
+	return protocol.EmptyResponse{}, nil
 }
 
 // handleSSHRemoveAuthorizedKeys handles the guest-ssh-remove-authorized-keys command.
-func handleSSHRemoveAuthorizedKeys(req json.RawMessage) (interface{}, error) {
+func handleSSHRemoveAuthorizedKeys(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	var args protocol.GuestSSHRemoveKeysArgs
 	if err := json.Unmarshal(req, &args); err != nil {
 		return nil, fmt.Errorf("failed to parse arguments: %v", err)
 	}
 
+	if err := removeAuthorizedKeys(args.Username, args.Keys); err != nil {
+		return nil, err
+	}
 	logrus.WithFields(logrus.Fields{
 		"username":  args.Username,
 		"key_count": len(args.Keys),
-	}).Info("SSH remove authorized keys requested")
+	}).Info("SSH authorized keys removed")
+	return protocol.EmptyResponse{}, nil
+}
 
-	// 由于安全原因，macOS版本的guest-agent不支持SSH密钥管理
-	// 返回一个安全错误，而不是实际执行操作
-	return nil, fmt.Errorf("SSH key management is not supported in macOS Guest Agent for security reasons")
+// sshUser is the account whose authorized_keys is managed.
+type sshUser struct {
+	Name    string
+	UID     int
+	GID     int
+	HomeDir string
 }
 
-// 以下是实际实现SSH密钥管理的函数，但在macOS版本中不会被调用
-// 保留这些代码是为了未来可能的功能扩展
+// sshDir returns the user's ~/.ssh.
+func (u *sshUser) sshDir() string {
+	return filepath.Join(u.HomeDir, ".ssh")
+}
 
-// getAuthorizedKeysPath returns the path to the authorized_keys file for a user.
-func getAuthorizedKeysPath(username string) (string, error) {
-	u, err := user.Lookup(username)
+// lookupSSHUser returns the account of a user.
+func lookupSSHUser(username string) (*sshUser, error) {
+	u, err := lookupUser(username)
 	if err != nil {
-		return "", fmt.Errorf("user not found: %v", err)
+		return nil, fmt.Errorf("user not found: %v", err)
 	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q for user %s", u.Uid, username)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q for user %s", u.Gid, username)
+	}
+	if u.HomeDir == "" || !filepath.IsAbs(u.HomeDir) {
+		return nil, fmt.Errorf("user %s has no home directory", username)
+	}
+	return &sshUser{Name: username, UID: uid, GID: gid, HomeDir: u.HomeDir}, nil
+}
 
-	sshDir := filepath.Join(u.HomeDir, ".ssh")
-	return filepath.Join(sshDir, "authorized_keys"), nil
+// validateSSHKey checks that key is a single OpenSSH public key line,
+// optionally preceded by options, whose blob matches its declared type.
+func validateSSHKey(key string) error {
+	invalid := fmt.Errorf("invalid OpenSSH public key: '%s'", key)
+	if strings.ContainsAny(key, "\r\n") {
+		return invalid
+	}
+	fields := strings.Fields(key)
+	for i, field := range fields {
+		keyType := strings.TrimSuffix(field, "-cert-v01@openssh.com")
+		if !sshKeyTypes[keyType] {
+			continue
+		}
+		if i+1 >= len(fields) {
+			return invalid
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil || len(blob) < 4 {
+			return invalid
+		}
+		n := binary.BigEndian.Uint32(blob)
+		if uint64(n) > uint64(len(blob)-4) || string(blob[4:4+n]) != field {
+			return invalid
+		}
+		return nil
+	}
+	return invalid
 }
 
-// getAuthorizedKeys reads the authorized_keys file for a user.
+// getAuthorizedKeys returns the keys in a user's authorized_keys, skipping
+// comments and blank lines.
 func getAuthorizedKeys(username string) ([]string, error) {
-	path, err := getAuthorizedKeysPath(username)
+	u, err := lookupSSHUser(username)
 	if err != nil {
 		return nil, err
 	}
-
-	data, err := os.ReadFile(path)
+	dir, err := openSSHDir(u, false)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []string{}, nil
 		}
 		return nil, err
 	}
+	defer unix.Close(dir)
 
-	lines := []string{}
-	for _, line := range strings.Split(string(data), "\n") {
+	lines, err := readAuthorizedKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if line != "" && !strings.HasPrefix(line, "#") {
-			lines = append(lines, line)
+			keys = append(keys, line)
 		}
 	}
-
-	return lines, nil
+	return keys, nil
 }
 
-// addAuthorizedKeys adds keys to the authorized_keys file for a user.
-func addAuthorizedKeys(username string, keys []string) error {
-	path, err := getAuthorizedKeysPath(username)
+// addAuthorizedKeys adds keys to a user's authorized_keys, creating ~/.ssh
+// if needed. Existing lines, including comments, are kept unless reset is
+// set.
+func addAuthorizedKeys(username string, keys []string, reset bool) error {
+	for _, key := range keys {
+		if err := validateSSHKey(key); err != nil {
+			return err
+		}
+	}
+	u, err := lookupSSHUser(username)
 	if err != nil {
 		return err
 	}
-
-	// Create .ssh directory if it doesn't exist
-	sshDir := filepath.Dir(path)
-	if err := os.MkdirAll(sshDir, 0700); err != nil {
+	dir, err := openSSHDir(u, true)
+	if err != nil {
 		return err
 	}
+	defer unix.Close(dir)
 
-	// Read existing keys
-	existingKeys, err := getAuthorizedKeys(username)
-	if err != nil && !os.IsNotExist(err) {
-		return err
+	var lines []string
+	if !reset {
+		if lines, err = readAuthorizedKeys(dir); err != nil {
+			return err
+		}
 	}
 
-	// Add new keys, avoiding duplicates
-	keyMap := make(map[string]bool)
-	for _, key := range existingKeys {
-		keyMap[key] = true
+	present := make(map[string]bool, len(lines)+len(keys))
+	for _, line := range lines {
+		present[strings.TrimSpace(line)] = true
 	}
-
-	var newKeys []string
 	for _, key := range keys {
-		if !keyMap[key] {
-			newKeys = append(newKeys, key)
-			keyMap[key] = true
+		key = strings.TrimSpace(key)
+		if !present[key] {
+			present[key] = true
+			lines = append(lines, key)
 		}
 	}
-
-	// Combine all keys
-	allKeys := append(existingKeys, newKeys...)
-
-	// Write back to file
-	data := []byte(strings.Join(allKeys, "\n") + "\n")
-	return os.WriteFile(path, data, 0600)
+	return writeAuthorizedKeys(u, dir, lines)
 }
 
-// removeAuthorizedKeys removes keys from the authorized_keys file for a user.
+// removeAuthorizedKeys removes keys from a user's authorized_keys. A missing
+// file has nothing to remove.
 func removeAuthorizedKeys(username string, keys []string) error {
-	path, err := getAuthorizedKeysPath(username)
+	for _, key := range keys {
+		if err := validateSSHKey(key); err != nil {
+			return err
+		}
+	}
+	u, err := lookupSSHUser(username)
 	if err != nil {
 		return err
 	}
-
-	// Read existing keys
-	existingKeys, err := getAuthorizedKeys(username)
+	dir, err := openSSHDir(u, false)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		}
 		return err
 	}
+	defer unix.Close(dir)
 
-	// Create a map of keys to remove
-	removeMap := make(map[string]bool)
+	lines, err := readAuthorizedKeys(dir)
+	if err != nil {
+		return err
+	}
+	remove := make(map[string]bool, len(keys))
 	for _, key := range keys {
-		removeMap[key] = true
+		remove[strings.TrimSpace(key)] = true
+	}
+	var remaining []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !remove[trimmed] {
+			remaining = append(remaining, line)
+		}
+	}
+	return writeAuthorizedKeys(u, dir, remaining)
+}
+
+// openSSHDir opens the user's ~/.ssh without following a symbolic link, so
+// a user cannot redirect the agent's writes outside the home directory.
+// With create a missing directory is made with mode 0700 and owned by the
+// user. The descriptor anchors all further access to the directory.
+func openSSHDir(u *sshUser, create bool) (int, error) {
+	path := u.sshDir()
+	created := false
+	if create {
+		err := unix.Mkdir(path, 0700)
+		if err != nil && err != unix.EEXIST {
+			return -1, fmt.Errorf("failed to create %s: %v", path, err)
+		}
+		created = err == nil
+	}
+
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	switch err {
+	case nil:
+	case unix.ENOENT:
+		return -1, os.ErrNotExist
+	case unix.ELOOP, unix.ENOTDIR:
+		// Linux reports a symbolic link opened with O_DIRECTORY as
+		// ENOTDIR rather than ELOOP.
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return -1, fmt.Errorf("%s: %v", path, errSymlink)
+		}
+		return -1, fmt.Errorf("%s is not a directory", path)
+	default:
+		return -1, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+
+	if created && os.Geteuid() == 0 {
+		if err := unix.Fchown(fd, u.UID, u.GID); err != nil {
+			unix.Close(fd)
+			return -1, fmt.Errorf("failed to change owner of %s: %v", path, err)
+		}
 	}
+	return fd, nil
+}
 
-	// Filter out keys to remove
-	var remainingKeys []string
-	for _, key := range existingKeys {
-		if !removeMap[key] {
-			remainingKeys = append(remainingKeys, key)
+// readAuthorizedKeys returns the lines of authorized_keys in dir, or none if
+// it does not exist.
+func readAuthorizedKeys(dir int) ([]string, error) {
+	fd, err := unix.Openat(dir, authorizedKeysName, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if err == unix.ENOENT {
+			return nil, nil
 		}
+		if err == unix.ELOOP {
+			return nil, fmt.Errorf("%s: %v", authorizedKeysName, errSymlink)
+		}
+		return nil, fmt.Errorf("failed to open %s: %v", authorizedKeysName, err)
+	}
+	f := os.NewFile(uintptr(fd), authorizedKeysName)
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", authorizedKeysName)
+	}
+	if info.Size() > authorizedKeysMaxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", authorizedKeysName, authorizedKeysMaxSize)
+	}
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", authorizedKeysName, err)
+	}
+	content := strings.TrimRight(buf.String(), "\n")
+	if content == "" {
+		return nil, nil
+	}
+	return strings.Split(content, "\n"), nil
+}
+
+// writeAuthorizedKeys replaces authorized_keys in dir with lines. The new
+// contents are written to a temporary file owned by the user with mode 0600
+// and renamed over the old file, so the file is never seen half written.
+func writeAuthorizedKeys(u *sshUser, dir int, lines []string) error {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return err
+	}
+	tmpName := "." + authorizedKeysName + "." + hex.EncodeToString(suffix[:])
+
+	fd, err := unix.Openat(dir, tmpName, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmpName, err)
+	}
+	f := os.NewFile(uintptr(fd), tmpName)
+	committed := false
+	defer func() {
+		if !committed {
+			f.Close()
+			unix.Unlinkat(dir, tmpName, 0)
+		}
+	}()
+
+	var data []byte
+	if len(lines) > 0 {
+		data = []byte(strings.Join(lines, "\n") + "\n")
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %v", authorizedKeysName, err)
+	}
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	if os.Geteuid() == 0 {
+		if err := f.Chown(u.UID, u.GID); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %v", authorizedKeysName, err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
 	}
 
-	// Write back to file
-	data := []byte(strings.Join(remainingKeys, "\n"))
-	if len(remainingKeys) > 0 {
-		data = append(data, '\n')
+	if err := unix.Renameat(dir, tmpName, dir, authorizedKeysName); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", authorizedKeysName, err)
 	}
-	return os.WriteFile(path, data, 0600)
+	committed = true
+	return unix.Fsync(dir)
 }
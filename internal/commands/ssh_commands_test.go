@@ -0,0 +1,343 @@
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"mac-guest-agent/protocol"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// useSSHHome makes the current user's home a temporary directory for the
+// duration of a test and returns the user name and home.
+func useSSHHome(t *testing.T) (string, string) {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+	home := t.TempDir()
+	previous := lookupUser
+	lookupUser = func(username string) (*user.User, error) {
+		if username != current.Username {
+			return nil, user.UnknownUserError(username)
+		}
+		u := *current
+		u.HomeDir = home
+		return &u, nil
+	}
+	t.Cleanup(func() { lookupUser = previous })
+	return current.Username, home
+}
+
+// sshKey returns an ed25519 public key line with a random key and comment.
+func sshKey(t *testing.T, comment string) string {
+	t.Helper()
+	var blob []byte
+	for _, field := range [][]byte{[]byte("ssh-ed25519"), make([]byte, 32)} {
+		blob = binary.BigEndian.AppendUint32(blob, uint32(len(field)))
+		blob = append(blob, field...)
+	}
+	if _, err := rand.Read(blob[len(blob)-32:]); err != nil {
+		t.Fatal(err)
+	}
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(blob) + " " + comment
+}
+
+// addKeys runs guest-ssh-add-authorized-keys.
+func addKeys(username string, keys []string, reset bool) error {
+	req, _ := json.Marshal(protocol.GuestSSHAddKeysArgs{Username: username, Keys: keys, Reset: reset})
+	_, err := handleSSHAddAuthorizedKeys(context.Background(), req)
+	return err
+}
+
+// removeKeys runs guest-ssh-remove-authorized-keys.
+func removeKeys(username string, keys []string) error {
+	req, _ := json.Marshal(protocol.GuestSSHRemoveKeysArgs{Username: username, Keys: keys})
+	_, err := handleSSHRemoveAuthorizedKeys(context.Background(), req)
+	return err
+}
+
+// getKeys runs guest-ssh-get-authorized-keys.
+func getKeys(username string) ([]string, error) {
+	req, _ := json.Marshal(protocol.GuestSSHGetKeysArgs{Username: username})
+	result, err := handleSSHGetAuthorizedKeys(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	return result.(protocol.GuestSSHInfo).Keys, nil
+}
+
+// readKeysFile returns the contents of the authorized_keys in home.
+func readKeysFile(t *testing.T, home string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(home, ".ssh", authorizedKeysName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// writeKeysFile creates ~/.ssh/authorized_keys in home holding content.
+func writeKeysFile(t *testing.T, home, content string) {
+	t.Helper()
+	dir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, authorizedKeysName), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkMode fails the test unless path has the given permissions and is
+// owned by the current user.
+func checkMode(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%s has mode %v, want %v", path, info.Mode().Perm(), mode)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; int(uid) != os.Getuid() {
+		t.Errorf("%s is owned by %d, want %d", path, uid, os.Getuid())
+	}
+}
+
+func TestSSHAddAuthorizedKeysCreatesFile(t *testing.T) {
+	username, home := useSSHHome(t)
+	key1, key2 := sshKey(t, "one"), sshKey(t, "two")
+
+	if err := addKeys(username, []string{key1, key2}, false); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	checkMode(t, filepath.Join(home, ".ssh"), 0700)
+	checkMode(t, filepath.Join(home, ".ssh", authorizedKeysName), 0600)
+	if got, want := readKeysFile(t, home), key1+"\n"+key2+"\n"; got != want {
+		t.Errorf("authorized_keys = %q, want %q", got, want)
+	}
+}
+
+func TestSSHAddAuthorizedKeysDedupes(t *testing.T) {
+	username, home := useSSHHome(t)
+	key1, key2, key3 := sshKey(t, "one"), sshKey(t, "two"), sshKey(t, "three")
+	writeKeysFile(t, home, "# managed by hand\n"+key1+"\n"+key2+"\n")
+
+	// Keys already present, with or without surrounding blanks, and keys
+	// repeated in the request are added once
+	if err := addKeys(username, []string{"  " + key2 + " ", key3, key3}, false); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	want := "# managed by hand\n" + key1 + "\n" + key2 + "\n" + key3 + "\n"
+	if got := readKeysFile(t, home); got != want {
+		t.Errorf("authorized_keys = %q, want %q", got, want)
+	}
+
+	keys, err := getKeys(username)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if want := []string{key1, key2, key3}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("get = %q, want %q", keys, want)
+	}
+}
+
+func TestSSHAddAuthorizedKeysReset(t *testing.T) {
+	username, home := useSSHHome(t)
+	key1, key2 := sshKey(t, "one"), sshKey(t, "two")
+	writeKeysFile(t, home, "# managed by hand\n"+key1+"\n")
+
+	if err := addKeys(username, []string{key2}, true); err != nil {
+		t.Fatalf("add with reset: %v", err)
+	}
+	if got, want := readKeysFile(t, home), key2+"\n"; got != want {
+		t.Errorf("authorized_keys = %q, want %q", got, want)
+	}
+
+	// Resetting to no keys leaves an empty file
+	if err := addKeys(username, []string{}, true); err != nil {
+		t.Fatalf("add with reset: %v", err)
+	}
+	if got := readKeysFile(t, home); got != "" {
+		t.Errorf("authorized_keys = %q, want it empty", got)
+	}
+}
+
+func TestSSHRemoveAuthorizedKeys(t *testing.T) {
+	username, home := useSSHHome(t)
+	key1, key2, key3 := sshKey(t, "one"), sshKey(t, "two"), sshKey(t, "three")
+
+	// Without ~/.ssh there is nothing to remove
+	if err := removeKeys(username, []string{key1}); err != nil {
+		t.Fatalf("remove without ~/.ssh: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".ssh")); !os.IsNotExist(err) {
+		t.Errorf("remove created ~/.ssh: %v", err)
+	}
+
+	writeKeysFile(t, home, "# managed by hand\n"+key1+"\n\n"+key2+"\n")
+	if err := removeKeys(username, []string{key1, key3}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if got, want := readKeysFile(t, home), "# managed by hand\n"+key2+"\n"; got != want {
+		t.Errorf("authorized_keys = %q, want %q", got, want)
+	}
+	checkMode(t, filepath.Join(home, ".ssh", authorizedKeysName), 0600)
+}
+
+func TestSSHKeyValidation(t *testing.T) {
+	username, home := useSSHHome(t)
+	key := sshKey(t, "valid")
+	blob := strings.Fields(key)[1]
+
+	valid := []string{
+		key,
+		"ssh-ed25519 " + blob,
+		`command="/usr/bin/true",no-pty ` + key,
+	}
+	for _, k := range valid {
+		if err := validateSSHKey(k); err != nil {
+			t.Errorf("validateSSHKey(%q) = %v", k, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"empty":            "",
+		"no key type":      "not a key",
+		"no blob":          "ssh-ed25519",
+		"bad base64":       "ssh-ed25519 !!!! comment",
+		"short blob":       "ssh-ed25519 AAA= comment",
+		"type mismatch":    "ssh-rsa " + blob + " comment",
+		"unknown type":     "ssh-foo " + blob,
+		"embedded newline": key + "\nssh-ed25519 " + blob,
+		"carriage return":  key + "\r",
+	}
+	for name, k := range invalid {
+		if err := validateSSHKey(k); err == nil {
+			t.Errorf("%s: validateSSHKey(%q) succeeded", name, k)
+		}
+	}
+
+	// A request with one invalid key changes nothing
+	writeKeysFile(t, home, key+"\n")
+	if err := addKeys(username, []string{sshKey(t, "new"), "not a key"}, true); err == nil {
+		t.Error("add with an invalid key succeeded")
+	}
+	if err := removeKeys(username, []string{key, "not a key"}); err == nil {
+		t.Error("remove with an invalid key succeeded")
+	}
+	if got := readKeysFile(t, home); got != key+"\n" {
+		t.Errorf("authorized_keys = %q after rejected requests", got)
+	}
+}
+
+func TestSSHRefusesSymlinkedDir(t *testing.T) {
+	username, home := useSSHHome(t)
+	outside := t.TempDir()
+	key := sshKey(t, "key")
+	if err := os.Symlink(outside, filepath.Join(home, ".ssh")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, err := range map[string]error{
+		"add":    addKeys(username, []string{key}, false),
+		"remove": removeKeys(username, []string{key}),
+	} {
+		if err == nil || !strings.Contains(err.Error(), errSymlink.Error()) {
+			t.Errorf("%s through a symlinked ~/.ssh: error = %v", name, err)
+		}
+	}
+	if _, err := getKeys(username); err == nil || !strings.Contains(err.Error(), errSymlink.Error()) {
+		t.Errorf("get through a symlinked ~/.ssh: error = %v", err)
+	}
+
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 0 {
+		t.Errorf("the symlink target was written to: %v", entries)
+	}
+}
+
+func TestSSHRefusesSymlinkedFile(t *testing.T) {
+	username, home := useSSHHome(t)
+	key := sshKey(t, "key")
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(target, []byte("outside\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(home, ".ssh", authorizedKeysName)
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, err := range map[string]error{
+		"add":    addKeys(username, []string{key}, false),
+		"remove": removeKeys(username, []string{key}),
+	} {
+		if err == nil || !strings.Contains(err.Error(), errSymlink.Error()) {
+			t.Errorf("%s through a symlinked authorized_keys: error = %v", name, err)
+		}
+	}
+	if _, err := getKeys(username); err == nil || !strings.Contains(err.Error(), errSymlink.Error()) {
+		t.Errorf("get through a symlinked authorized_keys: error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(target); string(data) != "outside\n" {
+		t.Errorf("the symlink target holds %q", data)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("authorized_keys is no longer the symlink: %v", err)
+	}
+}
+
+func TestSSHReplacesFileAtomically(t *testing.T) {
+	username, home := useSSHHome(t)
+	key1, key2 := sshKey(t, "one"), sshKey(t, "two")
+	writeKeysFile(t, home, key1+"\n")
+	path := filepath.Join(home, ".ssh", authorizedKeysName)
+
+	// A hard link keeps the old file, which is replaced rather than
+	// rewritten in place
+	old := filepath.Join(home, "old")
+	if err := os.Link(path, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := addKeys(username, []string{key2}, false); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if data, _ := os.ReadFile(old); string(data) != key1+"\n" {
+		t.Errorf("the old file was modified: %q", data)
+	}
+	if got, want := readKeysFile(t, home), key1+"\n"+key2+"\n"; got != want {
+		t.Errorf("authorized_keys = %q, want %q", got, want)
+	}
+	checkMode(t, path, 0600)
+
+	// No temporary file is left behind
+	entries, err := os.ReadDir(filepath.Join(home, ".ssh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != authorizedKeysName {
+		t.Errorf("~/.ssh holds %v, want only %s", entries, authorizedKeysName)
+	}
+}
+
+func TestSSHUnknownUser(t *testing.T) {
+	useSSHHome(t)
+	if err := addKeys("no-such-user", []string{sshKey(t, "key")}, false); err == nil || !strings.Contains(err.Error(), "user not found") {
+		t.Errorf("add for an unknown user: error = %v", err)
+	}
+}
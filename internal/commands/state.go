@@ -0,0 +1,55 @@
+package commands
+
+import "sync"
+
+// State is the agent-wide state commands may consult. It is implemented by
+// agent.GAState and registered with SetState when the agent is created.
+type State interface {
+	// GetFdHandle returns a new, unique guest-file-* handle.
+	GetFdHandle() int64
+
+	// AddCommandStateCleanup registers a function run when the agent stops.
+	AddCommandStateCleanup(cleanupFunc func())
+
+	// IsCommandAllowed reports whether the operator's allow and block
+	// lists, and the guest's freeze state, permit running a command.
+	IsCommandAllowed(cmdName string) bool
+
+	// SetFrozen records whether the guest's filesystems are frozen. While
+	// frozen only the commands upstream allows are permitted.
+	SetFrozen(frozen bool)
+}
+
+var (
+	state      State
+	stateMutex sync.RWMutex
+)
+
+// SetState registers the agent state with the commands package and hooks the
+// commands' own cleanup, such as closing leaked file handles, thawing frozen
+// filesystems and killing programs started by guest-exec, into it.
+func SetState(s State) {
+	stateMutex.Lock()
+	state = s
+	stateMutex.Unlock()
+
+	if s != nil {
+		s.AddCommandStateCleanup(closeAllFileHandles)
+		s.AddCommandStateCleanup(thawOnExit)
+		s.AddCommandStateCleanup(killExecProcesses)
+	}
+}
+
+// currentState returns the registered agent state, or nil if none.
+func currentState() State {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return state
+}
+
+// commandAllowed reports whether the registered state permits running the
+// named command. Without a state every command is allowed.
+func commandAllowed(name string) bool {
+	s := currentState()
+	return s == nil || s.IsCommandAllowed(name)
+}
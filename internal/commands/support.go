@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"fmt"
+	"mac-guest-agent/protocol"
+	"sync"
+)
+
+// SupportLevel describes how a command is implemented on macOS.
+type SupportLevel int
+
+const (
+	// SupportNative commands are implemented with real macOS facilities.
+	SupportNative SupportLevel = iota
+
+	// SupportEmulated commands succeed without doing everything upstream
+	// does, e.g. guest-fsfreeze-freeze flushes filesystems but cannot stop
+	// further writes.
+	SupportEmulated
+
+	// SupportUnsupported commands are registered for compatibility but
+	// always fail.
+	SupportUnsupported
+)
+
+// String returns the name of the level used in guest-info and
+// guest-query-schema.
+func (s SupportLevel) String() string {
+	switch s {
+	case SupportEmulated:
+		return "emulated"
+	case SupportUnsupported:
+		return "unsupported"
+	default:
+		return "native"
+	}
+}
+
+// EmulatedPolicy controls how emulated commands are presented to the host.
+type EmulatedPolicy int
+
+const (
+	// EmulatedAdvertise reports emulated commands as enabled in guest-info
+	// and runs them. This matches the behaviour of earlier releases.
+	EmulatedAdvertise EmulatedPolicy = iota
+
+	// EmulatedSucceed still runs emulated commands but reports them as
+	// disabled in guest-info, so tooling that checks first will not use
+	// them.
+	EmulatedSucceed
+
+	// EmulatedUnsupported reports emulated commands as disabled and fails
+	// them with an Unsupported error.
+	EmulatedUnsupported
+)
+
+// emulatedPolicyNames are the names accepted by ParseEmulatedPolicy.
+var emulatedPolicyNames = map[EmulatedPolicy]string{
+	EmulatedAdvertise:   "advertise",
+	EmulatedSucceed:     "succeed",
+	EmulatedUnsupported: "unsupported",
+}
+
+// String returns the configuration name of the policy.
+func (p EmulatedPolicy) String() string {
+	return emulatedPolicyNames[p]
+}
+
+// ParseEmulatedPolicy parses a policy name: advertise, succeed or
+// unsupported.
+func ParseEmulatedPolicy(name string) (EmulatedPolicy, error) {
+	for policy, n := range emulatedPolicyNames {
+		if n == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown emulated command policy %q, expected advertise, succeed or unsupported", name)
+}
+
+var (
+	emulatedPolicy      = EmulatedAdvertise
+	emulatedPolicyMutex sync.RWMutex
+)
+
+// SetEmulatedPolicy changes how emulated commands are presented.
+func SetEmulatedPolicy(policy EmulatedPolicy) {
+	emulatedPolicyMutex.Lock()
+	defer emulatedPolicyMutex.Unlock()
+	emulatedPolicy = policy
+}
+
+// CurrentEmulatedPolicy returns the policy for emulated commands.
+func CurrentEmulatedPolicy() EmulatedPolicy {
+	emulatedPolicyMutex.RLock()
+	defer emulatedPolicyMutex.RUnlock()
+	return emulatedPolicy
+}
+
+// advertised reports whether guest-info lists cmd as enabled. Commands
+// blocked by the operator are hidden, as is guest-exec unless -allow-exec
+// is set, and so are commands that would not do what the host asked unless
+// the policy says otherwise.
+func advertised(cmd *Command) bool {
+	if !cmd.Enabled || !commandAllowed(cmd.Name) {
+		return false
+	}
+	if cmd.Name == "guest-exec" && !ExecEnabled() {
+		return false
+	}
+	switch cmd.Support {
+	case SupportEmulated:
+		return CurrentEmulatedPolicy() == EmulatedAdvertise
+	case SupportUnsupported:
+		return false
+	default:
+		return true
+	}
+}
+
+// checkSupport returns an Unsupported error if cmd must not run on macOS.
+func checkSupport(cmd *Command) error {
+	switch cmd.Support {
+	case SupportEmulated:
+		if CurrentEmulatedPolicy() == EmulatedUnsupported {
+			return NewError(protocol.ErrorClassUnsupported,
+				"The command %s is only emulated on macOS and has been disabled", cmd.Name)
+		}
+	case SupportUnsupported:
+		return NewError(protocol.ErrorClassUnsupported, "The command %s is not supported on macOS", cmd.Name)
+	}
+	return nil
+}
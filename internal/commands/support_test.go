@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"context"
+	"mac-guest-agent/protocol"
+	"testing"
+)
+
+func TestFreezeIsEmulated(t *testing.T) {
+	defer SetEmulatedPolicy(CurrentEmulatedPolicy())
+	SetEmulatedPolicy(EmulatedUnsupported)
+
+	for _, name := range []string{"guest-fsfreeze-freeze", "guest-fsfreeze-freeze-list"} {
+		cmd := CommandRegistry[name]
+		if cmd.Support != SupportEmulated {
+			t.Errorf("%s support = %s, want emulated", name, cmd.Support)
+		}
+		if advertised(cmd) {
+			t.Errorf("%s is advertised with the unsupported policy", name)
+		}
+		resp := HandleCommand(context.Background(), protocol.QMPRequest{Execute: name})
+		if resp.Error == nil || resp.Error.Class != protocol.ErrorClassUnsupported {
+			t.Errorf("%s returned %+v, want an Unsupported error", name, resp)
+		}
+	}
+
+	// Thawing stays available so a guest frozen under another policy can
+	// always be released.
+	if cmd := CommandRegistry["guest-fsfreeze-thaw"]; !advertised(cmd) {
+		t.Error("guest-fsfreeze-thaw is not advertised")
+	}
+}
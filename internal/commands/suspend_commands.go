@@ -1,9 +1,9 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
-	"os/exec"
+	"mac-guest-agent/protocol"
 
 	"github.com/sirupsen/logrus"
 )
@@ -12,24 +12,27 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-suspend-disk",
 		Handler: handleGuestSuspendDisk,
+		Returns: protocol.EmptyResponse{},
 		Enabled: true,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-suspend-ram",
 		Handler: handleGuestSuspendRAM,
+		Returns: protocol.EmptyResponse{},
 		Enabled: true,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-suspend-hybrid",
 		Handler: handleGuestSuspendHybrid,
+		Returns: protocol.EmptyResponse{},
 		Enabled: true,
 	})
 }
 
 // handleGuestSuspendDisk handles the guest-suspend-disk command.
-func handleGuestSuspendDisk(req json.RawMessage) (interface{}, error) {
+func handleGuestSuspendDisk(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	logrus.Info("Executing guest-suspend-disk command")
-	if err := suspendToDisk(); err != nil {
+	if err := suspendToDisk(ctx); err != nil {
 		logrus.WithError(err).Error("Suspend to disk failed")
 		return nil, err
 	}
@@ -38,9 +41,9 @@ func handleGuestSuspendDisk(req json.RawMessage) (interface{}, error) {
 }
 
 // handleGuestSuspendRAM handles the guest-suspend-ram command.
-func handleGuestSuspendRAM(req json.RawMessage) (interface{}, error) {
+func handleGuestSuspendRAM(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	logrus.Info("Executing guest-suspend-ram command")
-	if err := suspendToRAM(); err != nil {
+	if err := suspendToRAM(ctx); err != nil {
 		logrus.WithError(err).Error("Suspend to RAM failed")
 		return nil, err
 	}
@@ -49,9 +52,9 @@ func handleGuestSuspendRAM(req json.RawMessage) (interface{}, error) {
 }
 
 // handleGuestSuspendHybrid handles the guest-suspend-hybrid command.
-func handleGuestSuspendHybrid(req json.RawMessage) (interface{}, error) {
+func handleGuestSuspendHybrid(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	logrus.Info("Executing guest-suspend-hybrid command")
-	if err := suspendHybrid(); err != nil {
+	if err := suspendHybrid(ctx); err != nil {
 		logrus.WithError(err).Error("Hybrid suspend failed")
 		return nil, err
 	}
@@ -60,40 +63,34 @@ func handleGuestSuspendHybrid(req json.RawMessage) (interface{}, error) {
 }
 
 // suspendToDisk suspends the system to disk.
-func suspendToDisk() error {
+func suspendToDisk(ctx context.Context) error {
 	// On macOS, use the pmset command for power management.
 	// hibernatemode 25 means suspend to disk.
-	cmd := exec.Command("pmset", "-a", "hibernatemode", "25")
-	if err := cmd.Run(); err != nil {
+	if err := runCommand(ctx, "pmset", "-a", "hibernatemode", "25"); err != nil {
 		return err
 	}
 	// Execute the sleep command.
-	cmd = exec.Command("pmset", "sleepnow")
-	return cmd.Run()
+	return runCommand(ctx, "pmset", "sleepnow")
 }
 
 // suspendToRAM suspends the system to RAM.
-func suspendToRAM() error {
+func suspendToRAM(ctx context.Context) error {
 	// On macOS, use the pmset command for sleep.
 	// hibernatemode 0 means suspend to RAM only.
-	cmd := exec.Command("pmset", "-a", "hibernatemode", "0")
-	if err := cmd.Run(); err != nil {
+	if err := runCommand(ctx, "pmset", "-a", "hibernatemode", "0"); err != nil {
 		return err
 	}
 	// Execute the sleep command.
-	cmd = exec.Command("pmset", "sleepnow")
-	return cmd.Run()
+	return runCommand(ctx, "pmset", "sleepnow")
 }
 
 // suspendHybrid performs a hybrid suspend.
-func suspendHybrid() error {
+func suspendHybrid(ctx context.Context) error {
 	// On macOS, use the pmset command for hybrid sleep.
 	// hibernatemode 3 means RAM + disk hybrid mode.
-	cmd := exec.Command("pmset", "-a", "hibernatemode", "3")
-	if err := cmd.Run(); err != nil {
+	if err := runCommand(ctx, "pmset", "-a", "hibernatemode", "3"); err != nil {
 		return err
 	}
 	// Execute the sleep command.
-	cmd = exec.Command("pmset", "sleepnow")
-	return cmd.Run()
+	return runCommand(ctx, "pmset", "sleepnow")
 }
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSuspend(t *testing.T) {
+	tests := []struct {
+		command string
+		mode    string
+	}{
+		{"guest-suspend-ram", "0"},
+		{"guest-suspend-hybrid", "3"},
+		{"guest-suspend-disk", "25"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			useFixtures(t, "pmset.json")
+			recorder := NewRecordingRunner(CurrentRunner())
+			useRunner(t, recorder)
+
+			cmd, _ := LookupCommand(tt.command)
+			if _, err := cmd.Handler(context.Background(), nil); err != nil {
+				t.Fatal(err)
+			}
+			var ran []string
+			for _, f := range recorder.Fixtures() {
+				ran = append(ran, strings.Join(f.Argv, " "))
+			}
+			want := []string{"pmset -a hibernatemode " + tt.mode, "pmset sleepnow"}
+			if !reflect.DeepEqual(ran, want) {
+				t.Errorf("ran %q, want %q", ran, want)
+			}
+		})
+	}
+}
+
+func TestSuspendFailsWithoutSleeping(t *testing.T) {
+	useFixtures(t, "pmset_not_root.json")
+	recorder := NewRecordingRunner(CurrentRunner())
+	useRunner(t, recorder)
+
+	_, err := handleGuestSuspendRAM(context.Background(), nil)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 1 {
+		t.Fatalf("guest-suspend-ram error = %v, want pmset's exit status", err)
+	}
+	for _, f := range recorder.Fixtures() {
+		if f.Argv[len(f.Argv)-1] == "sleepnow" {
+			t.Error("slept although the hibernate mode could not be set")
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 )
@@ -16,25 +17,34 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-sync",
 		Handler: handleGuestSync,
+		Returns: int64(0),
+		Args:    []Arg{{Name: "id", Type: TypeInt}},
 		Enabled: true,
+		Class:   ExecHeartbeat,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-sync-id",
 		Handler: handleGuestSync,
+		Returns: int64(0),
+		Args:    []Arg{{Name: "id", Type: TypeInt}},
 		Enabled: true,
+		Class:   ExecHeartbeat,
 	})
 	// guest-sync-delimited uses the same logic as guest-sync. The agent's
 	// response handling layer is responsible for sending the delimiter.
 	RegisterCommand(&Command{
 		Name:    "guest-sync-delimited",
 		Handler: handleGuestSync,
+		Returns: int64(0),
+		Args:    []Arg{{Name: "id", Type: TypeInt}},
 		Enabled: true,
+		Class:   ExecHeartbeat,
 	})
 }
 
 // handleGuestSync handles both guest-sync and guest-sync-delimited commands.
 // It simply returns the ID that was passed in.
-func handleGuestSync(req json.RawMessage) (interface{}, error) {
+func handleGuestSync(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	var args GuestSyncArgs
 	if err := json.Unmarshal(req, &args); err != nil {
 		return nil, fmt.Errorf("failed to parse arguments for guest-sync: %v", err)
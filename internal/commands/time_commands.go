@@ -1,9 +1,9 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
-	"mac-guest-agent/internal/protocol"
-	"os/exec"
+	"mac-guest-agent/protocol"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -13,22 +13,28 @@ func init() {
 	RegisterCommand(&Command{
 		Name:    "guest-get-time",
 		Handler: handleGetTime,
+		Returns: int64(0),
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-set-time",
 		Handler: handleSetTime,
+		Returns: protocol.EmptyResponse{},
+		Args:    []Arg{{Name: "time", Type: TypeInt, Optional: true}},
 		Enabled: true,
 	})
 	RegisterCommand(&Command{
 		Name:    "guest-get-timezone",
 		Handler: handleGetTimezone,
+		Returns: protocol.GuestTimezone{},
 		Enabled: true,
+		Class:   ExecConcurrent,
 	})
 }
 
 // handleGetTime handles the guest-get-time command.
-func handleGetTime(req json.RawMessage) (interface{}, error) {
+func handleGetTime(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	now := time.Now()
 	// Return nanoseconds timestamp.
 	nanoseconds := now.UnixNano()
@@ -42,7 +48,7 @@ func handleGetTime(req json.RawMessage) (interface{}, error) {
 }
 
 // handleSetTime handles the guest-set-time command.
-func handleSetTime(req json.RawMessage) (interface{}, error) {
+func handleSetTime(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	var args protocol.SetTimeArgs
 	if err := json.Unmarshal(req, &args); err != nil {
 		return nil, err
@@ -51,7 +57,7 @@ func handleSetTime(req json.RawMessage) (interface{}, error) {
 	targetTime := time.Unix(0, args.Time)
 
 	// Setting system time on macOS requires administrator privileges.
-	if err := setSystemTime(targetTime); err != nil {
+	if err := setSystemTime(ctx, targetTime); err != nil {
 		logrus.WithError(err).Error("Failed to set system time")
 		return nil, err
 	}
@@ -65,7 +71,7 @@ func handleSetTime(req json.RawMessage) (interface{}, error) {
 }
 
 // handleGetTimezone handles the guest-get-timezone command.
-func handleGetTimezone(req json.RawMessage) (interface{}, error) {
+func handleGetTimezone(ctx context.Context, req json.RawMessage) (interface{}, error) {
 	now := time.Now()
 	zone, offset := now.Zone()
 
@@ -83,10 +89,9 @@ func handleGetTimezone(req json.RawMessage) (interface{}, error) {
 }
 
 // setSystemTime sets the system time.
-func setSystemTime(t time.Time) error {
+func setSystemTime(ctx context.Context, t time.Time) error {
 	// On macOS, use the date command to set the time.
 	// Format: MMddHHmmYY (MonthDayHourMinuteYear)
 	dateStr := t.Format("0102150406")
-	cmd := exec.Command("sudo", "date", dateStr)
-	return cmd.Run()
+	return runCommand(ctx, "sudo", "date", dateStr)
 }
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestGetTime(t *testing.T) {
+	before := time.Now().UnixNano()
+	result, err := handleGetTime(context.Background(), nil)
+	after := time.Now().UnixNano()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.(int64); got < before || got > after {
+		t.Errorf("guest-get-time = %d, want between %d and %d", got, before, after)
+	}
+}
+
+func TestSetTime(t *testing.T) {
+	// date(1) takes the local time; pin the zone so the argv is stable
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	useFixtures(t, "date.json")
+	target := time.Date(2024, 3, 28, 9, 14, 0, 0, time.UTC).UnixNano()
+	args, _ := json.Marshal(map[string]int64{"time": target})
+	if _, err := handleSetTime(context.Background(), args); err != nil {
+		t.Fatalf("guest-set-time error = %v", err)
+	}
+
+	// Any other date command is not in the fixture and fails
+	args, _ = json.Marshal(map[string]int64{"time": target + int64(time.Hour)})
+	if _, err := handleSetTime(context.Background(), args); err == nil {
+		t.Error("guest-set-time ran an unexpected date command")
+	}
+}
@@ -0,0 +1,455 @@
+// Package config 读取Agent守护进程的配置文件。
+// 配置文件采用与upstream qemu-ga相同的INI格式，命令行参数优先于配置文件。
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mac-guest-agent/internal/agent"
+	"mac-guest-agent/internal/audit"
+	"mac-guest-agent/internal/commands"
+	"mac-guest-agent/internal/transport"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPath 默认配置文件路径
+	DefaultPath = "/usr/local/etc/mac-guest-agent.conf"
+
+	// DefaultLogFile 守护进程模式下的默认日志文件
+	DefaultLogFile = "/var/log/mac-guest-agent.log"
+)
+
+// Config Agent的完整配置
+type Config struct {
+	General   GeneralConfig
+	Transport TransportConfig
+	Logging   LoggingConfig
+	Commands  CommandsConfig
+	Exec      ExecConfig
+	Fsfreeze  FsfreezeConfig
+	Timeouts  TimeoutsConfig
+	Audit     AuditConfig
+	Metrics   MetricsConfig
+}
+
+// GeneralConfig [general] 分组
+type GeneralConfig struct {
+	// Daemon 以守护进程方式运行
+	Daemon bool
+}
+
+// TransportConfig [transport] 分组
+type TransportConfig struct {
+	// Method 传输方式名称
+	Method string
+
+	// Path 设备路径、套接字路径或vsock地址
+	Path string
+
+	// SocketMode unix-listen模式下套接字文件的权限
+	SocketMode os.FileMode
+
+	// MaxMessageSize 单条JSON消息的最大字节数
+	MaxMessageSize int
+
+	// RetryDelay 传输通道断开后重新连接前的等待时间
+	RetryDelay time.Duration
+}
+
+// LoggingConfig [logging] 分组
+type LoggingConfig struct {
+	// Verbose 启用详细日志
+	Verbose bool
+
+	// LogFile 守护进程模式下的日志文件路径
+	LogFile string
+}
+
+// CommandsConfig [commands] 分组
+type CommandsConfig struct {
+	// AllowRPCs 命令白名单，非空时只允许列出的命令
+	AllowRPCs []string
+
+	// BlockRPCs 命令黑名单
+	BlockRPCs []string
+
+	// Workers 并发执行命令的工作协程数
+	Workers int
+
+	// Emulated 模拟实现的命令的处理策略
+	Emulated commands.EmulatedPolicy
+}
+
+// ExecConfig [exec] 分组
+type ExecConfig struct {
+	// Enabled 允许宿主机通过guest-exec执行程序
+	Enabled bool
+
+	// Timeout guest-exec启动的程序的最长运行时间，超时后结束整个进程组
+	Timeout time.Duration
+}
+
+// FsfreezeConfig [fsfreeze] 分组
+type FsfreezeConfig struct {
+	// HookDir 冻结和解冻时依次执行的钩子脚本目录，为空时不执行钩子
+	HookDir string
+
+	// HookTimeout 单个钩子脚本的超时时间
+	HookTimeout time.Duration
+
+	// HookFailure 冻结钩子失败时的处理策略
+	HookFailure commands.FreezeHookPolicy
+
+	// Timeout 宿主机未发送解冻命令时自动解冻前的等待时间
+	Timeout time.Duration
+}
+
+// TimeoutsConfig [timeouts] 分组
+type TimeoutsConfig struct {
+	// Default 命令执行的默认超时时间
+	Default time.Duration
+
+	// Commands 单个命令的超时时间，键为命令名称
+	Commands map[string]time.Duration
+}
+
+// AuditConfig [audit] 分组
+type AuditConfig struct {
+	// LogFile 审计日志文件路径，为空时不记录审计日志
+	LogFile string
+
+	// MaxSize 单个审计日志文件的大小上限（字节），超过后轮转
+	MaxSize int64
+
+	// MaxBackups 轮转后保留的旧文件数
+	MaxBackups int
+
+	// Redact 额外需要在日志中隐去的参数，格式为 命令名:参数名
+	Redact []string
+}
+
+// MetricsConfig [metrics] 分组
+type MetricsConfig struct {
+	// Listen 导出指标的地址，回环地址（如 127.0.0.1:9101）或 unix:/path，为空时不导出
+	Listen string
+}
+
+// Default 返回默认配置，与未提供配置文件和命令行参数时的行为一致
+func Default() *Config {
+	return &Config{
+		Transport: TransportConfig{
+			Method:         transport.DefaultMethod,
+			SocketMode:     transport.DefaultSocketMode,
+			MaxMessageSize: agent.DefaultMaxMessageSize,
+			RetryDelay:     agent.DefaultReconnectDelay,
+		},
+		Logging: LoggingConfig{
+			LogFile: DefaultLogFile,
+		},
+		Commands: CommandsConfig{
+			Workers: agent.DefaultWorkers,
+		},
+		Exec: ExecConfig{
+			Timeout: commands.DefaultExecTimeout,
+		},
+		Fsfreeze: FsfreezeConfig{
+			HookDir:     commands.DefaultFreezeHookDir,
+			HookTimeout: commands.DefaultFreezeHookTimeout,
+			Timeout:     commands.DefaultFreezeTimeout,
+		},
+		Timeouts: TimeoutsConfig{
+			Default:  commands.DefaultTimeout,
+			Commands: make(map[string]time.Duration),
+		},
+		Audit: AuditConfig{
+			LogFile:    audit.DefaultPath,
+			MaxSize:    audit.DefaultMaxSize,
+			MaxBackups: audit.DefaultMaxBackups,
+		},
+	}
+}
+
+// Load 读取配置文件并覆盖默认配置
+// 如果 mustExist 为false，文件不存在时直接返回默认配置
+func Load(path string, mustExist bool) (*Config, error) {
+	cfg := Default()
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !mustExist {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := cfg.Parse(file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse 解析INI格式的配置并覆盖当前值
+func (c *Config) Parse(r io.Reader) error {
+	entries, err := parseINI(r)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := c.set(entry.Section, entry.Key, entry.Value); err != nil {
+			return fmt.Errorf("第%d行: [%s] %s: %v", entry.Line, entry.Section, entry.Key, err)
+		}
+	}
+	return nil
+}
+
+// set 设置单个配置项
+func (c *Config) set(section, key, value string) error {
+	var err error
+
+	switch section {
+	case "general":
+		switch key {
+		case "daemonize":
+			c.General.Daemon, err = strconv.ParseBool(value)
+		default:
+			return errUnknownKey
+		}
+
+	case "transport":
+		switch key {
+		case "method":
+			c.Transport.Method = value
+		case "path":
+			c.Transport.Path = value
+		case "socket-mode":
+			var mode uint64
+			mode, err = strconv.ParseUint(value, 8, 32)
+			c.Transport.SocketMode = os.FileMode(mode)
+		case "max-message-size":
+			c.Transport.MaxMessageSize, err = parsePositiveInt(value)
+		case "retry-delay":
+			c.Transport.RetryDelay, err = parseDuration(value)
+		default:
+			return errUnknownKey
+		}
+
+	case "logging":
+		switch key {
+		case "verbose":
+			c.Logging.Verbose, err = strconv.ParseBool(value)
+		case "logfile":
+			c.Logging.LogFile = value
+		default:
+			return errUnknownKey
+		}
+
+	case "commands":
+		switch key {
+		case "allow-rpcs":
+			c.Commands.AllowRPCs = SplitList(value)
+		case "block-rpcs":
+			c.Commands.BlockRPCs = SplitList(value)
+		case "workers":
+			c.Commands.Workers, err = parsePositiveInt(value)
+		case "emulated":
+			c.Commands.Emulated, err = commands.ParseEmulatedPolicy(value)
+		default:
+			return errUnknownKey
+		}
+
+	case "exec":
+		switch key {
+		case "enabled":
+			c.Exec.Enabled, err = strconv.ParseBool(value)
+		case "timeout":
+			c.Exec.Timeout, err = parseDuration(value)
+		default:
+			return errUnknownKey
+		}
+
+	case "fsfreeze":
+		switch key {
+		case "hook-dir":
+			c.Fsfreeze.HookDir = value
+		case "hook-timeout":
+			c.Fsfreeze.HookTimeout, err = parseDuration(value)
+		case "hook-failure":
+			c.Fsfreeze.HookFailure, err = commands.ParseFreezeHookPolicy(value)
+		case "timeout":
+			c.Fsfreeze.Timeout, err = parseDuration(value)
+		default:
+			return errUnknownKey
+		}
+
+	case "timeouts":
+		// default 以外的键都是命令名称
+		var timeout time.Duration
+		timeout, err = parseDuration(value)
+		if err != nil {
+			break
+		}
+		if key == "default" {
+			c.Timeouts.Default = timeout
+		} else if _, ok := commands.LookupCommand(key); ok {
+			c.Timeouts.Commands[key] = timeout
+		} else {
+			err = fmt.Errorf("未知的命令")
+		}
+
+	case "audit":
+		switch key {
+		case "logfile":
+			c.Audit.LogFile = value
+		case "max-size":
+			c.Audit.MaxSize, err = parseSize(value)
+		case "max-backups":
+			c.Audit.MaxBackups, err = strconv.Atoi(value)
+			if err == nil && c.Audit.MaxBackups < 0 {
+				err = fmt.Errorf("不能小于0")
+			}
+		case "redact":
+			c.Audit.Redact = SplitList(value)
+		default:
+			return errUnknownKey
+		}
+
+	case "metrics":
+		switch key {
+		case "listen":
+			c.Metrics.Listen = value
+		default:
+			return errUnknownKey
+		}
+
+	default:
+		return fmt.Errorf("未知的分组")
+	}
+
+	return err
+}
+
+// errUnknownKey 配置项不存在
+var errUnknownKey = errors.New("未知的配置项")
+
+// parsePositiveInt 解析正整数
+func parsePositiveInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("必须大于0")
+	}
+	return n, nil
+}
+
+// parseSize 解析字节数，支持 K、M、G 后缀（按1024进位）
+func parseSize(value string) (int64, error) {
+	if value == "" {
+		return 0, fmt.Errorf("不能为空")
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("必须大于0")
+	}
+	return n * multiplier, nil
+}
+
+// parseDuration 解析时间长度，支持 "30s" 这样的写法，纯数字按秒计算
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0, fmt.Errorf("必须大于0")
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("必须大于0")
+	}
+	return d, nil
+}
+
+// Dump 以配置文件格式输出完整的生效配置
+func (c *Config) Dump(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("[general]\n")
+	fmt.Fprintf(&b, "daemonize=%t\n", c.General.Daemon)
+
+	b.WriteString("\n[transport]\n")
+	fmt.Fprintf(&b, "method=%s\n", c.Transport.Method)
+	fmt.Fprintf(&b, "path=%s\n", c.Transport.Path)
+	fmt.Fprintf(&b, "socket-mode=%04o\n", uint32(c.Transport.SocketMode))
+	fmt.Fprintf(&b, "max-message-size=%d\n", c.Transport.MaxMessageSize)
+	fmt.Fprintf(&b, "retry-delay=%s\n", c.Transport.RetryDelay)
+
+	b.WriteString("\n[logging]\n")
+	fmt.Fprintf(&b, "verbose=%t\n", c.Logging.Verbose)
+	fmt.Fprintf(&b, "logfile=%s\n", c.Logging.LogFile)
+
+	b.WriteString("\n[commands]\n")
+	fmt.Fprintf(&b, "allow-rpcs=%s\n", strings.Join(c.Commands.AllowRPCs, ","))
+	fmt.Fprintf(&b, "block-rpcs=%s\n", strings.Join(c.Commands.BlockRPCs, ","))
+	fmt.Fprintf(&b, "workers=%d\n", c.Commands.Workers)
+	fmt.Fprintf(&b, "emulated=%s\n", c.Commands.Emulated)
+
+	b.WriteString("\n[exec]\n")
+	fmt.Fprintf(&b, "enabled=%t\n", c.Exec.Enabled)
+	fmt.Fprintf(&b, "timeout=%s\n", c.Exec.Timeout)
+
+	b.WriteString("\n[fsfreeze]\n")
+	fmt.Fprintf(&b, "hook-dir=%s\n", c.Fsfreeze.HookDir)
+	fmt.Fprintf(&b, "hook-timeout=%s\n", c.Fsfreeze.HookTimeout)
+	fmt.Fprintf(&b, "hook-failure=%s\n", c.Fsfreeze.HookFailure)
+	fmt.Fprintf(&b, "timeout=%s\n", c.Fsfreeze.Timeout)
+
+	b.WriteString("\n[timeouts]\n")
+	fmt.Fprintf(&b, "default=%s\n", c.Timeouts.Default)
+	names := make([]string, 0, len(c.Timeouts.Commands))
+	for name := range c.Timeouts.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%s\n", name, c.Timeouts.Commands[name])
+	}
+
+	b.WriteString("\n[audit]\n")
+	fmt.Fprintf(&b, "logfile=%s\n", c.Audit.LogFile)
+	fmt.Fprintf(&b, "max-size=%d\n", c.Audit.MaxSize)
+	fmt.Fprintf(&b, "max-backups=%d\n", c.Audit.MaxBackups)
+	fmt.Fprintf(&b, "redact=%s\n", strings.Join(c.Audit.Redact, ","))
+
+	b.WriteString("\n[metrics]\n")
+	fmt.Fprintf(&b, "listen=%s\n", c.Metrics.Listen)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
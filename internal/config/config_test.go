@@ -0,0 +1,172 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"mac-guest-agent/internal/commands"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingWriter 模拟写满的标准输出
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	cfg := Default()
+	var buf bytes.Buffer
+	if err := cfg.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := Default()
+	if err := parsed.Parse(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Dump() 的输出无法解析: %v\n%s", err, buf.String())
+	}
+	var again bytes.Buffer
+	if err := parsed.Dump(&again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != buf.String() {
+		t.Errorf("重新解析后输出不同:\n%s\n---\n%s", buf.String(), again.String())
+	}
+}
+
+func TestDumpReportsWriteError(t *testing.T) {
+	if err := Default().Dump(failingWriter{}); err == nil {
+		t.Error("Dump() 没有返回写入错误")
+	}
+}
+
+func TestParse(t *testing.T) {
+	cfg := Default()
+	err := cfg.Parse(strings.NewReader(`
+# 注释和空行被忽略
+; 分号开头的也是注释
+[general]
+daemonize = true
+
+[transport]
+method=unix-listen
+path=/var/run/qga.sock
+socket-mode=0660
+max-message-size=4096
+retry-delay=2
+
+[commands]
+allow-rpcs=guest-ping;guest-info
+block-rpcs=guest-exec, guest-shutdown
+workers=8
+emulated=unsupported
+
+[exec]
+enabled=true
+timeout=10m
+
+[fsfreeze]
+hook-failure=continue
+
+[timeouts]
+default=1m
+guest-get-disks=90s
+
+[audit]
+max-size=2M
+max-backups=0
+redact=guest-file-write:path
+
+[metrics]
+listen=127.0.0.1:9101
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"daemonize", cfg.General.Daemon, true},
+		{"method", cfg.Transport.Method, "unix-listen"},
+		{"path", cfg.Transport.Path, "/var/run/qga.sock"},
+		{"socket-mode", cfg.Transport.SocketMode, os.FileMode(0660)},
+		{"max-message-size", cfg.Transport.MaxMessageSize, 4096},
+		{"retry-delay", cfg.Transport.RetryDelay, 2 * time.Second},
+		{"allow-rpcs", cfg.Commands.AllowRPCs, []string{"guest-ping", "guest-info"}},
+		{"block-rpcs", cfg.Commands.BlockRPCs, []string{"guest-exec", "guest-shutdown"}},
+		{"workers", cfg.Commands.Workers, 8},
+		{"emulated", cfg.Commands.Emulated, commands.EmulatedUnsupported},
+		{"exec enabled", cfg.Exec.Enabled, true},
+		{"exec timeout", cfg.Exec.Timeout, 10 * time.Minute},
+		{"hook-failure", cfg.Fsfreeze.HookFailure, commands.FreezeHookContinue},
+		{"default timeout", cfg.Timeouts.Default, time.Minute},
+		{"command timeouts", cfg.Timeouts.Commands, map[string]time.Duration{"guest-get-disks": 90 * time.Second}},
+		{"max-size", cfg.Audit.MaxSize, int64(2 << 20)},
+		{"max-backups", cfg.Audit.MaxBackups, 0},
+		{"redact", cfg.Audit.Redact, []string{"guest-file-write:path"}},
+		{"listen", cfg.Metrics.Listen, "127.0.0.1:9101"},
+		// 未出现的配置项保持默认值
+		{"logfile", cfg.Logging.LogFile, DefaultLogFile},
+		{"hook-dir", cfg.Fsfreeze.HookDir, commands.DefaultFreezeHookDir},
+	}
+	for _, check := range checks {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("%s = %v, 期望 %v", check.name, check.got, check.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"未知的分组", "[nosuch]\nkey=1\n", "第2行: [nosuch] key: 未知的分组"},
+		{"未知的配置项", "[general]\n\nverbose=true\n", "第3行: [general] verbose: 未知的配置项"},
+		{"未知的命令超时", "[timeouts]\nguest-no-such-command=10s\n", "第2行: [timeouts] guest-no-such-command: 未知的命令"},
+		{"无效的超时", "[timeouts]\nguest-ping=-1s\n", "第2行: [timeouts] guest-ping: 必须大于0"},
+		{"无效的布尔值", "[exec]\nenabled=maybe\n", "第2行: [exec] enabled:"},
+		{"负的旧文件数", "[audit]\nmax-backups=-1\n", "第2行: [audit] max-backups: 不能小于0"},
+		{"不在分组中", "key=value\n", "第1行: 键 key 不在任何分组中"},
+		{"缺少等号", "[general]\ndaemonize\n", "第2行: 应为 key=value 格式"},
+		{"分组名未闭合", "[general\n", "第1行: 分组名缺少 ']'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Default().Parse(strings.NewReader(tt.input))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("Parse() 错误 = %v, 期望以 %q 开头", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.conf")
+
+	// 默认配置文件不存在时使用默认配置，显式指定的必须存在
+	cfg, err := Load(missing, false)
+	if err != nil || !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Load() = %+v, %v; 期望默认配置", cfg, err)
+	}
+	if _, err := Load(missing, true); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() 错误 = %v, 期望文件不存在", err)
+	}
+
+	path := filepath.Join(dir, "agent.conf")
+	if err := os.WriteFile(path, []byte("[timeouts]\nguest-bogus=5s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, true); err == nil || !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), "guest-bogus") {
+		t.Errorf("Load() 错误 = %v, 期望包含文件路径和配置项", err)
+	}
+}
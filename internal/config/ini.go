@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// iniEntry INI文件中的一个键值对
+type iniEntry struct {
+	Section string
+	Key     string
+	Value   string
+	Line    int
+}
+
+// parseINI 解析INI格式的配置文件，语法与upstream qemu-ga使用的GKeyFile一致：
+// [section] 开始一个分组，key=value 定义键值，以 # 或 ; 开头的行是注释
+func parseINI(r io.Reader) ([]iniEntry, error) {
+	var entries []iniEntry
+	section := ""
+	lineNo := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("第%d行: 分组名缺少 ']'", lineNo)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("第%d行: 分组名为空", lineNo)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("第%d行: 应为 key=value 格式", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("第%d行: 键名为空", lineNo)
+		}
+		if section == "" {
+			return nil, fmt.Errorf("第%d行: 键 %s 不在任何分组中", lineNo, key)
+		}
+
+		entries = append(entries, iniEntry{
+			Section: section,
+			Key:     key,
+			Value:   strings.TrimSpace(value),
+			Line:    lineNo,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// SplitList 拆分列表值（配置文件和命令行参数共用），兼容GKeyFile的 ; 分隔符和更常见的 , 分隔符
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';'
+	}) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,40 @@
+package metrics
+
+// Agent导出的指标，所有指标都注册在 Default 中
+var (
+	// Default Agent的指标集合
+	Default = NewRegistry()
+
+	// CommandRequests 按命令统计的请求数
+	CommandRequests = Default.NewCounterVec(
+		"mac_guest_agent_command_requests_total",
+		"Number of commands handled, by command.",
+		"command")
+
+	// CommandErrors 按命令和QMP错误类型统计的失败数
+	CommandErrors = Default.NewCounterVec(
+		"mac_guest_agent_command_errors_total",
+		"Number of commands that returned an error, by command and QMP error class.",
+		"command", "class")
+
+	// CommandDuration 按命令统计的执行耗时
+	CommandDuration = Default.NewHistogramVec(
+		"mac_guest_agent_command_duration_seconds",
+		"Time taken to handle a command, by command.",
+		[]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120},
+		"command")
+
+	// TransportReconnects 传输通道断开后的重新连接次数，
+	// 监听型通道的客户端断开后重新等待连接也计为一次成功
+	TransportReconnects = Default.NewCounterVec(
+		"mac_guest_agent_transport_reconnects_total",
+		"Number of attempts to reopen the transport or accept a new client after it was lost, by result.",
+		"result")
+
+	// ProcessFailures 外部程序执行失败的次数
+	// reason 为 error（无法启动）、timeout（超时被终止）或 exit（非零退出码）
+	ProcessFailures = Default.NewCounterVec(
+		"mac_guest_agent_process_failures_total",
+		"Number of external programs run by commands that failed, by program and reason.",
+		"program", "reason")
+)
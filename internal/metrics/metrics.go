@@ -0,0 +1,257 @@
+// Package metrics 收集Agent的运行指标，并以Prometheus文本格式导出，
+// 供虚拟机内的监控程序采集。
+//
+// 为了不引入额外依赖，这里只实现Agent需要的计数器和直方图。
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector 可以导出为Prometheus文本格式的指标
+type collector interface {
+	write(w io.Writer) error
+}
+
+// Registry 指标集合
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry 创建空的指标集合
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register 添加一个指标
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText 以Prometheus文本格式（0.0.4版）输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// desc 指标的名称、说明和标签
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// writeHeader 输出指标的 HELP 和 TYPE 行
+func (d *desc) writeHeader(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, kind)
+	return err
+}
+
+// key 把标签值拼接为map的键
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s 需要%d个标签值，实际为%d个", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs 格式化标签，extra 追加在最后（用于直方图的 le）
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, label := range d.labels {
+		pairs = append(pairs, label+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec 按标签区分的计数器
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec 创建计数器并注册到 r
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, labels: labels},
+		values: make(map[string]*counterValue),
+	}
+	r.register(c)
+	return c
+}
+
+// Inc 将指定标签值的计数器加1
+func (c *CounterVec) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add 将指定标签值的计数器增加 delta
+func (c *CounterVec) Add(delta float64, labels ...string) {
+	key := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: append([]string(nil), labels...)}
+		c.values[key] = v
+	}
+	v.value += delta
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+	// 没有标签的计数器在首次计数前也输出0
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		_, err := fmt.Fprintf(w, "%s 0\n", c.name)
+		return err
+	}
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := c.values[key]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(v.labels), formatFloat(v.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec 按标签区分的直方图
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec 创建直方图并注册到 r，buckets 为递增的桶上限
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe 记录一个观测值
+func (h *HistogramVec) Observe(value float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{
+			labels: append([]string(nil), labels...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = v
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := h.values[key]
+		for i, upper := range h.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				h.labelPairs(v.labels, "le", formatFloat(upper)), v.counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, h.labelPairs(v.labels, "le", "+Inf"), v.count,
+			h.name, h.labelPairs(v.labels), formatFloat(v.sum),
+			h.name, h.labelPairs(v.labels), v.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatFloat 按Prometheus的写法格式化数值
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// escapeHelp 转义 HELP 行中的反斜杠和换行
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel 转义标签值中的反斜杠、双引号和换行
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeText 返回 r 的文本格式输出
+func writeText(t *testing.T, r *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCounterVecText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "Requests handled,\nby command.", "command", "class")
+	c.Inc("guest-ping", "")
+	c.Add(2.5, "guest-exec", "GenericError")
+	c.Inc("guest-ping", "")
+
+	want := `# HELP test_requests_total Requests handled,\nby command.
+# TYPE test_requests_total counter
+test_requests_total{command="guest-exec",class="GenericError"} 2.5
+test_requests_total{command="guest-ping",class=""} 2
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("输出 =\n%s\n期望\n%s", got, want)
+	}
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Total.")
+
+	// 首次计数前输出0
+	want := "# HELP test_total Total.\n# TYPE test_total counter\ntest_total 0\n"
+	if got := writeText(t, r); got != want {
+		t.Errorf("输出 = %q, 期望 %q", got, want)
+	}
+	c.Inc()
+	if got := writeText(t, r); !strings.HasSuffix(got, "\ntest_total 1\n") {
+		t.Errorf("计数后输出 = %q", got)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", `Help with \ backslash.`, "value")
+	c.Inc("a\"b\\c\nd")
+
+	want := `# HELP test_total Help with \\ backslash.
+# TYPE test_total counter
+test_total{value="a\"b\\c\nd"} 1
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("输出 =\n%s\n期望\n%s", got, want)
+	}
+}
+
+func TestHistogramVecText(t *testing.T) {
+	r := NewRegistry()
+	// 桶上限乱序给出时按递增顺序输出
+	h := r.NewHistogramVec("test_duration_seconds", "Duration.", []float64{1, 0.1, 10}, "command")
+	h.Observe(0.05, "guest-ping")
+	h.Observe(0.1, "guest-ping")
+	h.Observe(2, "guest-ping")
+	h.Observe(60, "guest-ping")
+	h.Observe(0.5, "guest-exec")
+
+	want := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{command="guest-exec",le="0.1"} 0
+test_duration_seconds_bucket{command="guest-exec",le="1"} 1
+test_duration_seconds_bucket{command="guest-exec",le="10"} 1
+test_duration_seconds_bucket{command="guest-exec",le="+Inf"} 1
+test_duration_seconds_sum{command="guest-exec"} 0.5
+test_duration_seconds_count{command="guest-exec"} 1
+test_duration_seconds_bucket{command="guest-ping",le="0.1"} 2
+test_duration_seconds_bucket{command="guest-ping",le="1"} 2
+test_duration_seconds_bucket{command="guest-ping",le="10"} 3
+test_duration_seconds_bucket{command="guest-ping",le="+Inf"} 4
+test_duration_seconds_sum{command="guest-ping"} 62.15
+test_duration_seconds_count{command="guest-ping"} 4
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("输出 =\n%s\n期望\n%s", got, want)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_seconds", "Seconds.", []float64{1})
+	h.Observe(3)
+
+	want := `# HELP test_seconds Seconds.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="1"} 0
+test_seconds_bucket{le="+Inf"} 1
+test_seconds_sum 3
+test_seconds_count 1
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("输出 =\n%s\n期望\n%s", got, want)
+	}
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	c := NewRegistry().NewCounterVec("test_total", "Total.", "command")
+	defer func() {
+		if recover() == nil {
+			t.Error("标签值数量不符时没有panic")
+		}
+	}()
+	c.Inc("a", "b")
+}
+
+func TestListenRefusesNonLoopback(t *testing.T) {
+	for _, address := range []string{
+		"0.0.0.0:0",
+		":0",
+		"[::]:0",
+		"192.0.2.1:9101",
+		"example.com:9101",
+	} {
+		if s, err := Listen(address, NewRegistry()); err == nil {
+			s.Close()
+			t.Errorf("Listen(%q) 成功，期望只接受回环地址", address)
+		}
+	}
+}
+
+func TestListenServesMetrics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Total.").Inc()
+
+	s, err := Listen("127.0.0.1:0", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	resp, err := http.Get("http://" + s.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(string(body), "test_total 1\n") {
+		t.Errorf("响应 = %q", body)
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	s, err := Listen(unixPrefix+path, NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	client := http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", path) },
+	}}
+	resp, err := client.Get("http://metrics/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("状态码 = %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixPrefix 以Unix套接字监听时地址的前缀
+const unixPrefix = "unix:"
+
+// Server 导出指标的HTTP服务
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+	path     string
+}
+
+// Listen 在 address 上导出 registry 中的指标，路径为 /metrics。
+// address 为 "unix:/path/to.sock" 时监听Unix套接字（权限0600），
+// 否则必须是回环地址，如 "127.0.0.1:9101"，指标不会暴露到虚拟机之外。
+func Listen(address string, registry *Registry) (*Server, error) {
+	var (
+		listener net.Listener
+		path     string
+		err      error
+	)
+	if strings.HasPrefix(address, unixPrefix) {
+		path = strings.TrimPrefix(address, unixPrefix)
+		if path == "" {
+			return nil, errors.New("Unix套接字路径为空")
+		}
+		// 清理上次运行留下的套接字文件
+		if info, statErr := os.Lstat(path); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		listener, err = net.Listen("unix", path)
+		if err == nil {
+			err = os.Chmod(path, 0600)
+		}
+	} else {
+		if err := checkLoopback(address); err != nil {
+			return nil, err
+		}
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		if listener != nil {
+			listener.Close()
+		}
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.WriteText(w)
+	})
+
+	s := &Server{
+		listener: listener,
+		path:     path,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// checkLoopback 检查TCP监听地址是否为回环地址
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("指标只能在回环地址或Unix套接字上导出: %s", address)
+	}
+	return nil
+}
+
+// Addr 返回实际监听的地址
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close 停止导出指标，并删除Unix套接字文件
+func (s *Server) Close() error {
+	err := s.server.Close()
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+	return err
+}
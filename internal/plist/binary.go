@@ -0,0 +1,332 @@
+package plist
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf16"
+)
+
+// 二进制属性列表的结构：
+//
+//	文件头 "bplist00"
+//	对象区，每个对象以1字节标记开头，高4位为类型，低4位为长度或子类型
+//	偏移表，每项 offsetIntSize 字节，记录各对象在文件中的位置
+//	32字节的尾部信息
+
+// trailerSize 二进制属性列表尾部信息的长度
+const trailerSize = 32
+
+// plistEpoch 二进制属性列表中日期的起点（2001-01-01 UTC）
+var plistEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var errCorrupt = errors.New("plist: 二进制属性列表已损坏")
+
+// binaryDecoder 二进制属性列表的解码状态
+type binaryDecoder struct {
+	data          []byte
+	offsets       []uint64
+	objectRefSize int
+
+	// decoding 记录正在解码的对象，用于检测循环引用
+	decoding map[uint64]bool
+}
+
+// unmarshalBinary 解码二进制格式的属性列表
+func unmarshalBinary(data []byte) (interface{}, error) {
+	if len(data) < len(binaryMagic)+trailerSize {
+		return nil, errCorrupt
+	}
+
+	trailer := data[len(data)-trailerSize:]
+	offsetIntSize := int(trailer[6])
+	objectRefSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:16])
+	topObject := binary.BigEndian.Uint64(trailer[16:24])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:32])
+
+	if !validIntSize(offsetIntSize) || !validIntSize(objectRefSize) {
+		return nil, errCorrupt
+	}
+	// 偏移表必须位于文件头之后、尾部信息之前
+	tableEnd := uint64(len(data) - trailerSize)
+	if offsetTableOffset < uint64(len(binaryMagic)) || offsetTableOffset > tableEnd ||
+		numObjects == 0 || numObjects > (tableEnd-offsetTableOffset)/uint64(offsetIntSize) ||
+		topObject >= numObjects {
+		return nil, errCorrupt
+	}
+
+	d := &binaryDecoder{
+		data:          data[:offsetTableOffset],
+		offsets:       make([]uint64, numObjects),
+		objectRefSize: objectRefSize,
+		decoding:      make(map[uint64]bool),
+	}
+	table := data[offsetTableOffset:]
+	for i := range d.offsets {
+		d.offsets[i] = readUint(table[i*offsetIntSize : (i+1)*offsetIntSize])
+	}
+
+	return d.object(topObject, 0)
+}
+
+// validIntSize 偏移和引用只能是1、2、4或8字节
+func validIntSize(size int) bool {
+	return size == 1 || size == 2 || size == 4 || size == 8
+}
+
+// readUint 读取大端序的无符号整数
+func readUint(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+// object 解码编号为 ref 的对象
+func (d *binaryDecoder) object(ref uint64, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("plist: 嵌套层数超过%d", maxDepth)
+	}
+	if ref >= uint64(len(d.offsets)) {
+		return nil, errCorrupt
+	}
+	offset := d.offsets[ref]
+	if offset < uint64(len(binaryMagic)) || offset >= uint64(len(d.data)) {
+		return nil, errCorrupt
+	}
+
+	marker := d.data[offset]
+	kind, info := marker>>4, marker&0x0F
+	pos := offset + 1
+
+	switch kind {
+	case 0x0:
+		switch info {
+		case 0x0:
+			return nil, nil
+		case 0x8:
+			return false, nil
+		case 0x9:
+			return true, nil
+		}
+		return nil, fmt.Errorf("plist: 未知的对象标记 0x%02x", marker)
+
+	case 0x1:
+		b, err := d.bytes(pos, 1<<info)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 8 {
+			// 128位整数只用于表示超出int64的无符号值，取低64位
+			b = b[len(b)-8:]
+		}
+		// 1、2、4字节的整数是无符号的，8字节的是有符号的，
+		// 按位转换为int64对两者都成立
+		return int64(readUint(b)), nil
+
+	case 0x2:
+		b, err := d.bytes(pos, 1<<info)
+		if err != nil {
+			return nil, err
+		}
+		switch len(b) {
+		case 4:
+			return float64(math.Float32frombits(uint32(readUint(b)))), nil
+		case 8:
+			return math.Float64frombits(readUint(b)), nil
+		}
+		return nil, errCorrupt
+
+	case 0x3:
+		if info != 0x3 {
+			return nil, fmt.Errorf("plist: 未知的对象标记 0x%02x", marker)
+		}
+		b, err := d.bytes(pos, 8)
+		if err != nil {
+			return nil, err
+		}
+		seconds := math.Float64frombits(readUint(b))
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return nil, errCorrupt
+		}
+		return plistEpoch.Add(time.Duration(seconds * float64(time.Second))), nil
+
+	case 0x4:
+		n, pos, err := d.length(info, pos)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.bytes(pos, n)
+		if err != nil {
+			return nil, err
+		}
+		// 与XML格式一致，空data解码为非nil的空切片
+		return append([]byte{}, b...), nil
+
+	case 0x5:
+		n, pos, err := d.length(info, pos)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.bytes(pos, n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+
+	case 0x6:
+		n, pos, err := d.length(info, pos)
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt/2 {
+			return nil, errCorrupt
+		}
+		b, err := d.bytes(pos, n*2)
+		if err != nil {
+			return nil, err
+		}
+		units := make([]uint16, n)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+		return string(utf16.Decode(units)), nil
+
+	case 0x8:
+		// UID只出现在NSKeyedArchiver的输出中，按整数返回
+		b, err := d.bytes(pos, int(info)+1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(readUint(b)), nil
+
+	case 0xA, 0xC:
+		n, pos, err := d.length(info, pos)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := d.refs(pos, n)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.enter(ref); err != nil {
+			return nil, err
+		}
+		defer d.leave(ref)
+
+		array := make([]interface{}, 0, n)
+		for _, r := range refs {
+			value, err := d.object(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+
+	case 0xD:
+		n, pos, err := d.length(info, pos)
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt/2 {
+			return nil, errCorrupt
+		}
+		refs, err := d.refs(pos, n*2)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.enter(ref); err != nil {
+			return nil, err
+		}
+		defer d.leave(ref)
+
+		dict := make(Dict, n)
+		for i := 0; i < n; i++ {
+			key, err := d.object(refs[i], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, errors.New("plist: dict的键不是字符串")
+			}
+			value, err := d.object(refs[n+i], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			dict[keyString] = value
+		}
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("plist: 未知的对象标记 0x%02x", marker)
+}
+
+// length 读取对象的元素个数，低4位为0xF时个数存放在随后的整数对象中
+// 返回个数和对象内容的起始位置
+func (d *binaryDecoder) length(info byte, pos uint64) (int, uint64, error) {
+	if info != 0x0F {
+		return int(info), pos, nil
+	}
+	b, err := d.bytes(pos, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if b[0]>>4 != 0x1 || b[0]&0x0F > 3 {
+		return 0, 0, errCorrupt
+	}
+	size := 1 << (b[0] & 0x0F)
+	b, err = d.bytes(pos+1, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	n := readUint(b)
+	if n > uint64(len(d.data)) {
+		// 每个元素至少占1字节，超过文件长度的个数必然是损坏的
+		return 0, 0, errCorrupt
+	}
+	return int(n), pos + 1 + uint64(size), nil
+}
+
+// refs 读取 n 个对象引用
+func (d *binaryDecoder) refs(pos uint64, n int) ([]uint64, error) {
+	if n > len(d.data)/d.objectRefSize {
+		return nil, errCorrupt
+	}
+	b, err := d.bytes(pos, n*d.objectRefSize)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]uint64, n)
+	for i := range refs {
+		refs[i] = readUint(b[i*d.objectRefSize : (i+1)*d.objectRefSize])
+	}
+	return refs, nil
+}
+
+// bytes 返回从 pos 开始的 n 个字节，越界时返回错误
+func (d *binaryDecoder) bytes(pos uint64, n int) ([]byte, error) {
+	if n < 0 || pos > uint64(len(d.data)) || uint64(n) > uint64(len(d.data))-pos {
+		return nil, errCorrupt
+	}
+	return d.data[pos : pos+uint64(n)], nil
+}
+
+// enter 标记容器开始解码，容器引用自身时返回错误
+func (d *binaryDecoder) enter(ref uint64) error {
+	if d.decoding[ref] {
+		return errors.New("plist: 容器存在循环引用")
+	}
+	d.decoding[ref] = true
+	return nil
+}
+
+// leave 标记容器解码完成
+func (d *binaryDecoder) leave(ref uint64) {
+	delete(d.decoding, ref)
+}
@@ -0,0 +1,114 @@
+// Package plist 解码macOS属性列表（XML和二进制格式），
+// 用于解析 diskutil、ioreg 等系统工具的 -plist 输出。
+//
+// 解码结果使用以下Go类型表示：
+//
+//	dict    -> Dict
+//	array   -> []interface{}
+//	string  -> string
+//	integer -> int64
+//	real    -> float64
+//	true/false -> bool
+//	data    -> []byte
+//	date    -> time.Time
+package plist
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrUnsupportedFormat 数据既不是XML也不是二进制属性列表
+var ErrUnsupportedFormat = errors.New("plist: 不支持的属性列表格式")
+
+// maxDepth 限制容器的嵌套深度，防止恶意输入导致栈溢出
+const maxDepth = 512
+
+// binaryMagic 二进制属性列表的文件头
+var binaryMagic = []byte("bplist00")
+
+// Unmarshal 根据文件头自动识别格式并解码属性列表的根对象
+func Unmarshal(data []byte) (interface{}, error) {
+	if bytes.HasPrefix(data, binaryMagic) {
+		return unmarshalBinary(data)
+	}
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("<")) {
+		return unmarshalXML(trimmed)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// UnmarshalDict 解码根对象为dict的属性列表
+func UnmarshalDict(data []byte) (Dict, error) {
+	value, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := value.(Dict)
+	if !ok {
+		return nil, errors.New("plist: 根对象不是dict")
+	}
+	return dict, nil
+}
+
+// Dict 属性列表中的dict，提供按类型取值的辅助方法
+// 键不存在或类型不符时返回零值
+type Dict map[string]interface{}
+
+// String 返回字符串值
+func (d Dict) String(key string) string {
+	s, _ := d[key].(string)
+	return s
+}
+
+// Int 返回整数值
+func (d Dict) Int(key string) int64 {
+	switch v := d[key].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// Bool 返回布尔值
+func (d Dict) Bool(key string) bool {
+	b, _ := d[key].(bool)
+	return b
+}
+
+// Dict 返回嵌套的dict
+func (d Dict) Dict(key string) Dict {
+	v, _ := d[key].(Dict)
+	return v
+}
+
+// Array 返回数组
+func (d Dict) Array(key string) []interface{} {
+	v, _ := d[key].([]interface{})
+	return v
+}
+
+// Dicts 返回数组中所有dict类型的元素
+func (d Dict) Dicts(key string) []Dict {
+	var dicts []Dict
+	for _, item := range d.Array(key) {
+		if dict, ok := item.(Dict); ok {
+			dicts = append(dicts, dict)
+		}
+	}
+	return dicts
+}
+
+// Strings 返回数组中所有字符串类型的元素
+func (d Dict) Strings(key string) []string {
+	var strs []string
+	for _, item := range d.Array(key) {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
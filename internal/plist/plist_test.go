@@ -0,0 +1,567 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// readTestdata 读取 testdata 中的文件
+func readTestdata(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRecordedOutput(t *testing.T) {
+	// 同一份 `diskutil info -plist disk1s1` 和 `ioreg -a -l -r -c IOPCIDevice`
+	// 输出的XML和二进制两种编码
+	for _, name := range []string{"diskutil_info", "ioreg"} {
+		t.Run(name, func(t *testing.T) {
+			fromXML, err := Unmarshal(readTestdata(t, name+".plist"))
+			if err != nil {
+				t.Fatalf("XML: %v", err)
+			}
+			fromBinary, err := Unmarshal(readTestdata(t, name+".bplist"))
+			if err != nil {
+				t.Fatalf("二进制: %v", err)
+			}
+			if !reflect.DeepEqual(fromXML, fromBinary) {
+				t.Errorf("两种编码的解码结果不同:\nXML:    %v\n二进制: %v", fromXML, fromBinary)
+			}
+		})
+	}
+}
+
+func TestDiskutilInfo(t *testing.T) {
+	info, err := UnmarshalDict(readTestdata(t, "diskutil_info.bplist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.String("DeviceIdentifier"); got != "disk1s1" {
+		t.Errorf("DeviceIdentifier = %q", got)
+	}
+	if got := info.Int("Size"); got != 68509720576 {
+		t.Errorf("Size = %d", got)
+	}
+	if !info.Bool("Internal") || info.Bool("WholeDisk") {
+		t.Errorf("Internal = %v, WholeDisk = %v", info.Bool("Internal"), info.Bool("WholeDisk"))
+	}
+	stores := info.Dicts("APFSPhysicalStores")
+	if len(stores) != 1 || stores[0].String("APFSPhysicalStore") != "disk0s2" {
+		t.Errorf("APFSPhysicalStores = %v", info.Array("APFSPhysicalStores"))
+	}
+	if got := info.Strings("APFSVolumeRoles"); !reflect.DeepEqual(got, []string{"System"}) {
+		t.Errorf("APFSVolumeRoles = %q", got)
+	}
+	if info.Dict("SMARTDeviceSpecificKeysMayVaryNotGuaranteed") == nil {
+		t.Error("空dict解码为nil")
+	}
+}
+
+func TestIORegistry(t *testing.T) {
+	root, err := Unmarshal(readTestdata(t, "ioreg.bplist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, ok := root.([]interface{})
+	if !ok || len(devices) != 3 {
+		t.Fatalf("根对象 = %T，应为3个PCI设备的数组", root)
+	}
+	first := devices[0].(Dict)
+	if got := first.String("pcidebug"); got != "0:4:0" {
+		t.Errorf("pcidebug = %q", got)
+	}
+	reg, ok := first["reg"].([]byte)
+	if !ok || len(reg) != 40 || binary.LittleEndian.Uint32(reg) != 4<<11 {
+		t.Errorf("reg = %x", first["reg"])
+	}
+	name, _ := first["name"].([]byte)
+	if string(name) != "pci1af4,1001\x00" {
+		t.Errorf("name = %q", name)
+	}
+}
+
+func TestUnmarshalDictRejectsOtherRoots(t *testing.T) {
+	if _, err := UnmarshalDict(readTestdata(t, "ioreg.plist")); err == nil {
+		t.Error("根对象为数组时 UnmarshalDict() 没有返回错误")
+	}
+}
+
+// sampleValues 覆盖各种类型和长度边界的值
+func sampleValues() []interface{} {
+	long := strings.Repeat("x", 300)
+	manyItems := make([]interface{}, 20)
+	for i := range manyItems {
+		manyItems[i] = int64(i)
+	}
+	return []interface{}{
+		true,
+		false,
+		int64(0),
+		int64(255),
+		int64(65536),
+		int64(-1),
+		int64(math.MaxInt64),
+		int64(math.MinInt64),
+		1.5,
+		-0.25,
+		"",
+		"disk0s2",
+		long,
+		"Macintosh HD – Data ✓",
+		"emoji 💾",
+		[]byte{},
+		[]byte{0x00, 0x28, 0x00, 0x00},
+		time.Date(2024, 3, 28, 9, 14, 0, 0, time.UTC),
+		time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC),
+		[]interface{}{},
+		manyItems,
+		Dict{},
+		Dict{
+			"AllDisks": []interface{}{"disk0", "disk0s1"},
+			"Nested":   Dict{"Size": int64(68719476736), "Whole": true, "reg": []byte("data")},
+			long:       "key longer than 14 characters",
+		},
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, value := range sampleValues() {
+		got, err := Unmarshal(encodeBinary(value))
+		if err != nil {
+			t.Errorf("%#v: %v", value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("解码结果 %#v，应为 %#v", got, value)
+		}
+	}
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	for _, value := range sampleValues() {
+		got, err := Unmarshal(encodeXML(value))
+		if err != nil {
+			t.Errorf("%#v: %v", value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("解码结果 %#v，应为 %#v", got, value)
+		}
+	}
+}
+
+func TestBinaryIntegers(t *testing.T) {
+	tests := []struct {
+		name   string
+		object []byte
+		want   int64
+	}{
+		// 1、2、4字节的整数是无符号的
+		{"1字节", []byte{0x10, 0xFF}, 255},
+		{"2字节", []byte{0x11, 0xFF, 0xFF}, 65535},
+		{"4字节", []byte{0x12, 0xFF, 0xFF, 0xFF, 0xFF}, 4294967295},
+		{"8字节有符号", []byte{0x13, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE}, -2},
+		// 超出int64的无符号值以16字节保存，取低64位
+		{"16字节", append(make([]byte, 9), bytes.Repeat([]byte{0xFF}, 8)...), -1},
+		{"UID", []byte{0x80, 0x07}, 7},
+	}
+	tests[4].object[0] = 0x14
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmarshal(buildBinary([][]byte{tt.object}, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("解码结果 %v，应为 %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinaryTruncated(t *testing.T) {
+	for _, name := range []string{"diskutil_info.bplist", "ioreg.bplist"} {
+		data := readTestdata(t, name)
+		for n := 0; n < len(data); n++ {
+			if _, err := Unmarshal(data[:n]); err == nil && n >= len(binaryMagic) {
+				t.Errorf("%s 截断为 %d 字节时没有返回错误", name, n)
+			}
+		}
+	}
+}
+
+func TestXMLTruncated(t *testing.T) {
+	for _, name := range []string{"diskutil_info.plist", "ioreg.plist"} {
+		data := readTestdata(t, name)
+		// 根对象结束之后的截断不影响解码
+		end := bytes.LastIndex(data, []byte("</plist>")) - 1
+		for n := 0; n < end; n++ {
+			if _, err := Unmarshal(data[:n]); err == nil {
+				t.Errorf("%s 截断为 %d 字节时没有返回错误", name, n)
+			}
+		}
+	}
+}
+
+func TestXMLMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"空输入", ""},
+		{"不是属性列表", "Unable to run because unable to use the DiskManagement framework."},
+		{"空plist", `<plist version="1.0"></plist>`},
+		{"dict中缺少key", `<plist><dict><string>a</string></dict></plist>`},
+		{"key缺少值", `<plist><dict><key>a</key></dict></plist>`},
+		{"无效的整数", `<plist><integer>12abc</integer></plist>`},
+		{"超出范围的整数", `<plist><integer>99999999999999999999</integer></plist>`},
+		{"无效的实数", `<plist><real>one</real></plist>`},
+		{"无效的data", `<plist><data>!!!</data></plist>`},
+		{"无效的日期", `<plist><date>yesterday</date></plist>`},
+		{"未知的元素", `<plist><set/></plist>`},
+		{"文本元素中的子元素", `<plist><string>a<b/></string></plist>`},
+		{"未闭合的数组", `<plist><array><string>a</string>`},
+		{"未闭合的字符串", `<plist><string>abc`},
+		{"嵌套过深", "<plist>" + strings.Repeat("<array>", maxDepth+2) + strings.Repeat("</array>", maxDepth+2) + "</plist>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v, err := Unmarshal([]byte(tt.data)); err == nil {
+				t.Errorf("Unmarshal() = %#v，应返回错误", v)
+			}
+		})
+	}
+}
+
+func TestXMLVariants(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{"没有plist包装", `<dict><key>a</key><integer>1</integer></dict>`, Dict{"a": int64(1)}},
+		{"十六进制整数", `<plist><integer>0x10</integer></plist>`, int64(16)},
+		{"超出int64的无符号整数", `<plist><integer>18446744073709551615</integer></plist>`, int64(-1)},
+		{"带换行的data", "<plist><data>\n\tAAEC\n\tAw==\n</data></plist>", []byte{0, 1, 2, 3}},
+		{"空数组", `<plist><array/></plist>`, []interface{}{}},
+		{"注释", `<plist><!-- ioreg --><true/></plist>`, true},
+		{"转义字符", `<plist><string>a &amp; b &lt;c&gt;</string></plist>`, "a & b <c>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmarshal([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("解码结果 %#v，应为 %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinaryCorrupt(t *testing.T) {
+	// 对象0为包含对象1的数组，对象1为字符串 "ab"
+	objects := [][]byte{{0xA1, 0x01}, {0x52, 'a', 'b'}}
+	valid := buildBinary(objects, 0)
+	if got, err := Unmarshal(valid); err != nil || !reflect.DeepEqual(got, []interface{}{"ab"}) {
+		t.Fatalf("Unmarshal() = %v, %v", got, err)
+	}
+
+	// trailerAt 返回尾部信息中某个字段的位置
+	trailerAt := func(data []byte, field int) int { return len(data) - trailerSize + field }
+	tableOffset := int(binary.BigEndian.Uint64(valid[len(valid)-8:]))
+
+	tests := []struct {
+		name   string
+		mutate func(data []byte) []byte
+	}{
+		{"只有文件头", func(data []byte) []byte { return data[:len(binaryMagic)] }},
+		{"偏移长度为3", func(data []byte) []byte { data[trailerAt(data, 6)] = 3; return data }},
+		{"引用长度为0", func(data []byte) []byte { data[trailerAt(data, 7)] = 0; return data }},
+		{"没有对象", func(data []byte) []byte { putTrailer(data, 8, 0); return data }},
+		{"对象数超出偏移表", func(data []byte) []byte { putTrailer(data, 8, 1<<40); return data }},
+		{"根对象编号越界", func(data []byte) []byte { putTrailer(data, 16, 2); return data }},
+		{"偏移表位于文件头内", func(data []byte) []byte { putTrailer(data, 24, 4); return data }},
+		{"偏移表位于尾部信息内", func(data []byte) []byte { putTrailer(data, 24, uint64(len(data)-8)); return data }},
+		{"偏移表位置溢出", func(data []byte) []byte { putTrailer(data, 24, math.MaxUint64); return data }},
+		{"对象偏移指向文件头", func(data []byte) []byte { data[tableOffset] = 0; return data }},
+		{"对象偏移指向偏移表", func(data []byte) []byte { data[tableOffset+1] = byte(tableOffset); return data }},
+		{"对象偏移超出文件", func(data []byte) []byte { data[tableOffset+1] = 0xFF; return data }},
+		{"引用越界", func(data []byte) []byte { data[len(binaryMagic)+1] = 2; return data }},
+		{"数组引用自身", func(data []byte) []byte { data[len(binaryMagic)+1] = 0; return data }},
+		{"字符串长度越界", func(data []byte) []byte { data[len(binaryMagic)+2] = 0x5E; return data }},
+		{"数组长度超出文件", func(data []byte) []byte { data[len(binaryMagic)] = 0xAE; return data }},
+		{"未知的对象标记", func(data []byte) []byte { data[len(binaryMagic)+2] = 0x70; return data }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mutate(append([]byte(nil), valid...))
+			if v, err := Unmarshal(data); err == nil {
+				t.Errorf("Unmarshal() = %#v，应返回错误", v)
+			}
+		})
+	}
+}
+
+func TestBinaryLengths(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects [][]byte
+	}{
+		// 长度对象不是整数
+		{"长度不是整数", [][]byte{{0x5F, 0x52, 'a', 'b'}}},
+		// 长度对象的字节数超出文件
+		{"长度被截断", [][]byte{{0x5F, 0x13, 0x00}}},
+		// 长度接近 MaxInt 时乘2溢出
+		{"UTF-16长度溢出", [][]byte{{0x6F, 0x13, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}}},
+		{"dict长度溢出", [][]byte{{0xDF, 0x13, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}}},
+		{"dict的键不是字符串", [][]byte{{0xD1, 0x01, 0x01}, {0x10, 0x01}}},
+		{"3字节实数", [][]byte{{0x21, 0x00, 0x00}}},
+		{"日期为NaN", [][]byte{append([]byte{0x33}, be64(math.Float64bits(math.NaN()))...)}},
+		{"整数被截断", [][]byte{{0x13, 0x00}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v, err := Unmarshal(buildBinary(tt.objects, 0)); err == nil {
+				t.Errorf("Unmarshal() = %#v，应返回错误", v)
+			}
+		})
+	}
+}
+
+func TestBinaryDepthLimit(t *testing.T) {
+	// 每个数组包含下一个数组
+	n := maxDepth + 2
+	objects := make([][]byte, n)
+	for i := range objects[:n-1] {
+		objects[i] = []byte{0xA1, 0x00, 0x00}
+		binary.BigEndian.PutUint16(objects[i][1:], uint16(i+1))
+	}
+	objects[n-1] = []byte{0xA0}
+	if _, err := Unmarshal(buildBinaryRefSize(objects, 0, 2)); err == nil {
+		t.Error("嵌套过深时没有返回错误")
+	}
+}
+
+func TestBinarySharedObjects(t *testing.T) {
+	// 同一个对象可以被多次引用，只有循环引用是错误
+	objects := [][]byte{{0xA3, 0x01, 0x01, 0x01}, {0x51, 'x'}}
+	got, err := Unmarshal(buildBinary(objects, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"x", "x", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("解码结果 %#v，应为 %#v", got, want)
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, name := range []string{"diskutil_info.plist", "diskutil_info.bplist", "ioreg.plist", "ioreg.bplist"} {
+		f.Add(readTestdata(f, name))
+	}
+	for _, value := range sampleValues() {
+		f.Add(encodeBinary(value))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// 任意输入都不能导致panic
+		Unmarshal(data)
+	})
+}
+
+// be64 返回大端序的8字节整数
+func be64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// putTrailer 修改尾部信息中位于 field 的8字节字段
+func putTrailer(data []byte, field int, value uint64) {
+	binary.BigEndian.PutUint64(data[len(data)-trailerSize+field:], value)
+}
+
+// buildBinary 用1字节的引用和偏移组装二进制属性列表
+func buildBinary(objects [][]byte, top uint64) []byte {
+	return buildBinaryRefSize(objects, top, 1)
+}
+
+// buildBinaryRefSize 组装二进制属性列表，偏移占4字节
+func buildBinaryRefSize(objects [][]byte, top uint64, refSize int) []byte {
+	offsetSize := 1
+	var size int
+	for _, object := range objects {
+		size += len(object)
+	}
+	if len(binaryMagic)+size > 0xFF {
+		offsetSize = 4
+	}
+
+	data := append([]byte(nil), binaryMagic...)
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = len(data)
+		data = append(data, object...)
+	}
+	tableOffset := len(data)
+	for _, offset := range offsets {
+		if offsetSize == 1 {
+			data = append(data, byte(offset))
+		} else {
+			data = binary.BigEndian.AppendUint32(data, uint32(offset))
+		}
+	}
+	data = append(data, 0, 0, 0, 0, 0, 0, byte(offsetSize), byte(refSize))
+	data = append(data, be64(uint64(len(objects)))...)
+	data = append(data, be64(top)...)
+	return append(data, be64(uint64(tableOffset))...)
+}
+
+// encodeBinary 测试用的二进制编码器，每个值编码为单独的对象，不共享重复的值
+func encodeBinary(value interface{}) []byte {
+	var objects [][]byte
+	var add func(v interface{}) int
+	add = func(v interface{}) int {
+		ref := len(objects)
+		objects = append(objects, nil)
+
+		var b []byte
+		switch v := v.(type) {
+		case nil:
+			b = []byte{0x00}
+		case bool:
+			b = []byte{0x08}
+			if v {
+				b = []byte{0x09}
+			}
+		case int64:
+			b = append([]byte{0x13}, be64(uint64(v))...)
+		case float64:
+			b = append([]byte{0x23}, be64(math.Float64bits(v))...)
+		case time.Time:
+			b = append([]byte{0x33}, be64(math.Float64bits(v.Sub(plistEpoch).Seconds()))...)
+		case []byte:
+			b = append(binaryHeader(0x4, len(v)), v...)
+		case string:
+			if ascii := strings.IndexFunc(v, func(r rune) bool { return r > 0x7F }) < 0; ascii {
+				b = append(binaryHeader(0x5, len(v)), v...)
+			} else {
+				units := utf16.Encode([]rune(v))
+				b = binaryHeader(0x6, len(units))
+				for _, u := range units {
+					b = binary.BigEndian.AppendUint16(b, u)
+				}
+			}
+		case []interface{}:
+			b = binaryHeader(0xA, len(v))
+			for _, item := range v {
+				b = binary.BigEndian.AppendUint16(b, uint16(add(item)))
+			}
+		case Dict:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			b = binaryHeader(0xD, len(v))
+			for _, key := range keys {
+				b = binary.BigEndian.AppendUint16(b, uint16(add(key)))
+			}
+			for _, key := range keys {
+				b = binary.BigEndian.AppendUint16(b, uint16(add(v[key])))
+			}
+		default:
+			panic("不支持的类型")
+		}
+		objects[ref] = b
+		return ref
+	}
+	add(value)
+	return buildBinaryRefSize(objects, 0, 2)
+}
+
+// binaryHeader 返回对象标记，长度不小于15时随后写入长度对象
+func binaryHeader(kind byte, n int) []byte {
+	if n < 0x0F {
+		return []byte{kind<<4 | byte(n)}
+	}
+	return append([]byte{kind<<4 | 0x0F, 0x13}, be64(uint64(n))...)
+}
+
+// encodeXML 测试用的XML编码器
+func encodeXML(value interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">` + "\n")
+	var write func(v interface{})
+	text := func(tag, s string) {
+		b.WriteString("<" + tag + ">")
+		xml.EscapeText(&b, []byte(s))
+		b.WriteString("</" + tag + ">\n")
+	}
+	write = func(v interface{}) {
+		switch v := v.(type) {
+		case bool:
+			if v {
+				b.WriteString("<true/>\n")
+			} else {
+				b.WriteString("<false/>\n")
+			}
+		case int64:
+			text("integer", strconv.FormatInt(v, 10))
+		case float64:
+			text("real", strconv.FormatFloat(v, 'g', -1, 64))
+		case time.Time:
+			text("date", v.UTC().Format(time.RFC3339))
+		case []byte:
+			text("data", base64.StdEncoding.EncodeToString(v))
+		case string:
+			text("string", v)
+		case []interface{}:
+			b.WriteString("<array>\n")
+			for _, item := range v {
+				write(item)
+			}
+			b.WriteString("</array>\n")
+		case Dict:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			b.WriteString("<dict>\n")
+			for _, key := range keys {
+				text("key", key)
+				write(v[key])
+			}
+			b.WriteString("</dict>\n")
+		default:
+			panic("不支持的类型")
+		}
+	}
+	write(value)
+	b.WriteString("</plist>\n")
+	return b.Bytes()
+}
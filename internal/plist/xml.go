@@ -0,0 +1,195 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unmarshalXML 解码XML格式的属性列表
+func unmarshalXML(data []byte) (interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// 属性列表的DOCTYPE引用外部DTD，不需要解析
+	decoder.Strict = false
+
+	for {
+		start, err := nextStart(decoder)
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("plist: 缺少根对象")
+			}
+			return nil, err
+		}
+		if start.Name.Local == "plist" {
+			// <plist> 内部的第一个元素是根对象
+			value, err := decodeXMLNext(decoder, 0)
+			if err == errEndOfContainer {
+				return nil, fmt.Errorf("plist: 缺少根对象")
+			}
+			return value, err
+		}
+		// 没有 <plist> 包装时，第一个元素即为根对象
+		return decodeXMLValue(decoder, start, 0)
+	}
+}
+
+// errEndOfContainer 读到了容器的结束标签
+var errEndOfContainer = fmt.Errorf("plist: 容器结束")
+
+// nextStart 跳过注释、处理指令等内容，返回下一个开始标签
+func nextStart(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, errEndOfContainer
+		}
+	}
+}
+
+// decodeXMLNext 读取并解码下一个元素
+// 遇到父容器的结束标签时返回 errEndOfContainer
+func decodeXMLNext(decoder *xml.Decoder, depth int) (interface{}, error) {
+	start, err := nextStart(decoder)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return decodeXMLValue(decoder, start, depth)
+}
+
+// decodeXMLValue 解码以 start 开始的元素
+func decodeXMLValue(decoder *xml.Decoder, start xml.StartElement, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("plist: 嵌套层数超过%d", maxDepth)
+	}
+
+	switch start.Name.Local {
+	case "dict":
+		dict := make(Dict)
+		for {
+			keyStart, err := nextStart(decoder)
+			if err == errEndOfContainer {
+				return dict, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if keyStart.Name.Local != "key" {
+				return nil, fmt.Errorf("plist: dict中应为<key>，实际为<%s>", keyStart.Name.Local)
+			}
+			key, err := elementText(decoder)
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeXMLNext(decoder, depth+1)
+			if err == errEndOfContainer {
+				return nil, fmt.Errorf("plist: 键 %q 缺少值", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = value
+		}
+
+	case "array":
+		array := []interface{}{}
+		for {
+			value, err := decodeXMLNext(decoder, depth+1)
+			if err == errEndOfContainer {
+				return array, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+
+	case "true", "false":
+		if err := decoder.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	text, err := elementText(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "integer":
+		text = strings.TrimSpace(text)
+		if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+			return n, nil
+		}
+		// 超出int64范围的无符号整数按位保留
+		n, err := strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("plist: 无效的整数 %q", text)
+		}
+		return int64(n), nil
+	case "real":
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("plist: 无效的实数 %q", text)
+		}
+		return f, nil
+	case "data":
+		clean := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+				return -1
+			}
+			return r
+		}, text)
+		b, err := base64.StdEncoding.DecodeString(clean)
+		if err != nil {
+			return nil, fmt.Errorf("plist: 无效的data: %v", err)
+		}
+		return b, nil
+	case "date":
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("plist: 无效的日期 %q", text)
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("plist: 未知的元素 <%s>", start.Name.Local)
+	}
+}
+
+// elementText 读取当前元素的文本内容，直到其结束标签
+func elementText(decoder *xml.Decoder) (string, error) {
+	var b strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.StartElement:
+			return "", fmt.Errorf("plist: <%s> 不应出现在文本元素中", t.Name.Local)
+		case xml.EndElement:
+			return b.String(), nil
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// deviceReadTimeout 设备读取超时 - 使用短超时以便快速响应退出信号
+const deviceReadTimeout = 1 * time.Second
+
+// deviceWriteTimeout 设备写入超时
+const deviceWriteTimeout = 10 * time.Second
+
+func init() {
+	Register("virtio-serial", NewVirtioSerial)
+	Register("isa-serial", NewISASerial)
+}
+
+// DeviceTransport 基于字符设备的传输通道（virtio-serial、isa-serial）
+type DeviceTransport struct {
+	path   string
+	detect bool
+	serial bool
+	device *os.File
+	isOpen bool
+	mutex  sync.RWMutex
+}
+
+// NewVirtioSerial 创建virtio-serial传输通道，未指定路径时自动检测设备
+func NewVirtioSerial(spec Spec) (Transport, error) {
+	return &DeviceTransport{
+		path:   spec.Path,
+		detect: spec.Path == "",
+	}, nil
+}
+
+// NewISASerial 创建ISA串口传输通道
+func NewISASerial(spec Spec) (Transport, error) {
+	if spec.Path == "" {
+		return nil, &Error{Code: "InvalidPath", Message: "isa-serial需要指定串口设备路径"}
+	}
+	return &DeviceTransport{
+		path:   spec.Path,
+		serial: true,
+	}, nil
+}
+
+// DetectDevice 自动检测virtio设备
+func DetectDevice() (string, error) {
+	possiblePaths := []string{
+		// QEMU Guest Agent 标准设备路径
+		"/dev/cu.org.qemu.guest_agent.0",
+		"/dev/tty.org.qemu.guest_agent.0",
+		// 通用virtio设备路径
+		"/dev/cu.virtio-console.0",
+		"/dev/tty.virtio-console.0",
+		"/dev/cu.virtio-serial",
+		"/dev/tty.virtio-serial",
+		"/dev/cu.virtio-port",
+		"/dev/tty.virtio-port",
+		// 其他可能的QEMU设备路径
+		"/dev/cu.qemu-guest-agent",
+		"/dev/tty.qemu-guest-agent",
+	}
+
+	logrus.Debug("正在检测virtio设备...")
+	for _, path := range possiblePaths {
+		logrus.WithField("path", path).Debug("检查设备路径")
+		if stat, err := os.Stat(path); err == nil {
+			// 检查是否为字符设备
+			if stat.Mode()&os.ModeCharDevice != 0 {
+				logrus.WithField("device", path).Info("检测到virtio设备")
+				return path, nil
+			} else {
+				logrus.WithField("path", path).Debug("路径存在但不是字符设备")
+			}
+		} else {
+			logrus.WithField("path", path).WithError(err).Debug("设备路径不存在")
+		}
+	}
+
+	return "", fmt.Errorf("未找到可用的virtio设备，已检查的路径: %v", possiblePaths)
+}
+
+// Open 打开设备
+func (t *DeviceTransport) Open() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.isOpen {
+		return ErrAlreadyOpen
+	}
+
+	// 如果没有指定设备路径，自动检测
+	if t.detect {
+		path, err := DetectDevice()
+		if err != nil {
+			return fmt.Errorf("检测设备失败: %v", err)
+		}
+		t.path = path
+	}
+
+	device, err := os.OpenFile(t.path, os.O_RDWR|syscallNoCTTY, 0)
+	if err != nil {
+		return fmt.Errorf("打开设备 %s 失败: %v", t.path, err)
+	}
+
+	if t.serial {
+		if err := makeRaw(device); err != nil {
+			device.Close()
+			return fmt.Errorf("配置串口 %s 失败: %v", t.path, err)
+		}
+	}
+
+	t.device = device
+	t.isOpen = true
+
+	logrus.WithField("device", t.path).Info("成功打开设备")
+	return nil
+}
+
+// Close 关闭设备
+func (t *DeviceTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.isOpen {
+		return nil
+	}
+
+	err := t.device.Close()
+	t.isOpen = false
+	logrus.WithField("device", t.path).Info("已关闭设备连接")
+	return err
+}
+
+// IsOpen 检查设备是否已打开
+func (t *DeviceTransport) IsOpen() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.isOpen
+}
+
+// Path 获取设备路径
+func (t *DeviceTransport) Path() string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.path
+}
+
+// Read 从设备读取数据
+func (t *DeviceTransport) Read(buffer []byte) (int, error) {
+	device, err := t.file()
+	if err != nil {
+		return 0, err
+	}
+
+	// 设备不支持超时时忽略错误，此时读取会一直阻塞到有数据或设备关闭
+	device.SetReadDeadline(time.Now().Add(deviceReadTimeout))
+
+	n, err := device.Read(buffer)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// Guest Agent的正常工作模式就是等待命令，超时是正常的
+			return n, ErrTimeout
+		}
+		if errors.Is(err, os.ErrClosed) {
+			return n, ErrClosed
+		}
+	}
+	return n, err
+}
+
+// Write 向设备写入数据
+func (t *DeviceTransport) Write(data []byte) (int, error) {
+	device, err := t.file()
+	if err != nil {
+		return 0, err
+	}
+
+	device.SetWriteDeadline(time.Now().Add(deviceWriteTimeout))
+	return device.Write(data)
+}
+
+// file 返回当前打开的设备文件
+func (t *DeviceTransport) file() (*os.File, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if !t.isOpen {
+		return nil, ErrClosed
+	}
+	return t.device, nil
+}
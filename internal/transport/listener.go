@@ -0,0 +1,167 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// connListener 面向连接的监听器，unix-listen和vsock-listen各自提供实现
+type connListener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+}
+
+// ListenTransport 监听型传输通道的通用实现
+// 同一时间只服务一个客户端，客户端断开后在下一次读取时等待新的连接
+type ListenTransport struct {
+	path     string
+	listen   func() (connListener, error)
+	listener connListener
+	conn     io.ReadWriteCloser
+	isOpen   bool
+	mutex    sync.Mutex
+}
+
+// Open 开始监听
+func (t *ListenTransport) Open() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.isOpen {
+		return ErrAlreadyOpen
+	}
+
+	listener, err := t.listen()
+	if err != nil {
+		return err
+	}
+
+	t.listener = listener
+	t.isOpen = true
+
+	logrus.WithField("path", t.path).Info("开始监听客户端连接")
+	return nil
+}
+
+// Close 关闭当前连接和监听器
+func (t *ListenTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.isOpen {
+		return nil
+	}
+
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+
+	err := t.listener.Close()
+	t.listener = nil
+	t.isOpen = false
+	return err
+}
+
+// IsOpen 检查是否正在监听
+func (t *ListenTransport) IsOpen() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.isOpen
+}
+
+// Path 获取监听地址
+func (t *ListenTransport) Path() string {
+	return t.path
+}
+
+// Read 从当前客户端读取数据，没有客户端时阻塞等待新的连接
+// 客户端断开时返回io.EOF，下一次读取会重新等待连接
+func (t *ListenTransport) Read(buffer []byte) (int, error) {
+	conn, err := t.acceptClient()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.dropClient(conn)
+		if isClosedError(err) || !t.IsOpen() {
+			return n, ErrClosed
+		}
+		logrus.WithField("path", t.path).Info("客户端已断开连接")
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 向当前客户端写入数据
+func (t *ListenTransport) Write(data []byte) (int, error) {
+	t.mutex.Lock()
+	conn := t.conn
+	isOpen := t.isOpen
+	t.mutex.Unlock()
+
+	if !isOpen {
+		return 0, ErrClosed
+	}
+	if conn == nil {
+		return 0, ErrNoClient
+	}
+	return conn.Write(data)
+}
+
+// acceptClient 返回当前客户端连接，没有时等待新的连接
+func (t *ListenTransport) acceptClient() (io.ReadWriteCloser, error) {
+	t.mutex.Lock()
+	if !t.isOpen {
+		t.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	if t.conn != nil {
+		conn := t.conn
+		t.mutex.Unlock()
+		return conn, nil
+	}
+	listener := t.listener
+	t.mutex.Unlock()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		if isClosedError(err) {
+			return nil, ErrClosed
+		}
+		return nil, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.isOpen {
+		conn.Close()
+		return nil, ErrClosed
+	}
+	t.conn = conn
+
+	logrus.WithField("path", t.path).Info("客户端已连接")
+	return conn, nil
+}
+
+// dropClient 关闭并丢弃指定的客户端连接
+func (t *ListenTransport) dropClient(conn io.ReadWriteCloser) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn == conn {
+		t.conn = nil
+	}
+	conn.Close()
+}
+
+// isClosedError 判断错误是否由关闭监听器或连接引起
+func isClosedError(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed)
+}
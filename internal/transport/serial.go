@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeRaw 将串口设置为原始模式，避免终端驱动改写或回显协议数据 - 参考官方实现
+func makeRaw(device *os.File) error {
+	// 使用SyscallConn而不是Fd()，避免文件被切换为阻塞模式而失去读取超时
+	rawConn, err := device.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ioctlErr error
+	err = rawConn.Control(func(fd uintptr) {
+		termios, err := unix.IoctlGetTermios(int(fd), ioctlGetTermios)
+		if err != nil {
+			ioctlErr = err
+			return
+		}
+
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cflag &^= unix.CSIZE | unix.PARENB
+		termios.Cflag |= unix.CS8
+		termios.Cc[unix.VMIN] = 1
+		termios.Cc[unix.VTIME] = 0
+
+		ioctlErr = unix.IoctlSetTermios(int(fd), ioctlSetTermios, termios)
+	})
+	if err != nil {
+		return err
+	}
+	return ioctlErr
+}
@@ -0,0 +1,9 @@
+package transport
+
+import "golang.org/x/sys/unix"
+
+const (
+	syscallNoCTTY   = unix.O_NOCTTY
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETA
+)
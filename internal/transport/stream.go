@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register("stdio", NewStdio)
+}
+
+// StreamTransport 基于任意读写流的传输通道
+// 用于测试模式（标准输入输出）以及内存管道
+type StreamTransport struct {
+	name   string
+	reader io.Reader
+	writer io.Writer
+	isOpen bool
+	eof    bool
+	mutex  sync.RWMutex
+}
+
+// NewStream 使用给定的读写流创建传输通道
+func NewStream(name string, reader io.Reader, writer io.Writer) *StreamTransport {
+	return &StreamTransport{
+		name:   name,
+		reader: reader,
+		writer: writer,
+	}
+}
+
+// NewStdio 创建使用标准输入输出的传输通道（测试模式）
+func NewStdio(spec Spec) (Transport, error) {
+	return NewStream("stdio", os.Stdin, os.Stdout), nil
+}
+
+// Open 打开传输通道
+func (t *StreamTransport) Open() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.isOpen && !t.eof {
+		return ErrAlreadyOpen
+	}
+	t.isOpen = true
+	t.eof = false
+
+	if t.name == "stdio" {
+		logrus.Info("测试模式: 使用标准输入输出模拟virtio设备")
+		logrus.Info("测试模式: 你可以手动输入JSON命令进行测试")
+		logrus.Info("测试模式: 示例命令:")
+		logrus.Info(`  {"execute":"guest-ping"}`)
+		logrus.Info(`  {"execute":"guest-info"}`)
+		logrus.Info(`  {"execute":"guest-sync","arguments":{"id":12345}}`)
+	}
+	return nil
+}
+
+// Close 关闭传输通道，底层流如果实现了io.Closer也会被关闭
+func (t *StreamTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.isOpen {
+		return nil
+	}
+	t.isOpen = false
+
+	if t.name == "stdio" {
+		return nil
+	}
+	if closer, ok := t.reader.(io.Closer); ok {
+		closer.Close()
+	}
+	if closer, ok := t.writer.(io.Closer); ok {
+		closer.Close()
+	}
+	return nil
+}
+
+// IsOpen 检查传输通道是否打开，输入流结束后视为已断开
+func (t *StreamTransport) IsOpen() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.isOpen && !t.eof
+}
+
+// Path 获取传输通道名称
+func (t *StreamTransport) Path() string {
+	return t.name
+}
+
+// Read 读取数据，输入流结束时返回io.EOF
+// 输入结束后仍然可以写入，以便发送尚未完成的命令的响应
+func (t *StreamTransport) Read(buffer []byte) (int, error) {
+	if !t.IsOpen() {
+		return 0, ErrClosed
+	}
+
+	n, err := t.reader.Read(buffer)
+	if err == io.EOF {
+		t.mutex.Lock()
+		t.eof = true
+		t.mutex.Unlock()
+	}
+	return n, err
+}
+
+// Write 写入数据
+func (t *StreamTransport) Write(data []byte) (int, error) {
+	t.mutex.RLock()
+	isOpen := t.isOpen
+	t.mutex.RUnlock()
+
+	if !isOpen {
+		return 0, ErrClosed
+	}
+	return t.writer.Write(data)
+}
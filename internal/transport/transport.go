@@ -0,0 +1,124 @@
+// Package transport 实现Agent与宿主机之间的通信传输层。
+// 所有传输方式（virtio-serial、isa-serial、unix-listen、vsock-listen、stdio）
+// 都实现同一个Transport接口，并按名称注册。
+package transport
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Transport 通信传输接口 - 参考官方实现的GAChannel
+type Transport interface {
+	// Open 打开传输通道
+	Open() error
+
+	// Close 关闭传输通道
+	Close() error
+
+	// Read 读取数据。超时返回ErrTimeout，对端断开返回io.EOF
+	Read(buffer []byte) (int, error)
+
+	// Write 写入数据
+	Write(data []byte) (int, error)
+
+	// IsOpen 检查传输通道是否打开
+	IsOpen() bool
+
+	// Path 获取传输通道路径
+	Path() string
+}
+
+// Spec 传输配置
+type Spec struct {
+	// Method 传输方式名称，例如 "virtio-serial"、"unix-listen"
+	Method string
+
+	// Path 设备路径、套接字路径或vsock地址（CID:PORT）
+	Path string
+
+	// SocketMode unix-listen模式下套接字文件的权限
+	SocketMode os.FileMode
+}
+
+// Factory 根据配置创建传输通道
+type Factory func(spec Spec) (Transport, error)
+
+// DefaultMethod 默认传输方式
+const DefaultMethod = "virtio-serial"
+
+// DefaultSocketMode Unix套接字的默认权限
+const DefaultSocketMode os.FileMode = 0600
+
+var (
+	registry      = make(map[string]Factory)
+	registryMutex sync.RWMutex
+)
+
+// Register 注册传输方式
+func Register(method string, factory Factory) {
+	if method == "" || factory == nil {
+		return
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[method] = factory
+}
+
+// New 根据配置创建传输通道
+func New(spec Spec) (Transport, error) {
+	if spec.Method == "" {
+		spec.Method = DefaultMethod
+	}
+
+	registryMutex.RLock()
+	factory, ok := registry[spec.Method]
+	registryMutex.RUnlock()
+
+	if !ok {
+		return nil, &Error{
+			Code:    "UnsupportedMethod",
+			Message: fmt.Sprintf("不支持的传输方式: %s（可用: %v）", spec.Method, Methods()),
+		}
+	}
+	return factory(spec)
+}
+
+// Methods 返回所有已注册的传输方式名称
+func Methods() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	methods := make([]string, 0, len(registry))
+	for method := range registry {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Error 传输错误
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	// ErrClosed 传输通道已关闭
+	ErrClosed = &Error{Code: "TransportClosed", Message: "传输通道已关闭"}
+
+	// ErrAlreadyOpen 传输通道已经打开
+	ErrAlreadyOpen = &Error{Code: "TransportAlreadyOpen", Message: "传输通道已经打开"}
+
+	// ErrNoClient 当前没有已连接的客户端
+	ErrNoClient = &Error{Code: "NoClient", Message: "没有已连接的客户端"}
+
+	// ErrTimeout 读取超时，传输通道本身仍然可用
+	ErrTimeout = &Error{Code: "ReadTimeout", Message: "读取超时"}
+)
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+func init() {
+	Register("unix-listen", NewUnixListen)
+}
+
+// NewUnixListen 创建Unix套接字监听传输通道
+func NewUnixListen(spec Spec) (Transport, error) {
+	if spec.Path == "" {
+		return nil, &Error{Code: "InvalidPath", Message: "unix-listen需要指定套接字路径"}
+	}
+
+	mode := spec.SocketMode
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+
+	return &ListenTransport{
+		path: spec.Path,
+		listen: func() (connListener, error) {
+			return listenUnix(spec.Path, mode)
+		},
+	}, nil
+}
+
+// unixListener 将net.Listener适配为connListener
+type unixListener struct {
+	net.Listener
+}
+
+// Accept 等待新的客户端连接
+func (l unixListener) Accept() (io.ReadWriteCloser, error) {
+	return l.Listener.Accept()
+}
+
+// listenUnix 创建Unix套接字并设置权限
+func listenUnix(path string, mode os.FileMode) (connListener, error) {
+	// 清理上次运行遗留的套接字文件
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, &Error{Code: "InvalidPath", Message: "路径已存在且不是套接字: " + path}
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return unixListener{listener}, nil
+}
@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openUnixListen 在临时目录中打开unix-listen传输通道，测试结束时关闭
+func openUnixListen(t *testing.T, spec Spec) Transport {
+	t.Helper()
+	spec.Method = "unix-listen"
+	if spec.Path == "" {
+		spec.Path = filepath.Join(t.TempDir(), "agent.sock")
+	}
+	tr, err := New(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+// readString 从传输通道读取一次数据
+func readString(t *testing.T, tr Transport) (string, error) {
+	t.Helper()
+	buffer := make([]byte, 64)
+	n, err := tr.Read(buffer)
+	return string(buffer[:n]), err
+}
+
+func TestUnixListenRequiresPath(t *testing.T) {
+	if _, err := New(Spec{Method: "unix-listen"}); err == nil {
+		t.Error("没有路径时 New() 没有返回错误")
+	}
+}
+
+func TestUnixListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+
+	// 模拟上次运行异常退出后遗留的套接字文件
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("遗留的套接字文件不存在: %v", err)
+	}
+
+	tr := openUnixListen(t, Spec{Path: path})
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("无法连接新的套接字: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	if got, err := readString(t, tr); err != nil || got != "ping" {
+		t.Errorf("Read() = %q, %v", got, err)
+	}
+}
+
+func TestUnixListenKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := New(Spec{Method: "unix-listen", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Open(); err == nil {
+		tr.Close()
+		t.Fatal("路径是普通文件时 Open() 没有返回错误")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("普通文件被修改: %q, %v", data, err)
+	}
+}
+
+func TestUnixListenSocketMode(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want os.FileMode
+	}{
+		{0, DefaultSocketMode},
+		{0660, 0660},
+	}
+
+	for _, tt := range tests {
+		tr := openUnixListen(t, Spec{SocketMode: tt.mode})
+		info, err := os.Stat(tr.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != tt.want {
+			t.Errorf("SocketMode %o: 套接字权限为 %v，应为 %o", tt.mode, info.Mode(), tt.want)
+		}
+	}
+}
+
+func TestUnixListenReacceptsAfterHangup(t *testing.T) {
+	tr := openUnixListen(t, Spec{})
+
+	first, err := net.Dial("unix", tr.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Write([]byte("one"))
+	if got, err := readString(t, tr); err != nil || got != "one" {
+		t.Fatalf("Read() = %q, %v", got, err)
+	}
+	if _, err := tr.Write([]byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(first, reply); err != nil || string(reply) != "reply" {
+		t.Fatalf("客户端收到 %q, %v", reply, err)
+	}
+
+	// 客户端断开后读取返回io.EOF，之后没有客户端可以写入
+	first.Close()
+	if _, err := readString(t, tr); err != io.EOF {
+		t.Fatalf("客户端断开后 Read() 错误为 %v，应为io.EOF", err)
+	}
+	if _, err := tr.Write([]byte("lost")); !errors.Is(err, ErrNoClient) {
+		t.Errorf("没有客户端时 Write() 错误为 %v，应为ErrNoClient", err)
+	}
+	if !tr.IsOpen() {
+		t.Fatal("客户端断开后传输通道不应关闭")
+	}
+
+	// 下一次读取等待并接受新的客户端
+	second, err := net.Dial("unix", tr.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.Write([]byte("two"))
+	if got, err := readString(t, tr); err != nil || got != "two" {
+		t.Errorf("重新连接后 Read() = %q, %v", got, err)
+	}
+}
+
+func TestUnixListenCloseUnblocksRead(t *testing.T) {
+	tr := openUnixListen(t, Spec{})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := readString(t, tr)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	tr.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("关闭后 Read() 错误为 %v，应为ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("关闭传输通道后 Read() 仍在等待连接")
+	}
+}
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	Register("vsock-listen", NewVsockListen)
+}
+
+// defaultVsockPort 未指定端口时使用的vsock端口
+const defaultVsockPort = 1234
+
+// NewVsockListen 创建vsock监听传输通道，路径格式为 "CID:PORT" 或 "PORT"
+func NewVsockListen(spec Spec) (Transport, error) {
+	cid, port, err := parseVsockAddress(spec.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListenTransport{
+		path: fmt.Sprintf("vsock:%d:%d", int32(cid), port),
+		listen: func() (connListener, error) {
+			return listenVsock(cid, port)
+		},
+	}, nil
+}
+
+// parseVsockAddress 解析vsock地址，CID缺省或为-1/any时监听所有CID
+func parseVsockAddress(path string) (uint32, uint32, error) {
+	cid := uint32(unix.VMADDR_CID_ANY)
+	portStr := path
+
+	if idx := strings.LastIndex(path, ":"); idx >= 0 {
+		cidStr := path[:idx]
+		portStr = path[idx+1:]
+		if cidStr != "" && cidStr != "-1" && cidStr != "any" {
+			value, err := strconv.ParseUint(cidStr, 10, 32)
+			if err != nil {
+				return 0, 0, &Error{Code: "InvalidPath", Message: "无效的vsock CID: " + cidStr}
+			}
+			cid = uint32(value)
+		}
+	}
+
+	if portStr == "" {
+		return cid, defaultVsockPort, nil
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		return 0, 0, &Error{Code: "InvalidPath", Message: "无效的vsock端口: " + portStr}
+	}
+	return cid, uint32(port), nil
+}
+
+// vsockListener 基于AF_VSOCK套接字的监听器
+// 套接字以非阻塞模式交给Go运行时轮询，关闭时可以立即唤醒阻塞的Accept
+type vsockListener struct {
+	file *os.File
+}
+
+// listenVsock 创建vsock套接字并开始监听
+func listenVsock(cid, port uint32) (connListener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("创建vsock套接字失败: %v", err)
+	}
+	unix.CloseOnExec(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: cid, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("绑定vsock地址失败: %v", err)
+	}
+	if err := unix.Listen(fd, 1); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("vsock监听失败: %v", err)
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return &vsockListener{file: os.NewFile(uintptr(fd), fmt.Sprintf("vsock:%d", port))}, nil
+}
+
+// Accept 等待新的客户端连接
+func (l *vsockListener) Accept() (io.ReadWriteCloser, error) {
+	rawConn, err := l.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var connFd int
+	var acceptErr error
+	err = rawConn.Read(func(fd uintptr) bool {
+		connFd, _, acceptErr = unix.Accept(int(fd))
+		return acceptErr != unix.EAGAIN && acceptErr != unix.EINTR
+	})
+	if err != nil {
+		return nil, err
+	}
+	if acceptErr != nil {
+		return nil, acceptErr
+	}
+
+	unix.CloseOnExec(connFd)
+	if err := unix.SetNonblock(connFd, true); err != nil {
+		unix.Close(connFd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(connFd), l.file.Name()), nil
+}
+
+// Close 关闭监听套接字
+func (l *vsockListener) Close() error {
+	return l.file.Close()
+}
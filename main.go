@@ -5,10 +5,16 @@ import (
 	"flag"
 	"fmt"
 	"mac-guest-agent/internal/agent"
+	"mac-guest-agent/internal/audit"
+	"mac-guest-agent/internal/commands"
+	"mac-guest-agent/internal/config"
+	"mac-guest-agent/internal/metrics"
+	"mac-guest-agent/internal/transport"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -16,24 +22,44 @@ import (
 )
 
 var (
-	version   = "1.1.0"
-	daemon    = flag.Bool("daemon", false, "运行为守护进程")
-	verbose   = flag.Bool("verbose", false, "启用详细日志")
-	device    = flag.String("device", "", "指定virtio设备路径")
-	testMode  = flag.Bool("test", false, "测试模式（使用标准输入输出模拟设备）")
-	install   = flag.Bool("install", false, "安装为系统服务")
-	uninstall = flag.Bool("uninstall", false, "卸载系统服务")
+	version    = "1.1.0"
+	daemon     = flag.Bool("daemon", false, "运行为守护进程")
+	verbose    = flag.Bool("verbose", false, "启用详细日志")
+	device     = flag.String("device", "", "指定virtio设备路径或Unix套接字路径")
+	method     = flag.String("method", transport.DefaultMethod, "通信方式: virtio-serial, isa-serial, unix-listen, vsock-listen, stdio")
+	sockMode   = flag.String("socket-mode", "0600", "unix-listen模式下套接字文件的权限（八进制）")
+	maxMsg     = flag.Int("max-message-size", agent.DefaultMaxMessageSize, "单条JSON消息的最大字节数")
+	cmdTimeout = flag.Duration("command-timeout", commands.DefaultTimeout, "命令执行的默认超时时间")
+	recordFix  = flag.String("record-fixtures", "", "将外部命令的输出记录到指定的JSON文件（用于离线测试）")
+	replayFix  = flag.String("replay-fixtures", "", "从指定的JSON文件回放外部命令的输出，而不实际执行命令")
+	configPath = flag.String("config", config.DefaultPath, "配置文件路径")
+	dumpConfig = flag.Bool("dump-config", false, "输出合并命令行参数后生效的配置，然后退出")
+	allowExec  = flag.Bool("allow-exec", false, "允许宿主机通过guest-exec在虚拟机中执行程序")
+	allowRPCs  = flag.String("allow-rpcs", "", "命令白名单，逗号分隔，非空时只允许列出的命令")
+	blockRPCs  = flag.String("block-rpcs", "", "命令黑名单，逗号分隔")
+	hookDir    = flag.String("fsfreeze-hook-dir", commands.DefaultFreezeHookDir, "文件系统冻结/解冻钩子脚本目录")
+	auditLog   = flag.String("audit-log", audit.DefaultPath, "审计日志文件路径（JSON Lines格式），为空时不记录")
+	metricsAt  = flag.String("metrics-listen", "", "导出Prometheus指标的地址（回环地址或 unix:/path），为空时不导出")
+	emulated   = flag.String("emulated", commands.EmulatedAdvertise.String(), "模拟实现的命令的处理策略: advertise, succeed, unsupported")
+	testMode   = flag.Bool("test", false, "测试模式（使用标准输入输出模拟设备）")
+	install    = flag.Bool("install", false, "安装为系统服务")
+	uninstall  = flag.Bool("uninstall", false, "卸载系统服务")
 )
 
 //go:embed configs/com.macos.guest-agent.plist
 var plistContent []byte
 
+//go:embed configs/mac-guest-agent.conf
+var configContent []byte
+
 const (
 	serviceName = "com.macos.guest-agent"
 	binaryPath  = "/usr/local/bin/mac-guest-agent"
 	plistPath   = "/Library/LaunchDaemons/com.macos.guest-agent.plist"
-	logPath     = "/var/log/mac-guest-agent.log"
+	logPath     = config.DefaultLogFile
 	sharePath   = "/usr/local/share/mac-guest-agent"
+
+	defaultSocketPath = "/var/run/mac-guest-agent.sock"
 )
 
 func main() {
@@ -50,13 +76,29 @@ func main() {
 		return
 	}
 
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载配置失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *dumpConfig {
+		if err := cfg.Dump(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "输出配置失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 配置日志
-	setupLogging()
+	setupLogging(cfg)
 
 	logrus.WithField("version", version).Info("macOS Guest Agent 启动中...")
 
-	// 检测QEMU环境（测试模式下跳过检测）
-	if !*testMode {
+	spec := transportSpec(cfg)
+
+	// 检测QEMU环境（测试模式和Unix套接字模式下跳过检测）
+	if spec.Method != "stdio" && spec.Method != "unix-listen" {
 		if !isRunningInQEMU() {
 			logrus.Error("检测到当前系统不是运行在QEMU虚拟化环境中")
 			logrus.Error("macOS Guest Agent 仅支持在QEMU虚拟机中运行")
@@ -71,17 +113,42 @@ func main() {
 		logrus.Fatal("Guest Agent需要root权限运行，请使用sudo")
 	}
 
-	// 创建Agent实例
-	var guestAgent *agent.Agent
-	var err error
-
 	if *testMode {
 		logrus.Info("运行在测试模式下")
-		guestAgent, err = agent.NewTestMode()
-	} else {
-		guestAgent, err = agent.New(*device)
 	}
 
+	if cfg.Exec.Enabled {
+		logrus.Warn("已启用guest-exec，宿主机可以以root身份执行任意程序")
+	}
+	commands.SetExecEnabled(cfg.Exec.Enabled)
+	commands.SetExecTimeout(cfg.Exec.Timeout)
+	commands.SetEmulatedPolicy(cfg.Commands.Emulated)
+	commands.SetFreezeHooks(commands.FreezeHookConfig{
+		Dir:       cfg.Fsfreeze.HookDir,
+		Timeout:   cfg.Fsfreeze.HookTimeout,
+		OnFailure: cfg.Fsfreeze.HookFailure,
+	})
+	commands.SetFreezeTimeout(cfg.Fsfreeze.Timeout)
+	checkCommandLists(cfg)
+	auditLogger := setupAudit(cfg)
+	metricsServer := setupMetrics(cfg)
+
+	recorder, err := setupRunner()
+	if err != nil {
+		logrus.WithError(err).Fatal("配置命令执行器失败")
+	}
+
+	// 创建Agent实例
+	guestAgent, err := agent.New(agent.Config{
+		Transport:       spec,
+		MaxMessageSize:  cfg.Transport.MaxMessageSize,
+		Workers:         cfg.Commands.Workers,
+		ReconnectDelay:  cfg.Transport.RetryDelay,
+		CommandTimeout:  cfg.Timeouts.Default,
+		CommandTimeouts: cfg.Timeouts.Commands,
+		AllowedRPCs:     cfg.Commands.AllowRPCs,
+		BlockedRPCs:     cfg.Commands.BlockRPCs,
+	})
 	if err != nil {
 		logrus.WithError(err).Fatal("创建Guest Agent失败")
 	}
@@ -99,27 +166,207 @@ func main() {
 
 	logrus.Info("Guest Agent已启动，等待命令...")
 
-	// 等待退出信号
-	<-sigChan
-	logrus.Info("收到退出信号，正在关闭...")
+	// 等待退出信号，stdio模式下输入结束时也退出
+	select {
+	case <-sigChan:
+		logrus.Info("收到退出信号，正在关闭...")
+	case <-guestAgent.Done():
+		logrus.Info("输入已结束，正在关闭...")
+	}
 
 	// 优雅关闭
 	guestAgent.Stop()
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	if auditLogger != nil {
+		auditLogger.Close()
+	}
+	if recorder != nil {
+		if err := recorder.Save(*recordFix); err != nil {
+			logrus.WithError(err).Error("保存命令输出记录失败")
+		} else {
+			logrus.WithField("file", *recordFix).Info("命令输出记录已保存")
+		}
+	}
 	logrus.Info("Guest Agent已停止")
 }
 
+// setupRunner 根据命令行参数配置外部命令的执行方式
+// 启用记录时返回记录器，以便退出时保存
+func setupRunner() (*commands.RecordingRunner, error) {
+	var runner commands.Runner = commands.ExecRunner{}
+	if *replayFix != "" {
+		replay, err := commands.LoadFixtureRunner(*replayFix)
+		if err != nil {
+			return nil, err
+		}
+		logrus.WithField("file", *replayFix).Info("回放模式：外部命令的输出来自记录文件")
+		runner = replay
+	}
+
+	var recorder *commands.RecordingRunner
+	if *recordFix != "" {
+		recorder = commands.NewRecordingRunner(runner)
+		runner = recorder
+	}
+
+	commands.SetRunner(runner)
+	return recorder, nil
+}
+
+// loadConfig 读取配置文件，并用显式指定的命令行参数覆盖其中的配置
+func loadConfig() (*config.Config, error) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	// 只有显式指定的配置文件才必须存在
+	cfg, err := config.Load(*configPath, set["config"])
+	if err != nil {
+		return nil, err
+	}
+
+	if set["daemon"] {
+		cfg.General.Daemon = *daemon
+	}
+	if set["verbose"] {
+		cfg.Logging.Verbose = *verbose
+	}
+	if set["method"] {
+		cfg.Transport.Method = *method
+	}
+	if set["device"] {
+		cfg.Transport.Path = *device
+	}
+	if set["socket-mode"] {
+		mode, err := strconv.ParseUint(*sockMode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("无效的套接字权限 %q: %v", *sockMode, err)
+		}
+		cfg.Transport.SocketMode = os.FileMode(mode)
+	}
+	if set["max-message-size"] {
+		cfg.Transport.MaxMessageSize = *maxMsg
+	}
+	if set["command-timeout"] {
+		cfg.Timeouts.Default = *cmdTimeout
+	}
+	if set["allow-exec"] {
+		cfg.Exec.Enabled = *allowExec
+	}
+	if set["allow-rpcs"] {
+		cfg.Commands.AllowRPCs = config.SplitList(*allowRPCs)
+	}
+	if set["block-rpcs"] {
+		cfg.Commands.BlockRPCs = config.SplitList(*blockRPCs)
+	}
+	if set["fsfreeze-hook-dir"] {
+		cfg.Fsfreeze.HookDir = *hookDir
+	}
+	if set["audit-log"] {
+		cfg.Audit.LogFile = *auditLog
+	}
+	if set["metrics-listen"] {
+		cfg.Metrics.Listen = *metricsAt
+	}
+	if set["emulated"] {
+		policy, err := commands.ParseEmulatedPolicy(*emulated)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Commands.Emulated = policy
+	}
+
+	// 测试模式总是使用标准输入输出
+	if *testMode {
+		cfg.Transport.Method = "stdio"
+	}
+	if cfg.Transport.Method == "unix-listen" && cfg.Transport.Path == "" {
+		cfg.Transport.Path = defaultSocketPath
+	}
+
+	return cfg, nil
+}
+
+// transportSpec 根据配置构建传输配置
+func transportSpec(cfg *config.Config) transport.Spec {
+	return transport.Spec{
+		Method:     cfg.Transport.Method,
+		Path:       cfg.Transport.Path,
+		SocketMode: cfg.Transport.SocketMode,
+	}
+}
+
+// checkCommandLists 检查白名单和黑名单中的命令是否存在
+// 名单由Agent在处理每个请求时执行
+func checkCommandLists(cfg *config.Config) {
+	for _, name := range append(cfg.Commands.AllowRPCs, cfg.Commands.BlockRPCs...) {
+		if _, ok := commands.LookupCommand(name); !ok {
+			logrus.WithField("command", name).Warn("配置中的命令不存在")
+		}
+	}
+}
+
+// setupAudit 打开审计日志并应用额外的参数隐去规则
+// 审计日志无法打开时只记录警告，Agent照常运行
+func setupAudit(cfg *config.Config) *audit.Logger {
+	for _, rule := range cfg.Audit.Redact {
+		command, arg, ok := strings.Cut(rule, ":")
+		if !ok {
+			logrus.WithField("rule", rule).Warn("无效的参数隐去规则，应为 命令名:参数名")
+			continue
+		}
+		if err := commands.SetArgumentSensitive(command, arg); err != nil {
+			logrus.WithError(err).WithField("rule", rule).Warn("无效的参数隐去规则")
+		}
+	}
+
+	if cfg.Audit.LogFile == "" {
+		return nil
+	}
+	logger, err := audit.Open(audit.Config{
+		Path:       cfg.Audit.LogFile,
+		MaxSize:    cfg.Audit.MaxSize,
+		MaxBackups: cfg.Audit.MaxBackups,
+	})
+	if err != nil {
+		logrus.WithError(err).Warn("无法打开审计日志，不记录审计日志")
+		return nil
+	}
+	commands.SetAuditLog(logger)
+	logrus.WithField("file", cfg.Audit.LogFile).Info("审计日志已启用")
+	return logger
+}
+
+// setupMetrics 启动指标导出服务
+// 无法监听时只记录警告，Agent照常运行
+func setupMetrics(cfg *config.Config) *metrics.Server {
+	if cfg.Metrics.Listen == "" {
+		return nil
+	}
+	server, err := metrics.Listen(cfg.Metrics.Listen, metrics.Default)
+	if err != nil {
+		logrus.WithError(err).Warn("无法导出指标")
+		return nil
+	}
+	logrus.WithField("address", cfg.Metrics.Listen).Info("指标导出已启用")
+	return server
+}
+
 // setupLogging 配置日志
-func setupLogging() {
+func setupLogging(cfg *config.Config) {
 	// 设置日志级别
-	if *verbose {
+	if cfg.Logging.Verbose {
 		logrus.SetLevel(logrus.DebugLevel)
 	} else {
 		logrus.SetLevel(logrus.InfoLevel)
 	}
 
 	// 如果运行为守护进程，将日志写入系统日志文件
-	if *daemon {
-		file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if cfg.General.Daemon {
+		file, err := os.OpenFile(cfg.Logging.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			logrus.WithError(err).Fatal("无法打开日志文件")
 		}
@@ -179,6 +426,12 @@ func installService() {
 		os.Exit(1)
 	}
 
+	// 安装示例配置文件
+	if err := installConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "安装配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+
 	// 创建日志文件
 	if err := createLogFile(); err != nil {
 		fmt.Fprintf(os.Stderr, "创建日志文件失败: %v\n", err)
@@ -193,7 +446,8 @@ func installService() {
 
 	fmt.Println("✓ macOS Guest Agent 系统服务安装成功!")
 	fmt.Printf("  - 可执行文件: %s\n", binaryPath)
-	fmt.Printf("  - 配置文件: %s\n", plistPath)
+	fmt.Printf("  - 服务配置: %s\n", plistPath)
+	fmt.Printf("  - 配置文件: %s\n", config.DefaultPath)
 	fmt.Printf("  - 日志文件: %s\n", logPath)
 	fmt.Println("")
 	fmt.Println("服务管理命令:")
@@ -245,6 +499,7 @@ func createDirectories() error {
 		"/usr/local/share",
 		sharePath,
 		filepath.Dir(logPath),
+		filepath.Dir(config.DefaultPath),
 	}
 
 	for _, dir := range dirs {
@@ -271,6 +526,21 @@ func installPlist() error {
 	return nil
 }
 
+// installConfig 安装示例配置文件，已存在的配置文件保持不变
+func installConfig() error {
+	if _, err := os.Stat(config.DefaultPath); err == nil {
+		fmt.Printf("保留现有配置文件: %s\n", config.DefaultPath)
+		return nil
+	}
+
+	if err := os.WriteFile(config.DefaultPath, configContent, 0644); err != nil {
+		return fmt.Errorf("写入配置文件失败: %v", err)
+	}
+
+	fmt.Printf("已将示例配置写入: %s\n", config.DefaultPath)
+	return nil
+}
+
 // createLogFile 创建日志文件
 func createLogFile() error {
 	fmt.Println("创建日志文件...")
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setFlags 设置命令行参数，测试结束时恢复原值
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	for name, value := range values {
+		previous := flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { flag.Set(name, previous) })
+	}
+}
+
+func TestFlagsOverrideConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.conf")
+	err := os.WriteFile(path, []byte(`[transport]
+method=unix-listen
+path=/tmp/agent.sock
+[logging]
+verbose=true
+[commands]
+block-rpcs=guest-exec
+[timeouts]
+default=1m
+guest-get-disks=90s
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 显式指定的参数即使等于默认值也优先于配置文件
+	setFlags(t, map[string]string{
+		"config":          path,
+		"verbose":         "false",
+		"command-timeout": "2m",
+		"block-rpcs":      "guest-shutdown,guest-suspend-disk",
+	})
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"verbose", cfg.Logging.Verbose, false},
+		{"command-timeout", cfg.Timeouts.Default, 2 * time.Minute},
+		{"block-rpcs", cfg.Commands.BlockRPCs, []string{"guest-shutdown", "guest-suspend-disk"}},
+		// 未指定的参数不覆盖配置文件
+		{"method", cfg.Transport.Method, "unix-listen"},
+		{"device", cfg.Transport.Path, "/tmp/agent.sock"},
+		{"command timeouts", cfg.Timeouts.Commands, map[string]time.Duration{"guest-get-disks": 90 * time.Second}},
+	}
+	for _, check := range checks {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("%s = %v, 期望 %v", check.name, check.got, check.want)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+// Package protocol defines the QMP messages exchanged between the host and
+// the agent, and the arguments and results of its commands. It is shared by
+// the agent and the host-side client package.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// QMPRequest represents a QMP request
+type QMPRequest struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+	ID        interface{} `json:"id,omitempty"`
+}
+
+// QMPResponse represents a QMP response
+type QMPResponse struct {
+	Return interface{} `json:"return,omitempty"`
+	Error  *QMPError   `json:"error,omitempty"`
+	ID     interface{} `json:"id,omitempty"`
+}
+
+// QMPError represents a QMP error
+type QMPError struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+// QMP error classes reported to the host
+const (
+	ErrorClassGeneric         = "GenericError"
+	ErrorClassCommandNotFound = "CommandNotFound"
+	ErrorClassCommandDisabled = "CommandDisabled"
+	ErrorClassTimeout         = "Timeout"
+	ErrorClassUnsupported     = "Unsupported"
+
+	// Argument validation errors, as reported by older QEMU releases
+	ErrorClassMissingParameter      = "MissingParameter"
+	ErrorClassInvalidParameter      = "InvalidParameter"
+	ErrorClassInvalidParameterType  = "InvalidParameterType"
+	ErrorClassInvalidParameterValue = "InvalidParameterValue"
+)
+
+// Command-specific argument structures
+type PingArgs struct{}
+
+type InfoArgs struct{}
+
+type SyncArgs struct {
+	ID int64 `json:"id"`
+}
+
+type ShutdownArgs struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+// Standard QEMU Guest Agent structures
+
+// GuestAgentCommandInfo represents information about a guest agent command
+type GuestAgentCommandInfo struct {
+	Name            string `json:"name"`
+	Enabled         bool   `json:"enabled"`
+	SuccessResponse bool   `json:"success-response"`
+
+	// Support is "native", "emulated" or "unsupported". It is not part of
+	// the upstream schema; clients that do not know it ignore it.
+	Support string `json:"support,omitempty"`
+}
+
+// GuestAgentInfo represents information about the guest agent
+type GuestAgentInfo struct {
+	Version           string                  `json:"version"`
+	SupportedCommands []GuestAgentCommandInfo `json:"supported_commands"`
+}
+
+// GuestOSInfo represents guest operating system information
+type GuestOSInfo struct {
+	KernelRelease string `json:"kernel-release,omitempty"`
+	KernelVersion string `json:"kernel-version,omitempty"`
+	Machine       string `json:"machine,omitempty"`
+	ID            string `json:"id,omitempty"`
+	Name          string `json:"name,omitempty"`
+	PrettyName    string `json:"pretty-name,omitempty"`
+	Version       string `json:"version,omitempty"`
+	VersionID     string `json:"version-id,omitempty"`
+	Variant       string `json:"variant,omitempty"`
+	VariantID     string `json:"variant-id,omitempty"`
+}
+
+// GuestHostName represents the guest hostname
+type GuestHostName struct {
+	HostName string `json:"host-name"`
+}
+
+// GuestUser represents a logged-in user
+type GuestUser struct {
+	User      string  `json:"user"`
+	Domain    string  `json:"domain,omitempty"`
+	LoginTime float64 `json:"login-time"`
+}
+
+// GuestTimezone represents timezone information
+type GuestTimezone struct {
+	Zone   string `json:"zone,omitempty"`
+	Offset int    `json:"offset"`
+}
+
+// GuestIpAddressType represents IP address type
+type GuestIpAddressType string
+
+const (
+	IPv4 GuestIpAddressType = "ipv4"
+	IPv6 GuestIpAddressType = "ipv6"
+)
+
+// GuestIpAddress represents an IP address
+type GuestIpAddress struct {
+	IPAddress     string             `json:"ip-address"`
+	IPAddressType GuestIpAddressType `json:"ip-address-type"`
+	Prefix        int                `json:"prefix"`
+}
+
+// GuestNetworkInterfaceStat represents network interface statistics
+type GuestNetworkInterfaceStat struct {
+	RxBytes   int64 `json:"rx-bytes"`
+	RxPackets int64 `json:"rx-packets"`
+	RxErrs    int64 `json:"rx-errs"`
+	RxDropped int64 `json:"rx-dropped"`
+	TxBytes   int64 `json:"tx-bytes"`
+	TxPackets int64 `json:"tx-packets"`
+	TxErrs    int64 `json:"tx-errs"`
+	TxDropped int64 `json:"tx-dropped"`
+}
+
+// GuestNetworkInterface represents a network interface
+type GuestNetworkInterface struct {
+	Name            string                     `json:"name"`
+	HardwareAddress string                     `json:"hardware-address,omitempty"`
+	IPAddresses     []GuestIpAddress           `json:"ip-addresses,omitempty"`
+	Statistics      *GuestNetworkInterfaceStat `json:"statistics,omitempty"`
+}
+
+// GuestLogicalProcessor represents a logical processor
+type GuestLogicalProcessor struct {
+	LogicalID  int  `json:"logical-id"`
+	Online     bool `json:"online"`
+	CanOffline bool `json:"can-offline,omitempty"`
+}
+
+// GuestFsfreezeStatus represents filesystem freeze status
+type GuestFsfreezeStatus string
+
+const (
+	FsfreezeStatusThawed GuestFsfreezeStatus = "thawed"
+	FsfreezeStatusFrozen GuestFsfreezeStatus = "frozen"
+)
+
+// GuestFilesystemTrimResult represents filesystem trim result
+type GuestFilesystemTrimResult struct {
+	Path    string `json:"path"`
+	Error   string `json:"error,omitempty"`
+	Trimmed int64  `json:"trimmed,omitempty"`
+	Minimum int64  `json:"minimum,omitempty"`
+}
+
+// GuestFilesystemTrimResponse represents filesystem trim response
+type GuestFilesystemTrimResponse struct {
+	Paths []GuestFilesystemTrimResult `json:"paths"`
+}
+
+// GuestDiskBusType represents disk bus type
+type GuestDiskBusType string
+
+const (
+	DiskBusIDE     GuestDiskBusType = "ide"
+	DiskBusFDC     GuestDiskBusType = "fdc"
+	DiskBusSCSI    GuestDiskBusType = "scsi"
+	DiskBusVirtio  GuestDiskBusType = "virtio"
+	DiskBusXen     GuestDiskBusType = "xen"
+	DiskBusUSB     GuestDiskBusType = "usb"
+	DiskBusSATA    GuestDiskBusType = "sata"
+	DiskBusSD      GuestDiskBusType = "sd"
+	DiskBusNVMe    GuestDiskBusType = "nvme"
+	DiskBusUnknown GuestDiskBusType = "unknown"
+)
+
+// GuestPCIAddress represents a PCI address
+type GuestPCIAddress struct {
+	Domain   int `json:"domain"`
+	Bus      int `json:"bus"`
+	Slot     int `json:"slot"`
+	Function int `json:"function"`
+}
+
+// GuestDiskAddress represents disk address information
+type GuestDiskAddress struct {
+	PCIController GuestPCIAddress  `json:"pci-controller"`
+	BusType       GuestDiskBusType `json:"bus-type"`
+	Bus           int              `json:"bus"`
+	Target        int              `json:"target"`
+	Unit          int              `json:"unit"`
+	Serial        string           `json:"serial,omitempty"`
+	Dev           string           `json:"dev,omitempty"`
+}
+
+// GuestFilesystemInfo represents filesystem information
+type GuestFilesystemInfo struct {
+	Name                 string             `json:"name"`
+	Mountpoint           string             `json:"mountpoint"`
+	Type                 string             `json:"type"`
+	UsedBytes            int64              `json:"used-bytes,omitempty"`
+	TotalBytes           int64              `json:"total-bytes,omitempty"`
+	TotalBytesPrivileged int64              `json:"total-bytes-privileged,omitempty"`
+	Disk                 []GuestDiskAddress `json:"disk"`
+}
+
+// GuestMemoryBlock represents a memory block
+type GuestMemoryBlock struct {
+	PhysIndex  int  `json:"phys-index"`
+	Online     bool `json:"online"`
+	CanOffline bool `json:"can-offline,omitempty"`
+}
+
+// GuestMemoryBlockInfo represents memory block information
+type GuestMemoryBlockInfo struct {
+	Size int64 `json:"size"`
+}
+
+// GuestDiskInfo represents disk information
+type GuestDiskInfo struct {
+	Name         string               `json:"name"`
+	Partition    bool                 `json:"partition"`
+	Dependencies []string             `json:"dependencies,omitempty"`
+	Address      *GuestDiskAddress    `json:"address,omitempty"`
+	Alias        string               `json:"alias,omitempty"`
+	HasMedia     bool                 `json:"has-media,omitempty"`
+	Size         int64                `json:"size,omitempty"`
+	Partitions   []GuestPartitionInfo `json:"partitions,omitempty"`
+}
+
+// GuestPartitionInfo represents partition information
+type GuestPartitionInfo struct {
+	Number int    `json:"number"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// GuestSSHInfo represents SSH public key information
+type GuestSSHInfo struct {
+	Keys []string `json:"keys"`
+}
+
+// GuestSSHAddKeysArgs represents arguments for guest-ssh-add-authorized-keys command
+type GuestSSHAddKeysArgs struct {
+	Username string   `json:"username"`
+	Keys     []string `json:"keys"`
+	Reset    bool     `json:"reset,omitempty"`
+}
+
+// GuestSSHRemoveKeysArgs represents arguments for guest-ssh-remove-authorized-keys command
+type GuestSSHRemoveKeysArgs struct {
+	Username string   `json:"username"`
+	Keys     []string `json:"keys"`
+}
+
+// GuestSSHGetKeysArgs represents arguments for guest-ssh-get-authorized-keys command
+type GuestSSHGetKeysArgs struct {
+	Username string `json:"username"`
+}
+
+// GuestSetUserPasswordArgs represents arguments for guest-set-user-password command
+type GuestSetUserPasswordArgs struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Crypted  bool   `json:"crypted"`
+}
+
+// FstrimArgs represents arguments for fstrim command
+type FstrimArgs struct {
+	Minimum int64 `json:"minimum,omitempty"`
+}
+
+// FreezeListArgs represents arguments for fsfreeze-freeze-list command
+type FreezeListArgs struct {
+	Mountpoints []string `json:"mountpoints,omitempty"`
+}
+
+// GetTimeResponse represents the response for get-time command
+type GetTimeResponse struct {
+	Time int64 `json:"time"`
+}
+
+// SetTimeArgs represents arguments for set-time command
+type SetTimeArgs struct {
+	Time int64 `json:"time,omitempty"`
+}
+
+// GuestExecCaptureOutputMode selects which output streams guest-exec captures
+type GuestExecCaptureOutputMode string
+
+const (
+	GuestExecCaptureOutputNone      GuestExecCaptureOutputMode = "none"
+	GuestExecCaptureOutputStdout    GuestExecCaptureOutputMode = "stdout"
+	GuestExecCaptureOutputStderr    GuestExecCaptureOutputMode = "stderr"
+	GuestExecCaptureOutputSeparated GuestExecCaptureOutputMode = "separated"
+	GuestExecCaptureOutputMerged    GuestExecCaptureOutputMode = "merged"
+)
+
+// GuestExecCaptureOutput is either a boolean or a GuestExecCaptureOutputMode;
+// true is equivalent to "separated" and false to "none"
+type GuestExecCaptureOutput struct {
+	Mode GuestExecCaptureOutputMode
+}
+
+// UnmarshalJSON accepts both the boolean and the enum form
+func (c *GuestExecCaptureOutput) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		c.Mode = GuestExecCaptureOutputNone
+		if enabled {
+			c.Mode = GuestExecCaptureOutputSeparated
+		}
+		return nil
+	}
+
+	var mode GuestExecCaptureOutputMode
+	if err := json.Unmarshal(data, &mode); err != nil {
+		return fmt.Errorf("capture-output must be a boolean or a string")
+	}
+	switch mode {
+	case GuestExecCaptureOutputNone, GuestExecCaptureOutputStdout, GuestExecCaptureOutputStderr,
+		GuestExecCaptureOutputSeparated, GuestExecCaptureOutputMerged:
+		c.Mode = mode
+		return nil
+	}
+	return fmt.Errorf("invalid capture-output mode %q", mode)
+}
+
+// MarshalJSON always emits the enum form
+func (c GuestExecCaptureOutput) MarshalJSON() ([]byte, error) {
+	if c.Mode == "" {
+		return json.Marshal(GuestExecCaptureOutputNone)
+	}
+	return json.Marshal(c.Mode)
+}
+
+// GuestExecArgs represents arguments for guest-exec command
+type GuestExecArgs struct {
+	Path          string                  `json:"path"`
+	Arg           []string                `json:"arg,omitempty"`
+	Env           []string                `json:"env,omitempty"`
+	InputData     string                  `json:"input-data,omitempty"`
+	CaptureOutput *GuestExecCaptureOutput `json:"capture-output,omitempty"`
+}
+
+// GuestExec represents the response for guest-exec command
+type GuestExec struct {
+	PID int `json:"pid"`
+}
+
+// GuestExecStatusArgs represents arguments for guest-exec-status command
+type GuestExecStatusArgs struct {
+	PID int `json:"pid"`
+}
+
+// GuestExecStatus represents the response for guest-exec-status command
+type GuestExecStatus struct {
+	Exited       bool   `json:"exited"`
+	ExitCode     *int   `json:"exitcode,omitempty"`
+	Signal       *int   `json:"signal,omitempty"`
+	OutData      string `json:"out-data,omitempty"`
+	ErrData      string `json:"err-data,omitempty"`
+	OutTruncated bool   `json:"out-truncated,omitempty"`
+	ErrTruncated bool   `json:"err-truncated,omitempty"`
+}
+
+// GuestFileOpenArgs represents arguments for guest-file-open command
+type GuestFileOpenArgs struct {
+	Path string `json:"path"`
+	Mode string `json:"mode,omitempty"`
+}
+
+// GuestFileHandleArgs represents arguments for commands taking only a file handle
+type GuestFileHandleArgs struct {
+	Handle int64 `json:"handle"`
+}
+
+// GuestFileReadArgs represents arguments for guest-file-read command
+type GuestFileReadArgs struct {
+	Handle int64  `json:"handle"`
+	Count  *int64 `json:"count,omitempty"`
+}
+
+// GuestFileRead represents the response for guest-file-read command
+type GuestFileRead struct {
+	Count  int    `json:"count"`
+	BufB64 string `json:"buf-b64"`
+	EOF    bool   `json:"eof"`
+}
+
+// GuestFileWriteArgs represents arguments for guest-file-write command
+type GuestFileWriteArgs struct {
+	Handle int64  `json:"handle"`
+	BufB64 string `json:"buf-b64"`
+	Count  *int64 `json:"count,omitempty"`
+}
+
+// GuestFileWrite represents the response for guest-file-write command
+type GuestFileWrite struct {
+	Count int  `json:"count"`
+	EOF   bool `json:"eof"`
+}
+
+// GuestFileWhence is the reference point of guest-file-seek; it is either an
+// integer (0, 1 or 2) or one of "set", "cur" and "end"
+type GuestFileWhence int
+
+const (
+	GuestFileWhenceSet GuestFileWhence = 0
+	GuestFileWhenceCur GuestFileWhence = 1
+	GuestFileWhenceEnd GuestFileWhence = 2
+)
+
+// UnmarshalJSON accepts both the integer and the enum form
+func (w *GuestFileWhence) UnmarshalJSON(data []byte) error {
+	var value int
+	if err := json.Unmarshal(data, &value); err == nil {
+		*w = GuestFileWhence(value)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("whence must be an integer or a string")
+	}
+	switch name {
+	case "set":
+		*w = GuestFileWhenceSet
+	case "cur":
+		*w = GuestFileWhenceCur
+	case "end":
+		*w = GuestFileWhenceEnd
+	default:
+		return fmt.Errorf("invalid whence %q", name)
+	}
+	return nil
+}
+
+// GuestFileSeekArgs represents arguments for guest-file-seek command
+type GuestFileSeekArgs struct {
+	Handle int64           `json:"handle"`
+	Offset int64           `json:"offset"`
+	Whence GuestFileWhence `json:"whence"`
+}
+
+// GuestFileSeek represents the response for guest-file-seek command
+type GuestFileSeek struct {
+	Position int64 `json:"position"`
+	EOF      bool  `json:"eof"`
+}
+
+// EmptyResponse is used for commands that return no data, resulting in `{}`.
+type EmptyResponse struct{}
+
+// Schema meta-types reported by guest-query-schema, following QMP's
+// query-qmp-schema.
+const (
+	SchemaMetaTypeCommand   = "command"
+	SchemaMetaTypeObject    = "object"
+	SchemaMetaTypeEnum      = "enum"
+	SchemaMetaTypeArray     = "array"
+	SchemaMetaTypeAlternate = "alternate"
+	SchemaMetaTypeBuiltin   = "builtin"
+)
+
+// SchemaInfoCommand represents a command entity of guest-query-schema
+type SchemaInfoCommand struct {
+	Name     string `json:"name"`
+	MetaType string `json:"meta-type"`
+	ArgType  string `json:"arg-type"`
+	RetType  string `json:"ret-type"`
+	Support  string `json:"support"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// SchemaInfoObjectMember represents a member of an object entity
+type SchemaInfoObjectMember struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// SchemaInfoObject represents an object entity of guest-query-schema
+type SchemaInfoObject struct {
+	Name     string                   `json:"name"`
+	MetaType string                   `json:"meta-type"`
+	Members  []SchemaInfoObjectMember `json:"members"`
+}
+
+// SchemaInfoEnum represents an enum entity of guest-query-schema
+type SchemaInfoEnum struct {
+	Name     string   `json:"name"`
+	MetaType string   `json:"meta-type"`
+	Values   []string `json:"values"`
+}
+
+// SchemaInfoArray represents an array entity of guest-query-schema
+type SchemaInfoArray struct {
+	Name        string `json:"name"`
+	MetaType    string `json:"meta-type"`
+	ElementType string `json:"element-type"`
+}
+
+// SchemaInfoAlternateMember represents one branch of an alternate entity
+type SchemaInfoAlternateMember struct {
+	Type string `json:"type"`
+}
+
+// SchemaInfoAlternate represents an alternate entity of guest-query-schema,
+// a value that may have any one of several types
+type SchemaInfoAlternate struct {
+	Name     string                      `json:"name"`
+	MetaType string                      `json:"meta-type"`
+	Members  []SchemaInfoAlternateMember `json:"members"`
+}
+
+// SchemaInfoBuiltin represents a builtin type of guest-query-schema
+type SchemaInfoBuiltin struct {
+	Name     string `json:"name"`
+	MetaType string `json:"meta-type"`
+	JSONType string `json:"json-type"`
+}
+
+// Helper function to create error response
+func NewErrorResponse(class, desc string) *QMPResponse {
+	return &QMPResponse{
+		Error: &QMPError{
+			Class: class,
+			Desc:  desc,
+		},
+	}
+}
+
+// Helper function to create success response
+func NewSuccessResponse(data interface{}) *QMPResponse {
+	return &QMPResponse{
+		Return: data,
+	}
+}
+
+// Helper function to parse request arguments
+func ParseArguments(args interface{}, target interface{}) error {
+	if args == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, target)
+}
+
+// ParseRequest parses JSON data into QMPRequest
+func ParseRequest(data []byte) (*QMPRequest, error) {
+	var request QMPRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// MarshalResponse marshals QMPResponse into JSON data
+func MarshalResponse(response *QMPResponse) ([]byte, error) {
+	return json.Marshal(response)
+}